@@ -2,15 +2,11 @@ package controller
 
 import (
 	"context"
-	"github.com/xh-polaris/gopkg/util"
-	"github.com/xh-polaris/psych-profile/pkg/errorx"
-	"go.opentelemetry.io/otel/trace"
 
 	"github.com/google/wire"
 	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
 	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
 	"github.com/xh-polaris/psych-profile/biz/application/service"
-	"github.com/xh-polaris/psych-profile/pkg/logs"
 )
 
 var _ IConfigController = (*ConfigController)(nil)
@@ -31,19 +27,13 @@ var ConfigControllerSet = wire.NewSet(
 )
 
 func (c *ConfigController) ConfigCreate(ctx context.Context, req *profile.ConfigCreateOrUpdateReq) (resp *basic.Response, err error) {
-	resp, err = c.ConfigService.ConfigCreate(ctx, req)
-	logs.CtxInfof(ctx, "req=%s, resp=%s, err=%s, trace=%s", util.JSONF(req), util.JSONF(resp), errorx.ErrorWithoutStack(err), trace.SpanContextFromContext(ctx).TraceID().String())
-	return resp, err
+	return c.ConfigService.ConfigCreate(ctx, req)
 }
 
 func (c *ConfigController) ConfigUpdateInfo(ctx context.Context, req *profile.ConfigCreateOrUpdateReq) (resp *basic.Response, err error) {
-	resp, err = c.ConfigService.ConfigUpdateInfo(ctx, req)
-	logs.CtxInfof(ctx, "req=%s, resp=%s, err=%s, trace=%s", util.JSONF(req), util.JSONF(resp), errorx.ErrorWithoutStack(err), trace.SpanContextFromContext(ctx).TraceID().String())
-	return resp, err
+	return c.ConfigService.ConfigUpdateInfo(ctx, req)
 }
 
 func (c *ConfigController) ConfigGetByUnitID(ctx context.Context, req *profile.ConfigGetByUnitIdReq) (resp *profile.ConfigGetByUnitIdResp, err error) {
-	resp, err = c.ConfigService.ConfigGetByUnitID(ctx, req)
-	logs.CtxInfof(ctx, "req=%s, resp=%s, err=%s, trace=%s", util.JSONF(req), util.JSONF(resp), errorx.ErrorWithoutStack(err), trace.SpanContextFromContext(ctx).TraceID().String())
-	return resp, err
+	return c.ConfigService.ConfigGetByUnitID(ctx, req)
 }
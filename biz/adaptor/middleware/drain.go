@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/xh-polaris/psych-profile/biz/infra/graceful"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+)
+
+// DrainMiddleware 跟踪进行中的请求, 开始排空后拒绝复用连接上的新请求
+func DrainMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, req, resp interface{}) error {
+		// 先计数再检查, 避免排空开始时漏掉刚通过检查的请求
+		defer graceful.Begin()()
+		if graceful.Draining() {
+			return errorx.New(errno.ErrServiceUnavailable)
+		}
+		return next(ctx, req, resp)
+	}
+}
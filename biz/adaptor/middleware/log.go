@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// codeUnknown 非StatusError的错误码
+const codeUnknown = -1
+
+// LogMiddleware 统一记录接口的方法名、耗时、错误码、脱敏后的出入参及链路ID
+// 失败和慢请求总是记录, 成功请求按采样率记录
+func LogMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, req, resp interface{}) error {
+		start := time.Now()
+		err := next(ctx, req, resp)
+		latency := time.Since(start)
+
+		c := config.GetConfig().RPCLog
+		code, stable := statusOf(err)
+		slow := c.SlowThreshold > 0 && latency >= c.SlowThreshold
+		if err == nil && !slow && rand.Float64() >= c.SampleRate {
+			return err
+		}
+
+		method := methodOf(ctx)
+		ctx = logs.WithFields(ctx, "method", method, "latency_ms", latency.Milliseconds(), "code", code)
+		format := "[%s] latency=%dms, code=%d, trace=%s, req=%s, resp=%s, err=%s"
+		args := []any{method, latency.Milliseconds(), code, meta.TraceID(ctx), logs.Redact(argOf(req)), logs.Redact(resultOf(resp)), errorx.ErrorWithoutStack(err)}
+		switch {
+		case err != nil && !stable:
+			logs.CtxErrorf(ctx, format, args...)
+		case err != nil || slow:
+			logs.CtxWarnf(ctx, format, args...)
+		default:
+			logs.CtxInfof(ctx, format, args...)
+		}
+		return err
+	}
+}
+
+// statusOf 获得错误码以及错误是否属于正常的业务错误
+func statusOf(err error) (int32, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var se errorx.StatusError
+	if errors.As(err, &se) {
+		return se.Code(), !se.IsAffectStability()
+	}
+	return codeUnknown, false
+}
+
+func methodOf(ctx context.Context) string {
+	if ri := rpcinfo.GetRPCInfo(ctx); ri != nil {
+		return ri.To().Method()
+	}
+	return ""
+}
+
+func argOf(req interface{}) interface{} {
+	if args, ok := req.(utils.KitexArgs); ok {
+		return args.GetFirstArgument()
+	}
+	return req
+}
+
+func resultOf(resp interface{}) interface{} {
+	if result, ok := resp.(utils.KitexResult); ok {
+		return result.GetResult()
+	}
+	return resp
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+)
+
+// MetricMiddleware 按方法记录请求数和耗时, 影响稳定性的错误及未知错误计入系统错误
+func MetricMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, req, resp interface{}) error {
+		start := time.Now()
+		err := next(ctx, req, resp)
+		code, stable := statusOf(err)
+		metrics.ObserveRPC(methodOf(ctx), code, !stable, time.Since(start))
+		return err
+	}
+}
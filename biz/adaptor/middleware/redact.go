@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// 字段名规则之外的日志脱敏配置
+func init() {
+	// 单位名称和AI配置名称不是个人信息
+	unit := logs.Policy{Allow: []string{"unit.name"}}
+	logs.RegisterPolicy(profile.UnitSignUpReq{}, unit)
+	logs.RegisterPolicy(profile.UnitSignUpResp{}, unit)
+	logs.RegisterPolicy(profile.UnitGetInfoResp{}, unit)
+	logs.RegisterPolicy(profile.UnitUpdateInfoReq{}, unit)
+
+	config := logs.Policy{Allow: []string{"config.chat.name", "config.tts.name", "config.report.name"}}
+	logs.RegisterPolicy(profile.ConfigCreateOrUpdateReq{}, config)
+	logs.RegisterPolicy(profile.ConfigGetByUnitIdResp{}, config)
+
+	// 导出结果即用户的全部个人数据
+	logs.RegisterPolicy(dto.UserDataExportResp{}, logs.Policy{Deny: []string{"data"}})
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/adaptor/controller"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// 测试用的敏感值, 脱敏后不应出现在日志中
+const (
+	id          = "65f000000000000000000001"
+	password    = "Pwd#secret1"
+	verifyCode  = "246810"
+	phone       = "13812345678"
+	phoneMasked = "138****5678"
+	code        = "13900001111"
+	codeMasked  = "139****1111"
+	studentNo   = "S2024001"
+	name        = "张三"
+	contactName = "李四"
+	relation    = "母亲"
+	appId       = "app-credential-x"
+	secret      = "whsec-credential"
+	unitName    = "第一中学"
+	configName  = "心理陪伴"
+)
+
+var birth int64 = 1104508800
+
+func testUser() *profile.User {
+	return &profile.User{
+		Id:       id,
+		CodeType: "phone",
+		Code:     code,
+		Password: password,
+		UnitId:   id,
+		Name:     name,
+		Birth:    birth,
+		Gender:   "male",
+	}
+}
+
+func testUnit() *profile.Unit {
+	return &profile.Unit{Id: id, Phone: phone, Password: password, Name: unitName, Address: "北京", Contact: contactName}
+}
+
+func testConfig() *profile.Config {
+	return &profile.Config{
+		UnitId: id,
+		Type:   "chain",
+		Chat:   &profile.ChatApp{Name: configName, Provider: "coze", AppId: appId},
+		Tts:    &profile.TTSApp{Name: configName, Provider: "volc", AppId: appId},
+		Report: &profile.ReportApp{Name: configName, Provider: "coze", AppId: appId},
+	}
+}
+
+func testContacts() []*dto.Contact {
+	return []*dto.Contact{{Type: "guardian", Name: contactName, Relation: relation, Phone: phone, Priority: 1}}
+}
+
+func testPage() *basic.PaginationOptions {
+	p, l := int64(1), int64(10)
+	return &basic.PaginationOptions{Page: &p, Limit: &l}
+}
+
+// redactCases 覆盖所有接口的请求类型
+// hidden为不应出现的原值, shown为脱敏后应保留的内容
+var redactCases = []struct {
+	req    any
+	hidden []string
+	shown  []string
+}{
+	// 用户
+	{&profile.UserSignUpReq{User: testUser()}, []string{password, code, name, strconv.FormatInt(birth, 10)}, []string{codeMasked, id}},
+	{&profile.UserSignInReq{UnitId: id, AuthType: 1, AuthId: phone, VerifyCode: verifyCode}, []string{phone, verifyCode}, []string{phoneMasked, id}},
+	{&profile.UserGetInfoReq{UserId: id}, nil, []string{id}},
+	{&profile.UserUpdateInfoReq{User: testUser()}, []string{password, code, name, strconv.FormatInt(birth, 10)}, []string{codeMasked, id}},
+	{&profile.UserUpdatePasswordReq{Id: id, VerifyCode: verifyCode, NewPassword: password}, []string{verifyCode, password}, []string{id}},
+	{&dto.UserContactListReq{UserId: id}, nil, []string{id}},
+	{&dto.UserContactUpdateReq{UserId: id, Contacts: testContacts()}, []string{contactName, relation, phone}, []string{phoneMasked, id}},
+	{&dto.UserPseudonymResolveReq{Pseudonym: "p-1"}, nil, []string{"p-1"}},
+	// 单位
+	{&profile.UnitSignUpReq{Unit: testUnit()}, []string{phone, password, contactName}, []string{phoneMasked, unitName}},
+	{&profile.UnitSignInReq{AuthType: 1, AuthId: phone, VerifyCode: verifyCode}, []string{phone, verifyCode}, []string{phoneMasked}},
+	{&profile.UnitGetInfoReq{UnitId: id}, nil, []string{id}},
+	{&profile.UnitUpdateInfoReq{Unit: testUnit()}, []string{phone, password, contactName}, []string{phoneMasked, unitName}},
+	{&profile.UnitUpdatePasswordReq{Id: id, VerifyCode: verifyCode, NewPassword: password}, []string{verifyCode, password}, []string{id}},
+	{&profile.UnitLinkUserReq{UnitId: id, UserId: id}, nil, []string{id}},
+	{
+		&profile.UnitCreateAndLinkUserReq{UnitId: id, CodeType: "studentId", Users: []*profile.User{testUser(), {Code: studentNo, Password: password, Name: name}}},
+		[]string{password, code, studentNo, name, strconv.FormatInt(birth, 10)},
+		[]string{codeMasked, id},
+	},
+	{&dto.UnitAgePolicyGetReq{UnitId: id}, nil, []string{id}},
+	{&dto.UnitAgePolicyUpdateReq{UnitId: id, AgePolicy: &dto.AgePolicy{MinAge: 12, GuardianAge: 18}}, nil, []string{id, `"guardianAge":18`}},
+	// 配置
+	{&profile.ConfigCreateOrUpdateReq{Config: testConfig(), Admin: true}, []string{appId}, []string{configName, id}},
+	{&profile.ConfigGetByUnitIdReq{UnitId: id}, nil, []string{id}},
+	{&dto.ConfigRevisionListReq{UnitId: id, PaginationOptions: testPage()}, nil, []string{id}},
+	{&dto.ConfigRevisionDiffReq{UnitId: id, From: 1, To: 2}, nil, []string{id}},
+	{&dto.ConfigRollbackReq{UnitId: id, Number: 1}, nil, []string{id}},
+	// 同意书
+	{&dto.ConsentPublishReq{UnitId: id, Type: "privacy", Title: "隐私政策", Content: "正文"}, nil, []string{id, "正文"}},
+	{&dto.ConsentGetLatestReq{UnitId: id, Type: "privacy"}, nil, []string{id}},
+	{
+		&dto.ConsentAcceptReq{UserId: id, Type: "privacy", Version: 1, Acceptor: "guardian", AcceptorName: contactName, Relation: relation},
+		[]string{contactName, relation},
+		[]string{id, "guardian"},
+	},
+	{&dto.ConsentRevokeReq{UserId: id, Type: "privacy"}, nil, []string{id}},
+	{&dto.ConsentCheckReq{UserId: id, Feature: "chat"}, nil, []string{id}},
+	{&dto.ConsentListRecordReq{UserId: id}, nil, []string{id}},
+	// 个人数据
+	{&dto.UserDataExportReq{UserId: id}, nil, []string{id}},
+	{&dto.UserDataEraseReq{UserId: id}, nil, []string{id}},
+	// 审计
+	{&dto.AuditQueryReq{UnitId: id, Actor: "admin", Entity: "user", PaginationOptions: testPage()}, nil, []string{id}},
+	// 订阅
+	{&dto.WebhookCreateReq{UnitId: id, Url: "https://example.com/hook", Types: []string{"user.created"}, Secret: secret}, []string{secret}, []string{id, "https://example.com/hook"}},
+	{&dto.WebhookListReq{UnitId: id}, nil, []string{id}},
+	{&dto.WebhookDeleteReq{UnitId: id, Id: id}, nil, []string{id}},
+	{&dto.WebhookTestReq{UnitId: id, Id: id}, nil, []string{id}},
+	{&dto.WebhookDeliveryListReq{UnitId: id, WebhookId: id, PaginationOptions: testPage()}, nil, []string{id}},
+	{&dto.WebhookReplayReq{UnitId: id, DeliveryId: id}, nil, []string{id}},
+	// 健康检查
+	{&dto.HealthCheckReq{Service: "profile"}, nil, []string{"profile"}},
+}
+
+func TestRedactRequests(t *testing.T) {
+	for _, tt := range redactCases {
+		t.Run(reflect.TypeOf(tt.req).Elem().Name(), func(t *testing.T) {
+			out := logs.Redact(tt.req)
+			for _, h := range tt.hidden {
+				assert.NotContains(t, out, h)
+			}
+			for _, s := range tt.shown {
+				assert.Contains(t, out, s)
+			}
+		})
+	}
+}
+
+// 新增接口时需要在redactCases中补充对应的请求
+func TestRedactCoversAllRequests(t *testing.T) {
+	covered := map[reflect.Type]bool{}
+	for _, tt := range redactCases {
+		covered[reflect.TypeOf(tt.req)] = true
+	}
+	controllers := []any{
+		(*controller.IUserController)(nil),
+		(*controller.IUnitController)(nil),
+		(*controller.IConfigController)(nil),
+	}
+	for _, c := range controllers {
+		it := reflect.TypeOf(c).Elem()
+		for i := 0; i < it.NumMethod(); i++ {
+			m := it.Method(i)
+			req := m.Type.In(1)
+			assert.True(t, covered[req], "%s.%s: %s not covered", it.Name(), m.Name, req)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/xh-polaris/psych-profile/biz/adaptor/controller"
 )
 
+// Server 只挂载psych-idl中已定义的接口, psychprofileservice仅分发IDL中的方法
+// 尚未进入IDL的接口只保留service实现, IDL新增对应方法并重新生成后再挂载到controller
 type Server struct {
 	controller.IUserController
 	controller.IUnitController
@@ -0,0 +1,40 @@
+package dto
+
+import "github.com/xh-polaris/psych-idl/kitex_gen/basic"
+
+// Change 字段变更, Before和After为JSON编码后的值, 敏感字段不返回值
+type Change struct {
+	Field    string `json:"field,omitempty"`
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// Audit 审计记录
+type Audit struct {
+	Id         string    `json:"id,omitempty"`
+	UnitId     string    `json:"unitId,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	Entity     string    `json:"entity,omitempty"`
+	EntityId   string    `json:"entityId,omitempty"`
+	Diff       []*Change `json:"diff,omitempty"`
+	TraceId    string    `json:"traceId,omitempty"`
+	CreateTime int64     `json:"createTime,omitempty"`
+}
+
+// AuditQueryReq 查询审计记录, 时间范围为[StartTime, EndTime)的Unix秒
+type AuditQueryReq struct {
+	UnitId            string                   `json:"unitId,omitempty"`
+	Actor             string                   `json:"actor,omitempty"`
+	Entity            string                   `json:"entity,omitempty"` // user | unit | config
+	EntityId          string                   `json:"entityId,omitempty"`
+	StartTime         int64                    `json:"startTime,omitempty"`
+	EndTime           int64                    `json:"endTime,omitempty"`
+	PaginationOptions *basic.PaginationOptions `json:"paginationOptions,omitempty"`
+}
+
+type AuditQueryResp struct {
+	Audits []*Audit `json:"audits,omitempty"`
+	Total  int64    `json:"total,omitempty"`
+}
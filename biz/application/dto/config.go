@@ -0,0 +1,52 @@
+package dto
+
+import (
+	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+)
+
+// ConfigRevision 配置的一次修订, Active表示当前生效的修订
+type ConfigRevision struct {
+	Number     int64           `json:"number,omitempty"`
+	ConfigId   string          `json:"configId,omitempty"`
+	UnitId     string          `json:"unitId,omitempty"`
+	Config     *profile.Config `json:"config,omitempty"`
+	Author     string          `json:"author,omitempty"`
+	Action     string          `json:"action,omitempty"` // ConfigCreate | ConfigUpdateInfo | ConfigRollback | Baseline
+	RollbackOf int64           `json:"rollbackOf,omitempty"`
+	Active     bool            `json:"active,omitempty"`
+	CreateTime int64           `json:"createTime,omitempty"`
+}
+
+// ConfigRevisionListReq 按修订号倒序查询单位配置的修订
+type ConfigRevisionListReq struct {
+	UnitId            string                   `json:"unitId,omitempty"`
+	PaginationOptions *basic.PaginationOptions `json:"paginationOptions,omitempty"`
+}
+
+type ConfigRevisionListResp struct {
+	Revisions []*ConfigRevision `json:"revisions,omitempty"`
+	Total     int64             `json:"total,omitempty"`
+}
+
+// ConfigRevisionDiffReq 逐字段比较两个修订, Before为From中的值, After为To中的值
+type ConfigRevisionDiffReq struct {
+	UnitId string `json:"unitId,omitempty"`
+	From   int64  `json:"from,omitempty"`
+	To     int64  `json:"to,omitempty"`
+}
+
+type ConfigRevisionDiffResp struct {
+	Changes []*Change `json:"changes,omitempty"`
+}
+
+// ConfigRollbackReq 将配置恢复为某个修订的内容, 同时产生一个新的修订
+type ConfigRollbackReq struct {
+	UnitId string `json:"unitId,omitempty"`
+	Number int64  `json:"number,omitempty"`
+}
+
+type ConfigRollbackResp struct {
+	Revision *ConfigRevision `json:"revision,omitempty"`
+	Version  int64           `json:"version,omitempty"` // 回滚后配置的版本号
+}
@@ -0,0 +1,83 @@
+package dto
+
+// Consent 知情同意书
+type Consent struct {
+	Id         string `json:"id,omitempty"`
+	UnitId     string `json:"unitId,omitempty"`
+	Type       string `json:"type,omitempty"` // ai_counseling | recording | data_processing
+	Version    int32  `json:"version,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content,omitempty"`
+	CreateTime int64  `json:"createTime,omitempty"`
+}
+
+// Acceptance 同意书签署记录
+type Acceptance struct {
+	Id           string `json:"id,omitempty"`
+	UserId       string `json:"userId,omitempty"`
+	ConsentId    string `json:"consentId,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Version      int32  `json:"version,omitempty"`
+	Acceptor     string `json:"acceptor,omitempty"` // self | guardian
+	AcceptorName string `json:"acceptorName,omitempty"`
+	Relation     string `json:"relation,omitempty"`
+	Status       string `json:"status,omitempty"` // active | deleted
+	CreateTime   int64  `json:"createTime,omitempty"`
+	DeleteTime   int64  `json:"deleteTime,omitempty"`
+}
+
+// ConsentPublishReq 发布新版本同意书, 版本号自动递增
+type ConsentPublishReq struct {
+	UnitId  string `json:"unitId,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type ConsentPublishResp struct {
+	Consent *Consent `json:"consent,omitempty"`
+}
+
+type ConsentGetLatestReq struct {
+	UnitId string `json:"unitId,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+type ConsentGetLatestResp struct {
+	Consent *Consent `json:"consent,omitempty"`
+}
+
+// ConsentAcceptReq 用户本人或监护人签署同意书, Version须为当前最新版本
+type ConsentAcceptReq struct {
+	UserId       string `json:"userId,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Version      int32  `json:"version,omitempty"`
+	Acceptor     string `json:"acceptor,omitempty"`
+	AcceptorName string `json:"acceptorName,omitempty"`
+	Relation     string `json:"relation,omitempty"`
+}
+
+type ConsentRevokeReq struct {
+	UserId string `json:"userId,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+type ConsentListRecordReq struct {
+	UserId string `json:"userId,omitempty"`
+}
+
+type ConsentListRecordResp struct {
+	Acceptances []*Acceptance `json:"acceptances,omitempty"`
+}
+
+// ConsentCheckReq 供其他服务查询用户是否可以使用某项功能
+type ConsentCheckReq struct {
+	UserId  string `json:"userId,omitempty"`
+	Feature string `json:"feature,omitempty"` // chat | tts | report
+}
+
+type ConsentCheckResp struct {
+	Allowed    bool     `json:"allowed,omitempty"`
+	Restricted bool     `json:"restricted,omitempty"` // 因单位年龄规则被禁用
+	Missing    []string `json:"missing,omitempty"`    // 缺少的签署, 格式为 类型:签署人, 如 ai_counseling:guardian
+}
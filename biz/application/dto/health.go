@@ -0,0 +1,19 @@
+package dto
+
+// HealthCheckReq 与gRPC health协议一致, Service为空或readiness时检查就绪, liveness时检查存活
+type HealthCheckReq struct {
+	Service string `json:"service,omitempty"`
+}
+
+// HealthCheck 单项依赖的检查结果
+type HealthCheck struct {
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthCheckResp Status为SERVING或NOT_SERVING
+type HealthCheckResp struct {
+	Status string         `json:"status,omitempty"`
+	Checks []*HealthCheck `json:"checks,omitempty"`
+}
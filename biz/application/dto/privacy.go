@@ -0,0 +1,16 @@
+package dto
+
+// UserDataExportReq 导出用户的全部个人数据
+type UserDataExportReq struct {
+	UserId string `json:"userId,omitempty"`
+}
+
+// UserDataExportResp Data为JSON格式的导出文档
+type UserDataExportResp struct {
+	Data string `json:"data,omitempty"`
+}
+
+// UserDataEraseReq 匿名化用户, 只保留带删除时间的墓碑记录
+type UserDataEraseReq struct {
+	UserId string `json:"userId,omitempty"`
+}
@@ -0,0 +1,23 @@
+package dto
+
+// AgePolicy 单位的年龄规则
+type AgePolicy struct {
+	MinAge             int32    `json:"minAge,omitempty"`             // 注册及登录的最低年龄, 0表示不限制
+	GuardianAge        int32    `json:"guardianAge,omitempty"`        // 低于该年龄需要监护人签署同意书, 默认18
+	RestrictedAge      int32    `json:"restrictedAge,omitempty"`      // 低于该年龄禁用RestrictedFeatures, 0表示不限制
+	RestrictedFeatures []string `json:"restrictedFeatures,omitempty"` // chat | tts | report
+}
+
+type UnitAgePolicyGetReq struct {
+	UnitId string `json:"unitId,omitempty"`
+}
+
+type UnitAgePolicyGetResp struct {
+	AgePolicy *AgePolicy `json:"agePolicy,omitempty"`
+	Version   int64      `json:"version,omitempty"` // 单位当前的版本号, 更新年龄规则时通过metainfo传回
+}
+
+type UnitAgePolicyUpdateReq struct {
+	UnitId    string     `json:"unitId,omitempty"`
+	AgePolicy *AgePolicy `json:"agePolicy,omitempty"`
+}
@@ -0,0 +1,36 @@
+// Package dto 定义尚未同步到psych-idl中的接口出入参
+// idl更新后应替换为kitex_gen中的对应类型
+package dto
+
+// Contact 监护人及紧急联系人
+type Contact struct {
+	Type     string `json:"type,omitempty"` // guardian | emergency
+	Name     string `json:"name,omitempty"`
+	Relation string `json:"relation,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	Priority int32  `json:"priority,omitempty"`
+}
+
+type UserContactListReq struct {
+	UserId string `json:"userId,omitempty"`
+}
+
+type UserContactListResp struct {
+	Contacts []*Contact `json:"contacts,omitempty"`
+	Version  int64      `json:"version,omitempty"` // 用户当前的版本号, 更新联系人时通过metainfo传回
+}
+
+// UserContactUpdateReq 以Contacts整体替换用户的联系人列表
+type UserContactUpdateReq struct {
+	UserId   string     `json:"userId,omitempty"`
+	Contacts []*Contact `json:"contacts,omitempty"`
+}
+
+type UserPseudonymResolveReq struct {
+	Pseudonym string `json:"pseudonym,omitempty"`
+}
+
+type UserPseudonymResolveResp struct {
+	UserId string `json:"userId,omitempty"`
+	UnitId string `json:"unitId,omitempty"`
+}
@@ -0,0 +1,88 @@
+package dto
+
+import "github.com/xh-polaris/psych-idl/kitex_gen/basic"
+
+// Webhook 单位登记的事件订阅, Secret只在创建时返回
+type Webhook struct {
+	Id         string   `json:"id,omitempty"`
+	UnitId     string   `json:"unitId,omitempty"`
+	Url        string   `json:"url,omitempty"`
+	Types      []string `json:"types,omitempty"` // user.created | user.moved | user.deactivated | user.graduated | consent.revoked | config.changed
+	Secret     string   `json:"secret,omitempty"`
+	CreateTime int64    `json:"createTime,omitempty"`
+	UpdateTime int64    `json:"updateTime,omitempty"`
+}
+
+// Delivery 一次事件投递的记录, Body为发送的请求体
+type Delivery struct {
+	Id           string `json:"id,omitempty"`
+	WebhookId    string `json:"webhookId,omitempty"`
+	EventId      string `json:"eventId,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Body         string `json:"body,omitempty"`
+	Status       string `json:"status,omitempty"` // pending | delivered | dead
+	Attempts     int32  `json:"attempts,omitempty"`
+	NextTime     int64  `json:"nextTime,omitempty"`
+	ResponseCode int32  `json:"responseCode,omitempty"`
+	LastError    string `json:"lastError,omitempty"`
+	Replays      int32  `json:"replays,omitempty"`
+	CreateTime   int64  `json:"createTime,omitempty"`
+	UpdateTime   int64  `json:"updateTime,omitempty"`
+	DeliverTime  int64  `json:"deliverTime,omitempty"`
+}
+
+// WebhookCreateReq 登记订阅, Secret为空时自动生成
+// 投递请求带有 X-Timestamp 及 X-Signature: sha256=hex(HMAC-SHA256(Secret, X-Timestamp + "." + 请求体))
+type WebhookCreateReq struct {
+	UnitId string   `json:"unitId,omitempty"`
+	Url    string   `json:"url,omitempty"`
+	Types  []string `json:"types,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+type WebhookCreateResp struct {
+	Webhook *Webhook `json:"webhook,omitempty"`
+}
+
+type WebhookListReq struct {
+	UnitId string `json:"unitId,omitempty"`
+}
+
+type WebhookListResp struct {
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
+}
+
+// WebhookDeleteReq 删除订阅, 尚未完成的投递进入死信
+type WebhookDeleteReq struct {
+	UnitId string `json:"unitId,omitempty"`
+	Id     string `json:"id,omitempty"`
+}
+
+// WebhookTestReq 立即向订阅发送一个webhook.test事件, 不重试
+type WebhookTestReq struct {
+	UnitId string `json:"unitId,omitempty"`
+	Id     string `json:"id,omitempty"`
+}
+
+type WebhookTestResp struct {
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// WebhookDeliveryListReq 按时间倒序查询订阅的投递记录, Status为dead时即死信列表
+type WebhookDeliveryListReq struct {
+	UnitId            string                   `json:"unitId,omitempty"`
+	WebhookId         string                   `json:"webhookId,omitempty"`
+	Status            string                   `json:"status,omitempty"`
+	PaginationOptions *basic.PaginationOptions `json:"paginationOptions,omitempty"`
+}
+
+type WebhookDeliveryListResp struct {
+	Deliveries []*Delivery `json:"deliveries,omitempty"`
+	Total      int64       `json:"total,omitempty"`
+}
+
+// WebhookReplayReq 重新投递一条记录, 以相同的请求体重新开始重试, 重放死信后同一聚合被阻塞的后续记录恢复投递
+type WebhookReplayReq struct {
+	UnitId     string `json:"unitId,omitempty"`
+	DeliveryId string `json:"deliveryId,omitempty"`
+}
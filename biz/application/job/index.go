@@ -0,0 +1,66 @@
+package job
+
+import (
+	"context"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/acceptance"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/audit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/consent"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// Index 创建各集合声明的索引, 并报告与声明不一致的索引
+type Index struct {
+	UserMapper       user.IMongoMapper
+	UnitMapper       unit.IMongoMapper
+	ConfigMapper     config.IMongoMapper
+	ConsentMapper    consent.IMongoMapper
+	AcceptanceMapper acceptance.IMongoMapper
+	AuditMapper      audit.IMongoMapper
+	OutboxMapper     outbox.IMongoMapper
+	WebhookMapper    webhook.IMongoMapper
+	DeliveryMapper   delivery.IMongoMapper
+	RevisionMapper   revision.IMongoMapper
+}
+
+var IndexSet = wire.NewSet(
+	wire.Struct(new(Index), "*"),
+)
+
+// Run 不一致的索引只记录日志, 需要人工确认后处理
+func (i *Index) Run(ctx context.Context) error {
+	for _, ensure := range []func(context.Context) (*mapper.IndexReport, error){
+		i.UserMapper.EnsureIndexes,
+		i.UnitMapper.EnsureIndexes,
+		i.ConfigMapper.EnsureIndexes,
+		i.ConsentMapper.EnsureIndexes,
+		i.AcceptanceMapper.EnsureIndexes,
+		i.AuditMapper.EnsureIndexes,
+		i.OutboxMapper.EnsureIndexes,
+		i.WebhookMapper.EnsureIndexes,
+		i.DeliveryMapper.EnsureIndexes,
+		i.RevisionMapper.EnsureIndexes,
+	} {
+		report, err := ensure(ctx)
+		if err != nil {
+			logs.Errorf("ensure indexes error: %s", errorx.ErrorWithoutStack(err))
+			return err
+		}
+		if report.Drifted() {
+			logs.Warnf("index drift: %s", report)
+		} else {
+			logs.Infof("ensure indexes done: %s", report)
+		}
+	}
+	return nil
+}
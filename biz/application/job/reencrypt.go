@@ -0,0 +1,37 @@
+package job
+
+import (
+	"context"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// Reencrypt 密钥轮换后使用当前主密钥重新加密已有数据, 并为历史数据补全盲索引
+type Reencrypt struct {
+	UserMapper user.IMongoMapper
+	UnitMapper unit.IMongoMapper
+}
+
+var ReencryptSet = wire.NewSet(
+	wire.Struct(new(Reencrypt), "*"),
+)
+
+func (r *Reencrypt) Run(ctx context.Context) error {
+	count, err := r.UnitMapper.Reencrypt(ctx)
+	if err != nil {
+		logs.Errorf("reencrypt unit error: %s", errorx.ErrorWithoutStack(err))
+		return err
+	}
+	logs.Infof("reencrypt unit done, count=%d", count)
+
+	if count, err = r.UserMapper.Reencrypt(ctx); err != nil {
+		logs.Errorf("reencrypt user error: %s", errorx.ErrorWithoutStack(err))
+		return err
+	}
+	logs.Infof("reencrypt user done, count=%d", count)
+	return nil
+}
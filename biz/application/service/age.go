@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/age"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// agePolicyOf 获得单位生效的年龄规则, 未配置的项使用默认值
+func agePolicyOf(u *unit.Unit) *unit.AgePolicy {
+	policy := &unit.AgePolicy{}
+	if u != nil && u.AgePolicy != nil {
+		*policy = *u.AgePolicy
+	}
+	if policy.GuardianAge == 0 {
+		policy.GuardianAge = age.Adult
+	}
+	return policy
+}
+
+// loadAgePolicy 根据单位ID获得年龄规则, 未绑定单位的用户使用默认规则
+func loadAgePolicy(ctx context.Context, unitMapper unit.IMongoMapper, unitId primitive.ObjectID) (*unit.AgePolicy, error) {
+	if unitId.IsZero() {
+		return agePolicyOf(nil), nil
+	}
+	unitDAO, err := unitMapper.FindOne(ctx, unitId)
+	if err != nil {
+		logs.Errorf("find unit error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	return agePolicyOf(unitDAO), nil
+}
+
+// checkAge 校验出生日期是否合法以及是否满足单位的最低年龄
+// 未填写出生日期时, 只有单位要求了最低年龄才会拒绝
+func checkAge(birth int64, policy *unit.AgePolicy) error {
+	if birth == 0 {
+		if policy.MinAge > 0 {
+			return errorx.New(errno.ErrMissingParams, errorx.KV("field", "出生日期"))
+		}
+		return nil
+	}
+	now := time.Now()
+	if !age.Valid(birth, now) {
+		return errorx.New(errno.ErrInvalidBirth)
+	}
+	if int32(age.Of(birth, now)) < policy.MinAge {
+		return errorx.New(errno.ErrBelowMinimumAge, errorx.KV("age", strconv.Itoa(int(policy.MinAge))))
+	}
+	return nil
+}
+
+// needGuardian 用户是否需要监护人签署同意书, 未填写出生日期时无法确认已成年, 同样需要监护人签署
+func needGuardian(birth int64, policy *unit.AgePolicy) bool {
+	return birth == 0 || int32(age.Of(birth, time.Now())) < policy.GuardianAge
+}
+
+// isRestricted 用户是否因年龄被禁止使用某项功能, 未填写出生日期时无法确认已达到限制年龄, 同样禁用
+func isRestricted(birth int64, feature int, policy *unit.AgePolicy) bool {
+	if policy.RestrictedAge == 0 || !slices.Contains(policy.RestrictedFeatures, feature) {
+		return false
+	}
+	return birth == 0 || int32(age.Of(birth, time.Now())) < policy.RestrictedAge
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+)
+
+// 未填写出生日期时与needGuardian一样按受限处理, 单位未设置限制年龄时不限制
+func TestIsRestricted(t *testing.T) {
+	policy := &unit.AgePolicy{RestrictedAge: 12, RestrictedFeatures: []int{enum.FeatureTTS}}
+	tests := []struct {
+		name    string
+		birth   int64
+		feature int
+		policy  *unit.AgePolicy
+		want    bool
+	}{
+		{"adult", yearsAgo(20), enum.FeatureTTS, policy, false},
+		{"child", yearsAgo(10), enum.FeatureTTS, policy, true},
+		{"unknown birth", 0, enum.FeatureTTS, policy, true},
+		{"unrestricted feature", 0, enum.FeatureChat, policy, false},
+		{"no restricted age", 0, enum.FeatureTTS, &unit.AgePolicy{RestrictedFeatures: []int{enum.FeatureTTS}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRestricted(tt.birth, tt.feature, tt.policy))
+		})
+	}
+}
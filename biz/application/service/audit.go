@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/audit"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IAuditService = (*AuditService)(nil)
+
+type IAuditService interface {
+	AuditQuery(ctx context.Context, req *dto.AuditQueryReq) (*dto.AuditQueryResp, error)
+}
+
+// AuditService 记录并查询写操作的审计日志
+type AuditService struct {
+	AuditMapper audit.IMongoMapper
+}
+
+var AuditServiceSet = wire.NewSet(
+	wire.Struct(new(AuditService), "*"),
+	wire.Bind(new(IAuditService), new(*AuditService)),
+)
+
+// 被审计的实体, 与集合名一致
+const (
+	entityUser    = "user"
+	entityUnit    = "unit"
+	entityConfig  = "config"
+	entityWebhook = "webhook"
+)
+
+// redactedFields 只记录是否变更而不记录值的字段, 以小写的字段名匹配
+// 包括凭据类字段(密码、模型服务的appId), 以及落库时加密的个人信息和用户自定义选项
+var redactedFields = map[string]map[string]bool{
+	"":            {"password": true},
+	entityUser:    {"code": true, "codeindex": true, "name": true, "birth": true, "birthcipher": true, "contacts": true, "options": true},
+	entityUnit:    {"phone": true, "phoneindex": true, "contact": true},
+	entityConfig:  {"appid": true},
+	entityWebhook: {"secret": true},
+}
+
+// ignoredFields 不参与比较的字段
+var ignoredFields = map[string]bool{"_id": true, "updatetime": true}
+
+// Record 记录一次写操作, before和after可以是实体、更新文档或nil
+// after为更新文档时只比较其中出现的字段, 删除的字段以nil表示
+// 应在写操作所在的事务中调用, 审计失败时返回错误使写操作一并回滚
+func (a *AuditService) Record(ctx context.Context, action, entity string, unitId, entityId primitive.ObjectID, before, after any) error {
+	record := &audit.Audit{
+		ID:         primitive.NewObjectID(),
+		UnitID:     unitId,
+		Actor:      meta.Actor(ctx),
+		Action:     action,
+		Entity:     entity,
+		EntityID:   entityId,
+		TraceID:    meta.TraceID(ctx),
+		CreateTime: time.Now().Unix(),
+	}
+	var err error
+	if record.Diff, err = diff(entity, before, after); err != nil {
+		logs.CtxErrorf(ctx, "diff audit error: %s", errorx.ErrorWithoutStack(err))
+		return err
+	}
+	if err = a.AuditMapper.Insert(ctx, record); err != nil {
+		logs.CtxErrorf(ctx, "insert audit error: %s", errorx.ErrorWithoutStack(err))
+		return err
+	}
+	return nil
+}
+
+// AuditQuery 按单位、操作人、实体及时间范围查询审计记录, 仅管理员可用
+func (a *AuditService) AuditQuery(ctx context.Context, req *dto.AuditQueryReq) (*dto.AuditQueryResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "审计记录", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	filter := &audit.Filter{Actor: req.Actor, Entity: req.Entity, StartTime: req.StartTime, EndTime: req.EndTime}
+	var err error
+	if req.UnitId != "" {
+		if filter.UnitID, err = primitive.ObjectIDFromHex(req.UnitId); err != nil {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+		}
+	}
+	if req.EntityId != "" {
+		if filter.EntityID, err = primitive.ObjectIDFromHex(req.EntityId); err != nil {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "实体ID"))
+		}
+	}
+	if req.EndTime != 0 && req.StartTime > req.EndTime {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "时间范围"))
+	}
+	page := &mapper.PageOptions{}
+	if p := req.PaginationOptions; p != nil {
+		page.Page, page.Limit = p.GetPage(), p.GetLimit()
+	}
+
+	audits, total, err := a.AuditMapper.FindPage(ctx, filter, page)
+	if err != nil {
+		logs.Errorf("find audit error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	resp := &dto.AuditQueryResp{Audits: make([]*dto.Audit, 0, len(audits)), Total: total}
+	for _, r := range audits {
+		resp.Audits = append(resp.Audits, auditDTO(r))
+	}
+	return resp, nil
+}
+
+// diff 计算字段级变更, 嵌套文档以点号连接的字段名展开
+func diff(entity string, before, after any) ([]*audit.Change, error) {
+	b, err := flatten(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := flatten(after)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(a))
+	source := a
+	if after == nil {
+		source = b
+	}
+	for k := range source {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return compareFields(entity, b, a, keys), nil
+}
+
+// compareFields 比较展开后的文档中keys列出的字段, 未变更及忽略的字段不返回
+func compareFields(entity string, b, a bson.M, keys []string) []*audit.Change {
+	changes := make([]*audit.Change, 0, len(keys))
+	for _, k := range keys {
+		leaf := strings.ToLower(k[strings.LastIndex(k, ".")+1:])
+		if ignoredFields[leaf] || reflect.DeepEqual(b[k], a[k]) {
+			continue
+		}
+		if redactedFields[""][leaf] || redactedFields[entity][leaf] {
+			changes = append(changes, &audit.Change{Field: k, Redacted: true})
+			continue
+		}
+		changes = append(changes, &audit.Change{Field: k, Before: b[k], After: a[k]})
+	}
+	return changes
+}
+
+// flatten 将实体或更新文档转为单层的bson.M
+func flatten(v any) (bson.M, error) {
+	flat := bson.M{}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return flat, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	flattenInto(flat, "", doc)
+	return flat, nil
+}
+
+func flattenInto(flat bson.M, prefix string, doc bson.M) {
+	for k, v := range doc {
+		switch nested := v.(type) {
+		case bson.M:
+			flattenInto(flat, prefix+k+".", nested)
+		case primitive.D:
+			flattenInto(flat, prefix+k+".", nested.Map())
+		default:
+			flat[prefix+k] = v
+		}
+	}
+}
+
+// changesDTO 敏感字段只返回是否变更
+func changesDTO(diff []*audit.Change) []*dto.Change {
+	changes := make([]*dto.Change, 0, len(diff))
+	for _, c := range diff {
+		change := &dto.Change{Field: c.Field, Redacted: c.Redacted}
+		if !c.Redacted {
+			change.Before, change.After = jsonValue(c.Before), jsonValue(c.After)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func auditDTO(r *audit.Audit) *dto.Audit {
+	a := &dto.Audit{
+		Id:         r.ID.Hex(),
+		Actor:      r.Actor,
+		Action:     r.Action,
+		Entity:     r.Entity,
+		Diff:       changesDTO(r.Diff),
+		TraceId:    r.TraceID,
+		CreateTime: r.CreateTime,
+	}
+	if !r.UnitID.IsZero() {
+		a.UnitId = r.UnitID.Hex()
+	}
+	if !r.EntityID.IsZero() {
+		a.EntityId = r.EntityID.Hex()
+	}
+	return a
+}
+
+func jsonValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -2,16 +2,25 @@ package service
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/google/wire"
 	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
 	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
 	"github.com/xh-polaris/psych-profile/pkg/errorx"
 	"github.com/xh-polaris/psych-profile/pkg/logs"
 	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -22,10 +31,18 @@ type IConfigService interface {
 	ConfigCreate(ctx context.Context, req *profile.ConfigCreateOrUpdateReq) (resp *basic.Response, err error)
 	ConfigUpdateInfo(ctx context.Context, req *profile.ConfigCreateOrUpdateReq) (resp *basic.Response, err error)
 	ConfigGetByUnitID(ctx context.Context, req *profile.ConfigGetByUnitIdReq) (resp *profile.ConfigGetByUnitIdResp, err error)
+	ConfigRevisionList(ctx context.Context, req *dto.ConfigRevisionListReq) (*dto.ConfigRevisionListResp, error)
+	ConfigRevisionDiff(ctx context.Context, req *dto.ConfigRevisionDiffReq) (*dto.ConfigRevisionDiffResp, error)
+	ConfigRollback(ctx context.Context, req *dto.ConfigRollbackReq) (*dto.ConfigRollbackResp, error)
 }
 
 type ConfigService struct {
-	ConfigMapper config.IMongoMapper
+	ConfigMapper    config.IMongoMapper
+	RevisionMapper  revision.IMongoMapper
+	Transactor      mapper.Transactor
+	AuditService    *AuditService
+	EventService    *EventService
+	RevisionService *RevisionService
 }
 
 var ConfigServiceSet = wire.NewSet(
@@ -74,7 +91,18 @@ func (c *ConfigService) ConfigCreate(ctx context.Context, req *profile.ConfigCre
 		UpdateTime: now,
 	}
 	// 插入数据库
-	if err = c.ConfigMapper.Insert(ctx, confDAO); err != nil {
+	if err = c.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := c.ConfigMapper.Insert(ctx, confDAO); err != nil {
+			return err
+		}
+		if _, err := c.RevisionService.Record(ctx, confDAO, revision.ActionCreate, 0); err != nil {
+			return err
+		}
+		if err := c.EventService.Record(ctx, outbox.TypeConfigChanged, outbox.AggregateUnit, unitOID, unitOID, configChangedPayload(confDAO.ID, revision.ActionCreate)); err != nil {
+			return err
+		}
+		return c.AuditService.Record(ctx, "ConfigCreate", entityConfig, unitOID, confDAO.ID, nil, confDAO)
+	}); err != nil {
 		logs.Errorf("insert config error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
@@ -82,6 +110,7 @@ func (c *ConfigService) ConfigCreate(ctx context.Context, req *profile.ConfigCre
 	return &basic.Response{}, nil
 }
 
+// ConfigUpdateInfo 修改单位的配置, 不存在时创建, 修改已有配置时需要携带读取时的版本号
 func (c *ConfigService) ConfigUpdateInfo(ctx context.Context, req *profile.ConfigCreateOrUpdateReq) (resp *basic.Response, err error) {
 	// 鉴权
 	if !req.Admin {
@@ -104,11 +133,35 @@ func (c *ConfigService) ConfigUpdateInfo(ctx context.Context, req *profile.Confi
 	// 提取req中的非空字段，构造bson
 	update := extractUpdateBSON(req)
 
-	err = c.ConfigMapper.UpdateFields(ctx, oldConf.ID, update)
+	version, err := requireVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		// 引入修订前创建的配置先补记更新前的内容
+		if err := c.RevisionService.Baseline(ctx, oldConf); err != nil {
+			return err
+		}
+		if err := c.ConfigMapper.UpdateFieldsIfVersion(ctx, oldConf.ID, version, update); err != nil {
+			return err
+		}
+		newConf, err := c.ConfigMapper.FindOne(ctx, oldConf.ID)
+		if err != nil {
+			return err
+		}
+		if _, err = c.RevisionService.Record(ctx, newConf, revision.ActionUpdate, 0); err != nil {
+			return err
+		}
+		if err = c.EventService.Record(ctx, outbox.TypeConfigChanged, outbox.AggregateUnit, unitOid, unitOid, configChangedPayload(oldConf.ID, revision.ActionUpdate)); err != nil {
+			return err
+		}
+		return c.AuditService.Record(ctx, "ConfigUpdateInfo", entityConfig, unitOid, oldConf.ID, oldConf, update)
+	})
 	if err != nil {
 		logs.Errorf("update config error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
+	meta.SetVersion(ctx, version+1)
 
 	return &basic.Response{}, nil
 }
@@ -130,6 +183,7 @@ func (c *ConfigService) ConfigGetByUnitID(ctx context.Context, req *profile.Conf
 		logs.Errorf("find config error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
+	meta.SetVersion(ctx, configDAO.Version)
 	// 根据权限返回不同DTO
 	switch req.GetAdmin() {
 	case true:
@@ -145,6 +199,219 @@ func (c *ConfigService) ConfigGetByUnitID(ctx context.Context, req *profile.Conf
 	return nil, errorx.New(errno.ErrInternalError)
 }
 
+// ConfigRevisionList 按修订号倒序查询单位配置的修订, 仅管理员可用
+func (c *ConfigService) ConfigRevisionList(ctx context.Context, req *dto.ConfigRevisionListReq) (*dto.ConfigRevisionListResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "配置修订", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	conf, err := c.findUnitConfig(ctx, req.UnitId)
+	if err != nil {
+		return nil, err
+	}
+	page := &mapper.PageOptions{}
+	if p := req.PaginationOptions; p != nil {
+		page.Page, page.Limit = p.GetPage(), p.GetLimit()
+	}
+
+	revisions, total, err := c.RevisionMapper.FindPage(ctx, conf.ID, page)
+	if err != nil {
+		logs.Errorf("find config revisions error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	// 每次写入都产生修订, 最新的修订即为当前生效的配置
+	latest, err := c.RevisionMapper.FindLatest(ctx, conf.ID)
+	if err != nil {
+		logs.Errorf("find latest config revision error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	resp := &dto.ConfigRevisionListResp{Revisions: make([]*dto.ConfigRevision, 0, len(revisions)), Total: total}
+	for _, r := range revisions {
+		resp.Revisions = append(resp.Revisions, revisionDTO(r, latest != nil && r.Number == latest.Number))
+	}
+	return resp, nil
+}
+
+// ConfigRevisionDiff 逐字段比较两个修订的配置内容, 仅管理员可用
+func (c *ConfigService) ConfigRevisionDiff(ctx context.Context, req *dto.ConfigRevisionDiffReq) (*dto.ConfigRevisionDiffResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "配置修订", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	conf, err := c.findUnitConfig(ctx, req.UnitId)
+	if err != nil {
+		return nil, err
+	}
+	from, err := c.findRevision(ctx, conf.ID, req.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.findRevision(ctx, conf.ID, req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := flatten(revisionContent(from.Config))
+	if err != nil {
+		return nil, err
+	}
+	a, err := flatten(revisionContent(to.Config))
+	if err != nil {
+		return nil, err
+	}
+	// 两个修订中出现的字段都参与比较
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return &dto.ConfigRevisionDiffResp{Changes: changesDTO(compareFields(entityConfig, b, a, keys))}, nil
+}
+
+// ConfigRollback 将配置恢复为某个修订的内容并产生新的修订, 仅管理员可用
+// 与ConfigUpdateInfo一样需要携带读取时的版本号做并发控制
+func (c *ConfigService) ConfigRollback(ctx context.Context, req *dto.ConfigRollbackReq) (*dto.ConfigRollbackResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "配置修订", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	oldConf, err := c.findUnitConfig(ctx, req.UnitId)
+	if err != nil {
+		return nil, err
+	}
+	target, err := c.findRevision(ctx, oldConf.ID, req.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	content := revisionContent(target.Config)
+	update := bson.M{
+		cst.Type:       content.Type,
+		"chat":         content.Chat,
+		"tts":          content.TTS,
+		"report":       content.Report,
+		cst.Status:     content.Status,
+		cst.UpdateTime: time.Now().Unix(),
+	}
+	version, err := requireVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var newConf *config.Config
+	var latest *revision.Revision
+	if err = c.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := c.RevisionService.Baseline(ctx, oldConf); err != nil {
+			return err
+		}
+		if err := c.ConfigMapper.UpdateFieldsIfVersion(ctx, oldConf.ID, version, update); err != nil {
+			return err
+		}
+		var err error
+		if newConf, err = c.ConfigMapper.FindOne(ctx, oldConf.ID); err != nil {
+			return err
+		}
+		if latest, err = c.RevisionService.Record(ctx, newConf, revision.ActionRollback, target.Number); err != nil {
+			return err
+		}
+		if err = c.EventService.Record(ctx, outbox.TypeConfigChanged, outbox.AggregateUnit, oldConf.UnitID, oldConf.UnitID, configChangedPayload(oldConf.ID, revision.ActionRollback)); err != nil {
+			return err
+		}
+		return c.AuditService.Record(ctx, "ConfigRollback", entityConfig, oldConf.UnitID, oldConf.ID, oldConf, update)
+	}); err != nil {
+		logs.Errorf("rollback config error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	meta.SetVersion(ctx, newConf.Version)
+	return &dto.ConfigRollbackResp{Revision: revisionDTO(latest, true), Version: newConf.Version}, nil
+}
+
+// findUnitConfig 查询单位当前生效的配置
+func (c *ConfigService) findUnitConfig(ctx context.Context, unitIdHex string) (*config.Config, error) {
+	unitId, err := primitive.ObjectIDFromHex(unitIdHex)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+	conf, err := c.ConfigMapper.FindOneByUnitID(ctx, unitId)
+	if errors.Is(err, monc.ErrNotFound) {
+		return nil, errorx.New(errno.ErrNotFound, errorx.KV("field", "配置"))
+	} else if err != nil {
+		logs.Errorf("find config error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (c *ConfigService) findRevision(ctx context.Context, configId primitive.ObjectID, number int64) (*revision.Revision, error) {
+	r, err := c.RevisionMapper.FindByNumber(ctx, configId, number)
+	if errors.Is(err, monc.ErrNotFound) {
+		return nil, errorx.New(errno.ErrNotFound, errorx.KV("field", "配置修订"))
+	} else if err != nil {
+		logs.Errorf("find config revision error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	return r, nil
+}
+
+// revisionContent 修订中可回滚及参与比较的内容, 不包括ID、版本号及时间
+func revisionContent(conf *config.Config) *config.Config {
+	return &config.Config{
+		Type:   conf.Type,
+		Chat:   conf.Chat,
+		TTS:    conf.TTS,
+		Report: conf.Report,
+		Status: conf.Status,
+	}
+}
+
+// revisionDTO active表示该修订是否为配置当前的内容, 即最新的修订
+func revisionDTO(r *revision.Revision, active bool) *dto.ConfigRevision {
+	return &dto.ConfigRevision{
+		Number:     r.Number,
+		ConfigId:   r.ConfigID.Hex(),
+		UnitId:     r.UnitID.Hex(),
+		Config:     adminConfig(r.Config),
+		Author:     r.Author,
+		Action:     r.Action,
+		RollbackOf: r.RollbackOf,
+		Active:     active,
+		CreateTime: r.CreateTime,
+	}
+}
+
+// defaultConfig 以模板配置为基础构造单位的默认配置
+func defaultConfig(template *config.Config, unitId primitive.ObjectID) *config.Config {
+	now := time.Now().Unix()
+	conf := &config.Config{
+		ID:         primitive.NewObjectID(),
+		UnitID:     unitId,
+		Type:       template.Type,
+		Status:     enum.Active,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	if template.Chat != nil {
+		chat := *template.Chat
+		conf.Chat = &chat
+	}
+	if template.TTS != nil {
+		tts := *template.TTS
+		conf.TTS = &tts
+	}
+	if template.Report != nil {
+		report := *template.Report
+		conf.Report = &report
+	}
+	return conf
+}
+
 func validateCreateConfigReq(req *profile.ConfigCreateOrUpdateReq) error { // Deprecated
 	if req.Config == nil {
 		return errorx.New(errno.ErrMissingParams, errorx.KV("field", "配置内容"))
@@ -243,9 +510,8 @@ func extractUpdateBSON(req *profile.ConfigCreateOrUpdateReq) bson.M {
 			setUpdate["chat.provider"] = chat.GetProvider()
 		}
 		if chat.GetAppId() != "" {
-			setUpdate["chat.appid"] = chat.GetAppId()
+			setUpdate["chat.appId"] = chat.GetAppId()
 		}
-		setUpdate["chat.updatetime"] = now
 	}
 
 	// tts配置
@@ -260,12 +526,11 @@ func extractUpdateBSON(req *profile.ConfigCreateOrUpdateReq) bson.M {
 			setUpdate["tts.provider"] = tts.GetProvider()
 		}
 		if tts.GetAppId() != "" {
-			setUpdate["tts.appid"] = tts.GetAppId()
+			setUpdate["tts.appId"] = tts.GetAppId()
 		}
 		if tts.GetSpeaker() != "" {
 			setUpdate["tts.speaker"] = tts.GetSpeaker()
 		}
-		setUpdate["tts.updatetime"] = now
 	}
 
 	// report配置
@@ -280,13 +545,12 @@ func extractUpdateBSON(req *profile.ConfigCreateOrUpdateReq) bson.M {
 			setUpdate["report.provider"] = report.GetProvider()
 		}
 		if report.GetAppId() != "" {
-			setUpdate["report.appid"] = report.GetAppId()
+			setUpdate["report.appId"] = report.GetAppId()
 		}
-		setUpdate["report.updatetime"] = now
 	}
 
 	// 文档级更新时间
-	setUpdate["updatetime"] = now
+	setUpdate[cst.UpdateTime] = now
 
 	return setUpdate
 }
@@ -295,30 +559,41 @@ func extractUpdateBSON(req *profile.ConfigCreateOrUpdateReq) bson.M {
 func adminConfig(configDAO *config.Config) *profile.Config {
 	t, _ := enum.GetConfigType(configDAO.Type)
 	st, _ := enum.GetStatus(configDAO.Status)
+	// 由模板创建的配置可能缺少部分应用
+	chat, tts, report := configDAO.Chat, configDAO.TTS, configDAO.Report
+	if chat == nil {
+		chat = &config.Chat{}
+	}
+	if tts == nil {
+		tts = &config.TTS{}
+	}
+	if report == nil {
+		report = &config.Report{}
+	}
 	return &profile.Config{
 		UnitId: configDAO.UnitID.Hex(),
 		Type:   t,
 
 		Chat: &profile.ChatApp{
-			Name:        configDAO.Chat.Name,
-			Description: configDAO.Chat.Description,
-			Provider:    configDAO.Chat.Provider,
-			AppId:       configDAO.Chat.AppID,
+			Name:        chat.Name,
+			Description: chat.Description,
+			Provider:    chat.Provider,
+			AppId:       chat.AppID,
 		},
 
 		Tts: &profile.TTSApp{
-			Name:        configDAO.TTS.Name,
-			Description: configDAO.TTS.Description,
-			Provider:    configDAO.TTS.Provider,
-			AppId:       configDAO.TTS.AppID,
-			Speaker:     configDAO.TTS.Speaker,
+			Name:        tts.Name,
+			Description: tts.Description,
+			Provider:    tts.Provider,
+			AppId:       tts.AppID,
+			Speaker:     tts.Speaker,
 		},
 
 		Report: &profile.ReportApp{
-			Name:        configDAO.Report.Name,
-			Description: configDAO.Report.Description,
-			Provider:    configDAO.Report.Provider,
-			AppId:       configDAO.Report.AppID,
+			Name:        report.Name,
+			Description: report.Description,
+			Provider:    report.Provider,
+			AppId:       report.AppID,
 		},
 
 		Status:     st,
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testUnitConfig 单位的完整配置, name为对话应用的名称
+func testUnitConfig(unitId, name string) *profile.Config {
+	return &profile.Config{
+		UnitId: unitId,
+		Type:   "chain",
+		Chat:   &profile.ChatApp{Name: name, Description: "对话", Provider: "provider", AppId: "chat-app"},
+		Tts:    &profile.TTSApp{Name: "tts", Description: "语音", Provider: "provider", AppId: "tts-app", Speaker: "speaker"},
+		Report: &profile.ReportApp{Name: "report", Description: "报告", Provider: "provider", AppId: "report-app"},
+	}
+}
+
+func TestConfigUpdateInfo(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		admin bool
+		conf  *profile.Config
+		want  int
+	}{
+		{"not admin", context.Background(), false, testUnitConfig(unitId, "chat"), errno.ErrNotAdmin},
+		{"invalid unit", context.Background(), true, testUnitConfig("unit", "chat"), errno.ErrInvalidParams},
+		{"invalid type", context.Background(), true, &profile.Config{UnitId: unitId, Type: "unknown"}, errno.ErrInvalidParams},
+		// 不存在时当作创建处理
+		{"create", context.Background(), true, testUnitConfig(unitId, "chat"), 0},
+		// 修改已有配置必须携带版本号
+		{"missing version", context.Background(), true, &profile.Config{UnitId: unitId, Chat: &profile.ChatApp{Name: "unversioned"}}, errno.ErrMissingParams},
+		{"stale version", withVersion(context.Background(), 99), true, &profile.Config{UnitId: unitId, Chat: &profile.ChatApp{Name: "stale"}}, errno.ErrVersionConflict},
+		{"update", nil, true, &profile.Config{UnitId: unitId, Chat: &profile.ChatApp{Name: "chat-v2"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				// 携带读取到的当前版本号
+				ctx = withVersion(context.Background(), env.configVersion(t, unitId))
+			}
+			_, err := env.config.ConfigUpdateInfo(ctx, &profile.ConfigCreateOrUpdateReq{Config: tt.conf, Admin: tt.admin})
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	resp, err := env.config.ConfigGetByUnitID(context.Background(), &profile.ConfigGetByUnitIdReq{UnitId: unitId, Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, "chat-v2", resp.Config.Chat.Name)
+	// 未更新的字段保持不变
+	assert.Equal(t, "对话", resp.Config.Chat.Description)
+	assert.Equal(t, "speaker", resp.Config.Tts.Speaker)
+}
+
+// 非管理员查询时隐藏各应用的AppID
+func TestConfigGetByUnitID(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := primitive.NewObjectID().Hex()
+	_, err := env.config.ConfigUpdateInfo(context.Background(), &profile.ConfigCreateOrUpdateReq{Config: testUnitConfig(unitId, "chat"), Admin: true})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		req    *profile.ConfigGetByUnitIdReq
+		want   int
+		appIds [3]string
+	}{
+		{name: "missing unit", req: &profile.ConfigGetByUnitIdReq{}, want: errno.ErrMissingParams},
+		{name: "invalid unit", req: &profile.ConfigGetByUnitIdReq{UnitId: "unit"}, want: errno.ErrInvalidParams},
+		{name: "admin", req: &profile.ConfigGetByUnitIdReq{UnitId: unitId, Admin: true}, appIds: [3]string{"chat-app", "tts-app", "report-app"}},
+		{name: "public", req: &profile.ConfigGetByUnitIdReq{UnitId: unitId}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.config.ConfigGetByUnitID(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, tt.appIds, [3]string{resp.Config.Chat.AppId, resp.Config.Tts.AppId, resp.Config.Report.AppId})
+				assert.Equal(t, "chat", resp.Config.Chat.Name)
+				assert.Equal(t, "chain", resp.Config.Type)
+			}
+		})
+	}
+}
+
+func TestConfigRevision(t *testing.T) {
+	ctx := withRole("admin")
+	env := newTestEnv(t)
+	unitId := primitive.NewObjectID().Hex()
+	_, err := env.config.ConfigUpdateInfo(context.Background(), &profile.ConfigCreateOrUpdateReq{Config: testUnitConfig(unitId, "chat-v1"), Admin: true})
+	require.NoError(t, err)
+	_, err = env.config.ConfigUpdateInfo(withVersion(context.Background(), env.configVersion(t, unitId)), &profile.ConfigCreateOrUpdateReq{Config: testUnitConfig(unitId, "chat-v2"), Admin: true})
+	require.NoError(t, err)
+
+	// 鉴权及参数校验
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		fn   func(ctx context.Context) error
+		want int
+	}{
+		{"list as counselor", withRole("counselor"), func(ctx context.Context) error {
+			_, err := env.config.ConfigRevisionList(ctx, &dto.ConfigRevisionListReq{UnitId: unitId})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"list unknown unit", ctx, func(ctx context.Context) error {
+			_, err := env.config.ConfigRevisionList(ctx, &dto.ConfigRevisionListReq{UnitId: primitive.NewObjectID().Hex()})
+			return err
+		}, errno.ErrNotFound},
+		{"diff as student", withRole("student"), func(ctx context.Context) error {
+			_, err := env.config.ConfigRevisionDiff(ctx, &dto.ConfigRevisionDiffReq{UnitId: unitId, From: 1, To: 2})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"diff unknown revision", ctx, func(ctx context.Context) error {
+			_, err := env.config.ConfigRevisionDiff(ctx, &dto.ConfigRevisionDiffReq{UnitId: unitId, From: 1, To: 9})
+			return err
+		}, errno.ErrNotFound},
+		{"rollback without role", context.Background(), func(ctx context.Context) error {
+			_, err := env.config.ConfigRollback(ctx, &dto.ConfigRollbackReq{UnitId: unitId, Number: 1})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"rollback invalid unit", ctx, func(ctx context.Context) error {
+			_, err := env.config.ConfigRollback(ctx, &dto.ConfigRollbackReq{UnitId: "unit", Number: 1})
+			return err
+		}, errno.ErrInvalidParams},
+		{"rollback without version", ctx, func(ctx context.Context) error {
+			_, err := env.config.ConfigRollback(ctx, &dto.ConfigRollbackReq{UnitId: unitId, Number: 1})
+			return err
+		}, errno.ErrMissingParams},
+		{"rollback stale version", withVersion(ctx, 99), func(ctx context.Context) error {
+			_, err := env.config.ConfigRollback(ctx, &dto.ConfigRollbackReq{UnitId: unitId, Number: 1})
+			return err
+		}, errno.ErrVersionConflict},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assertErrno(t, tt.want, tt.fn(tt.ctx))
+		})
+	}
+
+	list, err := env.config.ConfigRevisionList(ctx, &dto.ConfigRevisionListReq{UnitId: unitId})
+	require.NoError(t, err)
+	require.Len(t, list.Revisions, 2)
+	assert.EqualValues(t, 2, list.Total)
+	assert.Equal(t, []int64{2, 1}, []int64{list.Revisions[0].Number, list.Revisions[1].Number})
+	assert.Equal(t, []bool{true, false}, []bool{list.Revisions[0].Active, list.Revisions[1].Active})
+	assert.Equal(t, revision.ActionUpdate, list.Revisions[0].Action)
+	assert.Equal(t, revision.ActionCreate, list.Revisions[1].Action)
+	// 修订中保存的AppID与配置一致
+	assert.Equal(t, "chat-app", list.Revisions[1].Config.Chat.AppId)
+
+	diff, err := env.config.ConfigRevisionDiff(ctx, &dto.ConfigRevisionDiffReq{UnitId: unitId, From: 1, To: 2})
+	require.NoError(t, err)
+	require.Len(t, diff.Changes, 1)
+	assert.Equal(t, &dto.Change{Field: "chat.name", Before: `"chat-v1"`, After: `"chat-v2"`}, diff.Changes[0])
+
+	rollback, err := env.config.ConfigRollback(withVersion(ctx, env.configVersion(t, unitId)), &dto.ConfigRollbackReq{UnitId: unitId, Number: 1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, rollback.Revision.Number)
+	assert.EqualValues(t, 1, rollback.Revision.RollbackOf)
+	assert.Equal(t, revision.ActionRollback, rollback.Revision.Action)
+	assert.True(t, rollback.Revision.Active)
+	assert.Equal(t, "chat-v1", rollback.Revision.Config.Chat.Name)
+
+	conf, err := env.config.ConfigGetByUnitID(context.Background(), &profile.ConfigGetByUnitIdReq{UnitId: unitId, Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, "chat-v1", conf.Config.Chat.Name)
+
+	list, err = env.config.ConfigRevisionList(ctx, &dto.ConfigRevisionListReq{UnitId: unitId})
+	require.NoError(t, err)
+	require.Len(t, list.Revisions, 3)
+	assert.True(t, list.Revisions[0].Active)
+	assert.EqualValues(t, 3, list.Revisions[0].Number)
+	assert.False(t, list.Revisions[1].Active)
+}
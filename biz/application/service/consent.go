@@ -0,0 +1,372 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/acceptance"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/consent"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IConsentService = (*ConsentService)(nil)
+
+type IConsentService interface {
+	ConsentPublish(ctx context.Context, req *dto.ConsentPublishReq) (*dto.ConsentPublishResp, error)
+	ConsentGetLatest(ctx context.Context, req *dto.ConsentGetLatestReq) (*dto.ConsentGetLatestResp, error)
+	ConsentAccept(ctx context.Context, req *dto.ConsentAcceptReq) (*basic.Response, error)
+	ConsentRevoke(ctx context.Context, req *dto.ConsentRevokeReq) (*basic.Response, error)
+	ConsentListRecord(ctx context.Context, req *dto.ConsentListRecordReq) (*dto.ConsentListRecordResp, error)
+	ConsentCheck(ctx context.Context, req *dto.ConsentCheckReq) (*dto.ConsentCheckResp, error)
+}
+
+type ConsentService struct {
+	ConsentMapper    consent.IMongoMapper
+	AcceptanceMapper acceptance.IMongoMapper
+	UserMapper       user.IMongoMapper
+	UnitMapper       unit.IMongoMapper
+	Transactor       mapper.Transactor
+	EventService     *EventService
+}
+
+var ConsentServiceSet = wire.NewSet(
+	wire.Struct(new(ConsentService), "*"),
+	wire.Bind(new(IConsentService), new(*ConsentService)),
+)
+
+// featureConsents 使用各项功能前需要签署的同意书
+var featureConsents = map[int][]int{
+	enum.FeatureChat:   {enum.ConsentTypeAICounseling, enum.ConsentTypeDataProcessing},
+	enum.FeatureTTS:    {enum.ConsentTypeAICounseling, enum.ConsentTypeRecording, enum.ConsentTypeDataProcessing},
+	enum.FeatureReport: {enum.ConsentTypeDataProcessing},
+}
+
+func (c *ConsentService) ConsentPublish(ctx context.Context, req *dto.ConsentPublishReq) (*dto.ConsentPublishResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "同意书", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	unitId, err := primitive.ObjectIDFromHex(req.UnitId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+	consentType, ok := enum.ParseConsentType(req.Type)
+	if !ok {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "同意书类型"))
+	}
+	if req.Title == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "同意书标题"))
+	}
+	if req.Content == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "同意书内容"))
+	}
+
+	// 版本号在最新版本上递增
+	latest, err := c.ConsentMapper.FindLatestByUnitIDAndType(ctx, unitId, consentType)
+	if err != nil {
+		logs.Errorf("find latest consent error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	var version int32 = 1
+	if latest != nil {
+		version = latest.Version + 1
+	}
+
+	now := time.Now().Unix()
+	consentDAO := &consent.Consent{
+		ID:         primitive.NewObjectID(),
+		UnitID:     unitId,
+		Type:       consentType,
+		Version:    version,
+		Title:      req.Title,
+		Content:    req.Content,
+		Status:     enum.Active,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	if err = c.ConsentMapper.Insert(ctx, consentDAO); err != nil {
+		logs.Errorf("insert consent error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	return &dto.ConsentPublishResp{Consent: consentDTO(consentDAO)}, nil
+}
+
+func (c *ConsentService) ConsentGetLatest(ctx context.Context, req *dto.ConsentGetLatestReq) (*dto.ConsentGetLatestResp, error) {
+	// 参数校验
+	unitId, err := primitive.ObjectIDFromHex(req.UnitId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+	consentType, ok := enum.ParseConsentType(req.Type)
+	if !ok {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "同意书类型"))
+	}
+
+	latest, err := c.ConsentMapper.FindLatestByUnitIDAndType(ctx, unitId, consentType)
+	if err != nil {
+		logs.Errorf("find latest consent error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	if latest == nil {
+		return nil, errorx.New(errno.ErrConsentNotPublished, errorx.KV("field", "同意书"))
+	}
+
+	return &dto.ConsentGetLatestResp{Consent: consentDTO(latest)}, nil
+}
+
+func (c *ConsentService) ConsentAccept(ctx context.Context, req *dto.ConsentAcceptReq) (*basic.Response, error) {
+	// 参数校验
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+	consentType, ok := enum.ParseConsentType(req.Type)
+	if !ok {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "同意书类型"))
+	}
+	acceptor, ok := enum.ParseAcceptor(req.Acceptor)
+	if !ok {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "签署人"))
+	}
+	if acceptor == enum.AcceptorGuardian {
+		if req.AcceptorName == "" {
+			return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "监护人姓名"))
+		}
+		if req.Relation == "" {
+			return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "监护人关系"))
+		}
+	}
+
+	// 获得用户及其单位的最新同意书
+	userDAO, err := c.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	latest, err := c.ConsentMapper.FindLatestByUnitIDAndType(ctx, userDAO.UnitID, consentType)
+	if err != nil {
+		logs.Errorf("find latest consent error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	if latest == nil {
+		return nil, errorx.New(errno.ErrConsentNotPublished, errorx.KV("field", "同意书"))
+	}
+	// 只能签署最新版本
+	if latest.Version != req.Version {
+		return nil, errorx.New(errno.ErrConsentVersionOutdated)
+	}
+
+	// 本人签署时记录本人姓名
+	acceptorName := req.AcceptorName
+	if acceptor == enum.AcceptorSelf {
+		acceptorName = userDAO.Name
+	}
+
+	now := time.Now().Unix()
+	if err = c.AcceptanceMapper.Insert(ctx, &acceptance.Acceptance{
+		ID:           primitive.NewObjectID(),
+		UserID:       userDAO.ID,
+		UnitID:       userDAO.UnitID,
+		ConsentID:    latest.ID,
+		Type:         consentType,
+		Version:      latest.Version,
+		Acceptor:     acceptor,
+		AcceptorName: acceptorName,
+		Relation:     req.Relation,
+		Status:       enum.Active,
+		CreateTime:   now,
+		UpdateTime:   now,
+	}); err != nil {
+		logs.Errorf("insert acceptance error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	return &basic.Response{}, nil
+}
+
+// ConsentRevoke 撤回用户对某类同意书的全部签署
+func (c *ConsentService) ConsentRevoke(ctx context.Context, req *dto.ConsentRevokeReq) (*basic.Response, error) {
+	// 参数校验
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+	consentType, ok := enum.ParseConsentType(req.Type)
+	if !ok {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "同意书类型"))
+	}
+
+	userDAO, err := c.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	acceptances, err := c.AcceptanceMapper.FindActiveByUserIDAndType(ctx, userId, consentType)
+	if err != nil {
+		logs.Errorf("find acceptances error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	if len(acceptances) == 0 {
+		return &basic.Response{}, nil
+	}
+
+	now := time.Now().Unix()
+	if err = c.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, a := range acceptances {
+			if err := c.AcceptanceMapper.UpdateFields(ctx, a.ID, bson.M{
+				cst.Status:     enum.Deleted,
+				cst.UpdateTime: now,
+				cst.DeleteTime: now,
+			}); err != nil {
+				return err
+			}
+		}
+		return c.EventService.Record(ctx, outbox.TypeConsentRevoked, outbox.AggregateUser, userId, userDAO.UnitID, map[string]any{
+			"type": consentType,
+		})
+	}); err != nil {
+		logs.Errorf("revoke acceptance error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	return &basic.Response{}, nil
+}
+
+func (c *ConsentService) ConsentListRecord(ctx context.Context, req *dto.ConsentListRecordReq) (*dto.ConsentListRecordResp, error) {
+	// 参数校验
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+
+	acceptances, err := c.AcceptanceMapper.FindAllByUserID(ctx, userId)
+	if err != nil {
+		logs.Errorf("find acceptances error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	records := make([]*dto.Acceptance, 0, len(acceptances))
+	for _, a := range acceptances {
+		typeStr, _ := enum.GetConsentType(a.Type)
+		acceptorStr, _ := enum.GetAcceptor(a.Acceptor)
+		statusStr, _ := enum.GetStatus(a.Status)
+		records = append(records, &dto.Acceptance{
+			Id:           a.ID.Hex(),
+			UserId:       a.UserID.Hex(),
+			ConsentId:    a.ConsentID.Hex(),
+			Type:         typeStr,
+			Version:      a.Version,
+			Acceptor:     acceptorStr,
+			AcceptorName: a.AcceptorName,
+			Relation:     a.Relation,
+			Status:       statusStr,
+			CreateTime:   a.CreateTime,
+			DeleteTime:   a.DeleteTime,
+		})
+	}
+	return &dto.ConsentListRecordResp{Acceptances: records}, nil
+}
+
+// ConsentCheck 检查用户是否已签署使用某项功能所需的全部最新同意书
+// 低于单位监护人同意年龄或未填写出生日期的用户还需要监护人签署, 低于功能限制年龄或未填写出生日期的用户直接禁用受限功能
+func (c *ConsentService) ConsentCheck(ctx context.Context, req *dto.ConsentCheckReq) (*dto.ConsentCheckResp, error) {
+	// 参数校验
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+	feature, ok := enum.ParseFeature(req.Feature)
+	if !ok {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "功能"))
+	}
+
+	userDAO, err := c.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	// 单位按年龄禁用的功能
+	policy, err := loadAgePolicy(ctx, c.UnitMapper, userDAO.UnitID)
+	if err != nil {
+		return nil, err
+	}
+	if isRestricted(userDAO.Birth, feature, policy) {
+		return &dto.ConsentCheckResp{Restricted: true}, nil
+	}
+
+	// 需要签署的人
+	acceptors := []int{enum.AcceptorSelf}
+	if needGuardian(userDAO.Birth, policy) {
+		acceptors = append(acceptors, enum.AcceptorGuardian)
+	}
+
+	var missing []string
+	for _, consentType := range featureConsents[feature] {
+		typeStr, _ := enum.GetConsentType(consentType)
+
+		// 单位未发布的同意书视为未签署
+		latest, err := c.ConsentMapper.FindLatestByUnitIDAndType(ctx, userDAO.UnitID, consentType)
+		if err != nil {
+			logs.Errorf("find latest consent error: %s", errorx.ErrorWithoutStack(err))
+			return nil, err
+		}
+		if latest == nil {
+			missing = append(missing, typeStr)
+			continue
+		}
+
+		acceptances, err := c.AcceptanceMapper.FindActiveByUserIDAndType(ctx, userId, consentType)
+		if err != nil {
+			logs.Errorf("find acceptances error: %s", errorx.ErrorWithoutStack(err))
+			return nil, err
+		}
+		accepted := make(map[int]bool)
+		for _, a := range acceptances {
+			if a.Version == latest.Version {
+				accepted[a.Acceptor] = true
+			}
+		}
+		for _, acceptor := range acceptors {
+			if !accepted[acceptor] {
+				acceptorStr, _ := enum.GetAcceptor(acceptor)
+				missing = append(missing, typeStr+":"+acceptorStr)
+			}
+		}
+	}
+
+	return &dto.ConsentCheckResp{
+		Allowed: len(missing) == 0,
+		Missing: missing,
+	}, nil
+}
+
+func consentDTO(c *consent.Consent) *dto.Consent {
+	typeStr, _ := enum.GetConsentType(c.Type)
+	return &dto.Consent{
+		Id:         c.ID.Hex(),
+		UnitId:     c.UnitID.Hex(),
+		Type:       typeStr,
+		Version:    c.Version,
+		Title:      c.Title,
+		Content:    c.Content,
+		CreateTime: c.CreateTime,
+	}
+}
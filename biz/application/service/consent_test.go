@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/types/errno"
+)
+
+// publishConsent 以管理员身份发布同意书, 返回版本号
+func (e *testEnv) publishConsent(t *testing.T, unitId, typ string) int32 {
+	resp, err := e.consent.ConsentPublish(withRole("admin"), &dto.ConsentPublishReq{UnitId: unitId, Type: typ, Title: "同意书", Content: "内容"})
+	require.NoError(t, err)
+	return resp.Consent.Version
+}
+
+func TestConsentPublish(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+
+	tests := []struct {
+		name    string
+		role    string
+		req     *dto.ConsentPublishReq
+		want    int
+		version int32
+	}{
+		{name: "counselor", role: "counselor", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "recording", Title: "t", Content: "c"}, want: errno.ErrPermissionDenied},
+		{name: "invalid unit", role: "admin", req: &dto.ConsentPublishReq{UnitId: "unit", Type: "recording", Title: "t", Content: "c"}, want: errno.ErrInvalidParams},
+		{name: "invalid type", role: "admin", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "video", Title: "t", Content: "c"}, want: errno.ErrInvalidParams},
+		{name: "missing title", role: "admin", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "recording", Content: "c"}, want: errno.ErrMissingParams},
+		{name: "missing content", role: "admin", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "recording", Title: "t"}, want: errno.ErrMissingParams},
+		{name: "first version", role: "admin", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "recording", Title: "t", Content: "v1"}, version: 1},
+		{name: "second version", role: "admin", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "recording", Title: "t", Content: "v2"}, version: 2},
+		// 不同类型的同意书分别计算版本号
+		{name: "other type", role: "admin", req: &dto.ConsentPublishReq{UnitId: unitId, Type: "ai_counseling", Title: "t", Content: "v1"}, version: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.consent.ConsentPublish(withRole(tt.role), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, tt.version, resp.Consent.Version)
+			}
+		})
+	}
+
+	latest, err := env.consent.ConsentGetLatest(context.Background(), &dto.ConsentGetLatestReq{UnitId: unitId, Type: "recording"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, latest.Consent.Version)
+	assert.Equal(t, "v2", latest.Consent.Content)
+	_, err = env.consent.ConsentGetLatest(context.Background(), &dto.ConsentGetLatestReq{UnitId: unitId, Type: "data_processing"})
+	assertErrno(t, errno.ErrConsentNotPublished, err)
+}
+
+func TestConsentAccept(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	userId := env.signUpUser(t, unitId, "13800000001", yearsAgo(10))
+	env.publishConsent(t, unitId, "ai_counseling")
+	env.publishConsent(t, unitId, "ai_counseling")
+
+	tests := []struct {
+		name string
+		req  *dto.ConsentAcceptReq
+		want int
+	}{
+		{"invalid user", &dto.ConsentAcceptReq{UserId: "user", Type: "ai_counseling", Version: 2, Acceptor: "self"}, errno.ErrInvalidParams},
+		{"invalid type", &dto.ConsentAcceptReq{UserId: userId, Type: "video", Version: 2, Acceptor: "self"}, errno.ErrInvalidParams},
+		{"invalid acceptor", &dto.ConsentAcceptReq{UserId: userId, Type: "ai_counseling", Version: 2, Acceptor: "teacher"}, errno.ErrInvalidParams},
+		{"guardian without name", &dto.ConsentAcceptReq{UserId: userId, Type: "ai_counseling", Version: 2, Acceptor: "guardian", Relation: "父亲"}, errno.ErrMissingParams},
+		{"guardian without relation", &dto.ConsentAcceptReq{UserId: userId, Type: "ai_counseling", Version: 2, Acceptor: "guardian", AcceptorName: "张父"}, errno.ErrMissingParams},
+		{"not published", &dto.ConsentAcceptReq{UserId: userId, Type: "recording", Version: 1, Acceptor: "self"}, errno.ErrConsentNotPublished},
+		{"outdated version", &dto.ConsentAcceptReq{UserId: userId, Type: "ai_counseling", Version: 1, Acceptor: "self"}, errno.ErrConsentVersionOutdated},
+		{"self", &dto.ConsentAcceptReq{UserId: userId, Type: "ai_counseling", Version: 2, Acceptor: "self"}, 0},
+		{"guardian", &dto.ConsentAcceptReq{UserId: userId, Type: "ai_counseling", Version: 2, Acceptor: "guardian", AcceptorName: "张父", Relation: "父亲"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.consent.ConsentAccept(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	records, err := env.consent.ConsentListRecord(context.Background(), &dto.ConsentListRecordReq{UserId: userId})
+	require.NoError(t, err)
+	require.Len(t, records.Acceptances, 2)
+	names := map[string]string{}
+	for _, a := range records.Acceptances {
+		assert.EqualValues(t, 2, a.Version)
+		assert.Equal(t, "active", a.Status)
+		names[a.Acceptor] = a.AcceptorName
+	}
+	// 本人签署时记录用户的姓名
+	assert.Equal(t, map[string]string{"self": "张三", "guardian": "张父"}, names)
+}
+
+// 未成年及未填写出生日期的用户需要监护人签署, 低于功能限制年龄及未填写出生日期的用户直接禁用受限功能
+func TestConsentCheck(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	_, err := env.unit.UnitAgePolicyUpdate(withVersion(withRole("admin"), env.unitVersion(t, unitId)), &dto.UnitAgePolicyUpdateReq{UnitId: unitId, AgePolicy: &dto.AgePolicy{
+		GuardianAge:        16,
+		RestrictedAge:      12,
+		RestrictedFeatures: []string{"tts"},
+	}})
+	require.NoError(t, err)
+	ai := env.publishConsent(t, unitId, "ai_counseling")
+	data := env.publishConsent(t, unitId, "data_processing")
+
+	users := map[string]string{
+		"adult":   env.signUpUser(t, unitId, "13800000001", yearsAgo(20)),
+		"minor":   env.signUpUser(t, unitId, "13800000002", yearsAgo(14)),
+		"child":   env.signUpUser(t, unitId, "13800000003", yearsAgo(10)),
+		"unknown": env.signUpUser(t, unitId, "13800000004", 0),
+	}
+	accept := func(user, typ string, version int32, acceptor string) {
+		_, err := env.consent.ConsentAccept(context.Background(), &dto.ConsentAcceptReq{
+			UserId: users[user], Type: typ, Version: version, Acceptor: acceptor, AcceptorName: "监护人", Relation: "母亲",
+		})
+		require.NoError(t, err)
+	}
+	for _, user := range []string{"adult", "minor", "unknown"} {
+		accept(user, "ai_counseling", ai, "self")
+		accept(user, "data_processing", data, "self")
+	}
+	accept("unknown", "ai_counseling", ai, "guardian")
+
+	tests := []struct {
+		name       string
+		user       string
+		feature    string
+		want       int
+		allowed    bool
+		restricted bool
+		missing    []string
+	}{
+		{name: "invalid feature", user: "adult", feature: "video", want: errno.ErrInvalidParams},
+		{name: "adult chat", user: "adult", feature: "chat", allowed: true},
+		{name: "adult report", user: "adult", feature: "report", allowed: true},
+		// 单位未发布的同意书视为未签署
+		{name: "adult tts", user: "adult", feature: "tts", missing: []string{"recording"}},
+		{name: "minor chat", user: "minor", feature: "chat", missing: []string{"ai_counseling:guardian", "data_processing:guardian"}},
+		{name: "unknown birth chat", user: "unknown", feature: "chat", missing: []string{"data_processing:guardian"}},
+		{name: "child chat", user: "child", feature: "chat", missing: []string{"ai_counseling:self", "ai_counseling:guardian", "data_processing:self", "data_processing:guardian"}},
+		{name: "child tts", user: "child", feature: "tts", restricted: true},
+		// 未填写出生日期时无法确认已达到限制年龄
+		{name: "unknown birth tts", user: "unknown", feature: "tts", restricted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.consent.ConsentCheck(context.Background(), &dto.ConsentCheckReq{UserId: users[tt.user], Feature: tt.feature})
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, tt.allowed, resp.Allowed)
+				assert.Equal(t, tt.restricted, resp.Restricted)
+				assert.Equal(t, tt.missing, resp.Missing)
+			}
+		})
+	}
+}
+
+func TestConsentRevoke(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	userId := env.signUpUser(t, unitId, "13800000001", yearsAgo(20))
+	version := env.publishConsent(t, unitId, "data_processing")
+	_, err := env.consent.ConsentAccept(context.Background(), &dto.ConsentAcceptReq{UserId: userId, Type: "data_processing", Version: version, Acceptor: "self"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  *dto.ConsentRevokeReq
+		want int
+	}{
+		{"invalid user", &dto.ConsentRevokeReq{UserId: "user", Type: "data_processing"}, errno.ErrInvalidParams},
+		{"invalid type", &dto.ConsentRevokeReq{UserId: userId, Type: "video"}, errno.ErrInvalidParams},
+		{"revoke", &dto.ConsentRevokeReq{UserId: userId, Type: "data_processing"}, 0},
+		// 没有签署时不产生事件
+		{"revoke again", &dto.ConsentRevokeReq{UserId: userId, Type: "data_processing"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.consent.ConsentRevoke(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	check, err := env.consent.ConsentCheck(context.Background(), &dto.ConsentCheckReq{UserId: userId, Feature: "report"})
+	require.NoError(t, err)
+	assert.False(t, check.Allowed)
+	assert.Equal(t, []string{"data_processing:self"}, check.Missing)
+
+	events, err := env.outbox.FindPending(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	revoked := events[1]
+	assert.Equal(t, outbox.TypeConsentRevoked, revoked.Type)
+	assert.Equal(t, userId, revoked.AggregateID.Hex())
+	assert.Equal(t, unitId, revoked.UnitID.Hex())
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-profile/biz/infra/event"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventService 将领域事件写入outbox, 由投递任务异步发布
+type EventService struct {
+	OutboxMapper outbox.IMongoMapper
+	Dispatcher   *event.Dispatcher
+}
+
+var EventServiceSet = wire.Struct(new(EventService), "*")
+
+// Record 记录一个事件, 需要与业务写入在同一事务中调用, 事务回滚时事件也不会被投递
+// payload只能包含ID及枚举值, 不能包含个人信息
+func (e *EventService) Record(ctx context.Context, typ, aggregate string, aggregateId, unitId primitive.ObjectID, payload map[string]any) error {
+	if err := e.OutboxMapper.Insert(ctx, &outbox.Event{
+		ID:          primitive.NewObjectID(),
+		Type:        typ,
+		Aggregate:   aggregate,
+		AggregateID: aggregateId,
+		UnitID:      unitId,
+		Payload:     payload,
+		Status:      outbox.StatusPending,
+		CreateTime:  time.Now().Unix(),
+	}); err != nil {
+		logs.CtxErrorf(ctx, "insert event error: %s", errorx.ErrorWithoutStack(err))
+		return err
+	}
+	// 提交后才能被投递任务读到
+	mapper.AfterCommit(ctx, func(context.Context) { e.Dispatcher.Notify() })
+	return nil
+}
+
+// userCreatedPayload 用户创建事件只携带ID及枚举值
+func userCreatedPayload(u *user.User) map[string]any {
+	return map[string]any{
+		"unitId":   u.UnitID.Hex(),
+		"codeType": u.CodeType,
+		"status":   u.Status,
+	}
+}
+
+// configChangedPayload action为触发变更的操作
+func configChangedPayload(configId primitive.ObjectID, action string) map[string]any {
+	return map[string]any{
+		"configId": configId.Hex(),
+		"action":   action,
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/health"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+)
+
+var _ IHealthService = (*HealthService)(nil)
+
+type IHealthService interface {
+	HealthCheck(ctx context.Context, req *dto.HealthCheckReq) (*dto.HealthCheckResp, error)
+}
+
+type HealthService struct {
+	Checker *health.Checker
+}
+
+var HealthServiceSet = wire.NewSet(
+	wire.Struct(new(HealthService), "*"),
+	wire.Bind(new(IHealthService), new(*HealthService)),
+)
+
+// 检查的范围
+const (
+	healthLiveness  = "liveness"
+	healthReadiness = "readiness"
+)
+
+// HealthCheck 检查服务的存活或就绪状态, 不需要鉴权
+func (h *HealthService) HealthCheck(ctx context.Context, req *dto.HealthCheckReq) (*dto.HealthCheckResp, error) {
+	switch req.Service {
+	case healthLiveness:
+		status := health.StatusServing
+		if !h.Checker.Live() {
+			status = health.StatusNotServing
+		}
+		return &dto.HealthCheckResp{Status: status}, nil
+	case "", healthReadiness:
+		ready, results := h.Checker.Ready(ctx)
+		resp := &dto.HealthCheckResp{Status: health.StatusServing, Checks: make([]*dto.HealthCheck, 0, len(results))}
+		if !ready {
+			resp.Status = health.StatusNotServing
+		}
+		for _, r := range results {
+			resp.Checks = append(resp.Checks, &dto.HealthCheck{Name: r.Name, Status: r.Status, Error: r.Error})
+		}
+		return resp, nil
+	default:
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "检查范围"))
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"strconv"
+	"unicode"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+)
+
+// checkPassword 按当前配置的密码规则校验用户自行设置的密码, 规则支持热更新
+// 单位批量导入的初始密码不受限制
+func checkPassword(password string) error {
+	policy := config.GetConfig().Password
+	if len([]rune(password)) < policy.MinLength {
+		return errorx.New(errno.ErrWeakPassword, errorx.KV("reason", "长度不能少于"+strconv.Itoa(policy.MinLength)+"位"))
+	}
+	if policy.RequireLetterDigit {
+		var letter, digit bool
+		for _, r := range password {
+			letter = letter || unicode.IsLetter(r)
+			digit = digit || unicode.IsDigit(r)
+		}
+		if !letter || !digit {
+			return errorx.New(errno.ErrWeakPassword, errorx.KV("reason", "需要同时包含字母和数字"))
+		}
+	}
+	return nil
+}
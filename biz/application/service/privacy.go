@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/acceptance"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IPrivacyService = (*PrivacyService)(nil)
+
+// IPrivacyService 处理用户查询和删除个人数据的请求
+type IPrivacyService interface {
+	UserDataExport(ctx context.Context, req *dto.UserDataExportReq) (*dto.UserDataExportResp, error)
+	UserDataErase(ctx context.Context, req *dto.UserDataEraseReq) (*basic.Response, error)
+}
+
+type PrivacyService struct {
+	UserMapper       user.IMongoMapper
+	UnitMapper       unit.IMongoMapper
+	ConfigMapper     config.IMongoMapper
+	AcceptanceMapper acceptance.IMongoMapper
+	Transactor       mapper.Transactor
+	AuditService     *AuditService
+	EventService     *EventService
+}
+
+var PrivacyServiceSet = wire.NewSet(
+	wire.Struct(new(PrivacyService), "*"),
+	wire.Bind(new(IPrivacyService), new(*PrivacyService)),
+)
+
+// exportVersion 导出文档的格式版本, 字段有不兼容变更时递增
+const exportVersion = 1
+
+// userExport 导出文档, 字段与数据库实体的json格式一致
+type userExport struct {
+	Version     int                      `json:"version"`
+	ExportTime  int64                    `json:"exportTime"`
+	User        *user.User               `json:"user"`
+	Unit        *unit.Unit               `json:"unit,omitempty"`
+	Config      *config.Config           `json:"config,omitempty"`
+	Acceptances []*acceptance.Acceptance `json:"acceptances,omitempty"`
+}
+
+// erasedFields 匿名化时删除的用户字段
+var erasedFields = []string{
+	cst.Code, cst.CodeIndex, cst.Name, cst.Birth, cst.BirthCipher, cst.Options, cst.Contacts, cst.Password,
+}
+
+// UserDataExport 导出与用户关联的全部数据, 学生本人及管理员可用
+func (p *PrivacyService) UserDataExport(ctx context.Context, req *dto.UserDataExportReq) (*dto.UserDataExportResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "个人数据", enum.RoleStudent, enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	userId, err := parseUserId(req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获得用户, 去除密码及仅用于存储的字段
+	userDAO, err := p.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	userDAO.Password, userDAO.CodeIndex = "", ""
+	export := &userExport{Version: exportVersion, ExportTime: time.Now().Unix(), User: userDAO}
+
+	// 用户所属单位只导出基本信息, 单位账号的凭据与用户无关
+	if !userDAO.UnitID.IsZero() {
+		unitDAO, err := p.UnitMapper.FindOne(ctx, userDAO.UnitID)
+		if err != nil {
+			logs.Errorf("find unit error: %s", errorx.ErrorWithoutStack(err))
+			return nil, err
+		}
+		export.Unit = &unit.Unit{ID: unitDAO.ID, Name: unitDAO.Name, Address: unitDAO.Address}
+
+		confDAO, err := p.ConfigMapper.FindOneByUnitID(ctx, userDAO.UnitID)
+		if err != nil && !errors.Is(err, monc.ErrNotFound) {
+			logs.Errorf("find config error: %s", errorx.ErrorWithoutStack(err))
+			return nil, err
+		}
+		export.Config = confDAO
+	}
+
+	if export.Acceptances, err = p.AcceptanceMapper.FindAllByUserID(ctx, userId); err != nil {
+		logs.Errorf("find acceptance error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		logs.Errorf("marshal export error: %s", errorx.ErrorWithoutStack(err))
+		return nil, errorx.New(errno.ErrInternalError)
+	}
+
+	// 导出记录写入失败时不返回数据
+	if err = p.AuditService.Record(ctx, "UserDataExport", entityUser, userDAO.UnitID, userId, nil, nil); err != nil {
+		return nil, err
+	}
+	return &dto.UserDataExportResp{Data: string(data)}, nil
+}
+
+// UserDataErase 匿名化用户, 删除可识别身份的字段并保留带删除时间的墓碑记录, 仅管理员可用
+// 假名保留, 以便下游服务据此清理各自的数据
+func (p *PrivacyService) UserDataErase(ctx context.Context, req *dto.UserDataEraseReq) (*basic.Response, error) {
+	// 鉴权
+	if err := checkRole(ctx, "个人数据", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	userId, err := parseUserId(req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	userDAO, err := p.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	// 重复请求时保持原删除时间
+	if userDAO.Status == enum.Deleted && userDAO.DeleteTime != 0 {
+		return &basic.Response{}, nil
+	}
+
+	now := time.Now().Unix()
+	update := bson.M{
+		cst.Status:     enum.Deleted,
+		cst.UpdateTime: now,
+		cst.DeleteTime: now,
+	}
+	// 删除的字段在审计中记为nil
+	after := bson.M{}
+	for _, f := range erasedFields {
+		after[f] = nil
+	}
+	for k, v := range update {
+		after[k] = v
+	}
+	if err = p.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := p.UserMapper.UnsetFields(ctx, userId, erasedFields, update); err != nil {
+			return err
+		}
+		if err := p.EventService.Record(ctx, outbox.TypeUserDeactivated, outbox.AggregateUser, userId, userDAO.UnitID, map[string]any{
+			"pseudonym": userDAO.Pseudonym,
+		}); err != nil {
+			return err
+		}
+		return p.AuditService.Record(ctx, "UserDataErase", entityUser, userDAO.UnitID, userId, userDAO, after)
+	}); err != nil {
+		logs.Errorf("erase user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	return &basic.Response{}, nil
+}
+
+func parseUserId(id string) (primitive.ObjectID, error) {
+	if id == "" {
+		return primitive.NilObjectID, errorx.New(errno.ErrMissingParams, errorx.KV("field", "用户ID"))
+	}
+	userId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+	return userId, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/wire"
+	infraconfig "github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevisionService 记录配置的修订历史
+type RevisionService struct {
+	RevisionMapper revision.IMongoMapper
+}
+
+var RevisionServiceSet = wire.Struct(new(RevisionService), "*")
+
+// Record 记录配置写入后的内容并返回新的修订, 需要与配置写入在同一事务中调用
+// rollbackOf为回滚的目标修订, 其他操作为0, 超出保留数的最早修订同时删除
+func (r *RevisionService) Record(ctx context.Context, conf *config.Config, action string, rollbackOf int64) (*revision.Revision, error) {
+	snapshot := *conf
+	rev := &revision.Revision{
+		ID:         primitive.NewObjectID(),
+		ConfigID:   conf.ID,
+		UnitID:     conf.UnitID,
+		Number:     conf.Version + 1,
+		Config:     &snapshot,
+		Author:     meta.Actor(ctx),
+		Action:     action,
+		RollbackOf: rollbackOf,
+		CreateTime: time.Now().Unix(),
+	}
+	if err := r.RevisionMapper.Insert(ctx, rev); err != nil {
+		logs.CtxErrorf(ctx, "insert config revision error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	if keep := infraconfig.GetConfig().ConfigRevision.Keep; keep > 0 && rev.Number > int64(keep) {
+		if _, err := r.RevisionMapper.DeleteBefore(ctx, conf.ID, rev.Number-int64(keep)+1); err != nil {
+			logs.CtxErrorf(ctx, "delete expired config revisions error: %s", errorx.ErrorWithoutStack(err))
+			return nil, err
+		}
+	}
+	return rev, nil
+}
+
+// Baseline 配置尚无修订时以当前内容补记一个修订, 使更新前的内容可以回滚
+func (r *RevisionService) Baseline(ctx context.Context, conf *config.Config) error {
+	latest, err := r.RevisionMapper.FindLatest(ctx, conf.ID)
+	if err != nil || latest != nil {
+		return err
+	}
+	snapshot := *conf
+	return r.RevisionMapper.Insert(ctx, &revision.Revision{
+		ID:         primitive.NewObjectID(),
+		ConfigID:   conf.ID,
+		UnitID:     conf.UnitID,
+		Number:     conf.Version + 1,
+		Config:     &snapshot,
+		Action:     revision.ActionBaseline,
+		CreateTime: conf.UpdateTime,
+	})
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	infraconfig "github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/event"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/acceptance"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/audit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/consent"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testConfig 服务使用的最小配置, 数据只保存在内存中
+const testConfig = `Name: psych.profile.test
+ListenOn: 127.0.0.1:0
+State: test
+Storage: memory
+Log:
+  Mode: console
+  Level: severe
+Pseudonym:
+  Key: test-pseudonym-key
+`
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "psych-profile")
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err = os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		panic(err)
+	}
+	_ = os.Setenv("CONFIG_PATH", path)
+	if _, err = infraconfig.NewConfig(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testEnv 基于内存mapper构造的全部服务
+type testEnv struct {
+	users       user.IMongoMapper
+	units       unit.IMongoMapper
+	configs     config.IMongoMapper
+	revisions   revision.IMongoMapper
+	consents    consent.IMongoMapper
+	acceptances acceptance.IMongoMapper
+	outbox      outbox.IMongoMapper
+	webhooks    webhook.IMongoMapper
+	deliveries  delivery.IMongoMapper
+
+	user    *UserService
+	unit    *UnitService
+	config  *ConfigService
+	consent *ConsentService
+	webhook *WebhookService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	c := infraconfig.GetConfig()
+	keyring, err := crypto.NewKeyring(c)
+	require.NoError(t, err)
+
+	env := &testEnv{
+		users:       user.NewMemoryMapper(keyring),
+		units:       unit.NewMemoryMapper(keyring),
+		configs:     config.NewMemoryMapper(),
+		revisions:   revision.NewMemoryMapper(keyring),
+		consents:    consent.NewMemoryMapper(),
+		acceptances: acceptance.NewMemoryMapper(),
+		outbox:      outbox.NewMemoryMapper(),
+		webhooks:    webhook.NewMemoryMapper(keyring),
+		deliveries:  delivery.NewMemoryMapper(),
+	}
+	transactor := mapper.NewMemoryTransactor()
+	deliverer := event.NewDeliverer(c, env.webhooks, env.deliveries)
+	auditService := &AuditService{AuditMapper: audit.NewMemoryMapper()}
+	eventService := &EventService{OutboxMapper: env.outbox, Dispatcher: event.NewDispatcher(c, env.outbox, deliverer)}
+	revisionService := &RevisionService{RevisionMapper: env.revisions}
+
+	env.user = &UserService{
+		UserMapper:   env.users,
+		UnitMapper:   env.units,
+		Transactor:   transactor,
+		AuditService: auditService,
+		EventService: eventService,
+	}
+	env.unit = &UnitService{
+		UnitMapper:      env.units,
+		UserMapper:      env.users,
+		ConfigMapper:    env.configs,
+		Transactor:      transactor,
+		AuditService:    auditService,
+		EventService:    eventService,
+		RevisionService: revisionService,
+	}
+	env.config = &ConfigService{
+		ConfigMapper:    env.configs,
+		RevisionMapper:  env.revisions,
+		Transactor:      transactor,
+		AuditService:    auditService,
+		EventService:    eventService,
+		RevisionService: revisionService,
+	}
+	env.consent = &ConsentService{
+		ConsentMapper:    env.consents,
+		AcceptanceMapper: env.acceptances,
+		UserMapper:       env.users,
+		UnitMapper:       env.units,
+		Transactor:       transactor,
+		EventService:     eventService,
+	}
+	env.webhook = &WebhookService{
+		WebhookMapper:  env.webhooks,
+		DeliveryMapper: env.deliveries,
+		Deliverer:      deliverer,
+		Transactor:     transactor,
+		AuditService:   auditService,
+	}
+	return env
+}
+
+// signUpUnit 注册一个单位并返回其ID
+func (e *testEnv) signUpUnit(t *testing.T, phone string) string {
+	resp, err := e.unit.UnitSignUp(context.Background(), &profile.UnitSignUpReq{Unit: &profile.Unit{
+		Name:     "测试单位",
+		Phone:    phone,
+		Password: "unit-password1",
+	}})
+	require.NoError(t, err)
+	return resp.Unit.Id
+}
+
+// signUpUser 在单位下注册一个用户并返回其ID
+func (e *testEnv) signUpUser(t *testing.T, unitId, code string, birth int64) string {
+	resp, err := e.user.UserSignUp(context.Background(), &profile.UserSignUpReq{User: &profile.User{
+		Code:     code,
+		Password: "user-password1",
+		Name:     "张三",
+		Gender:   "male",
+		Birth:    birth,
+		UnitId:   unitId,
+	}})
+	require.NoError(t, err)
+	return resp.User.Id
+}
+
+// unitVersion 单位当前的版本号, 即调用方读取单位后携带的版本号
+func (e *testEnv) unitVersion(t *testing.T, unitId string) int64 {
+	id, err := primitive.ObjectIDFromHex(unitId)
+	require.NoError(t, err)
+	u, err := e.units.FindOne(context.Background(), id)
+	require.NoError(t, err)
+	return u.Version
+}
+
+// configVersion 单位配置当前的版本号
+func (e *testEnv) configVersion(t *testing.T, unitId string) int64 {
+	id, err := primitive.ObjectIDFromHex(unitId)
+	require.NoError(t, err)
+	c, err := e.configs.FindOneByUnitID(context.Background(), id)
+	require.NoError(t, err)
+	return c.Version
+}
+
+// eventTypes 按写入顺序返回outbox中尚未投递的事件类型
+func (e *testEnv) eventTypes(t *testing.T) []string {
+	events, err := e.outbox.FindPending(context.Background(), 1000)
+	require.NoError(t, err)
+	types := make([]string, 0, len(events))
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+// yearsAgo 生日已过的n周岁的出生日期
+func yearsAgo(n int) int64 {
+	return time.Now().AddDate(-n, 0, -1).Unix()
+}
+
+// withRole 模拟网关鉴权后写入的调用方角色
+func withRole(role string) context.Context {
+	return metainfo.WithPersistentValue(context.Background(), meta.KeyRole, role)
+}
+
+func withVersion(ctx context.Context, version int64) context.Context {
+	return metainfo.WithValue(ctx, meta.KeyVersion, strconv.FormatInt(version, 10))
+}
+
+// assertErrno 断言错误码, code为0时断言没有错误
+func assertErrno(t *testing.T, code int, err error) {
+	t.Helper()
+	if code == 0 {
+		assert.NoError(t, err)
+		return
+	}
+	var se errorx.StatusError
+	if assert.True(t, errors.As(err, &se), "want errno %d, got %v", code, err) {
+		assert.EqualValues(t, code, se.Code())
+	}
+}
@@ -2,20 +2,30 @@ package service
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/wire"
 	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
 	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
 	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/age"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/encrypt"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/reg"
 	"github.com/xh-polaris/psych-profile/pkg/errorx"
 	"github.com/xh-polaris/psych-profile/pkg/logs"
 	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -30,11 +40,18 @@ type IUnitService interface {
 	UnitUpdatePassword(ctx context.Context, req *profile.UnitUpdatePasswordReq) (*basic.Response, error)
 	UnitLinkUser(ctx context.Context, req *profile.UnitLinkUserReq) (*basic.Response, error)
 	UnitCreateAndLinkUser(ctx context.Context, req *profile.UnitCreateAndLinkUserReq) (*profile.UnitCreateAndLinkUserResp, error)
+	UnitAgePolicyGet(ctx context.Context, req *dto.UnitAgePolicyGetReq) (*dto.UnitAgePolicyGetResp, error)
+	UnitAgePolicyUpdate(ctx context.Context, req *dto.UnitAgePolicyUpdateReq) (*basic.Response, error)
 }
 
 type UnitService struct {
-	UnitMapper unit.IMongoMapper
-	UserMapper user.IMongoMapper
+	UnitMapper      unit.IMongoMapper
+	UserMapper      user.IMongoMapper
+	ConfigMapper    config.IMongoMapper
+	Transactor      mapper.Transactor
+	AuditService    *AuditService
+	EventService    *EventService
+	RevisionService *RevisionService
 }
 
 var UnitServiceSet = wire.NewSet(
@@ -56,6 +73,9 @@ func (u *UnitService) UnitSignUp(ctx context.Context, req *profile.UnitSignUpReq
 	if req.Unit.Password == "" {
 		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "密码"))
 	}
+	if err := checkPassword(req.Unit.Password); err != nil {
+		return nil, err
+	}
 
 	// 手机号格式校验
 	if !reg.CheckMobile(req.Unit.Phone) {
@@ -91,11 +111,44 @@ func (u *UnitService) UnitSignUp(ctx context.Context, req *profile.UnitSignUpReq
 		UpdateTime: time.Now().Unix(),
 	}
 
-	// 插入数据库
-	if err := u.UnitMapper.Insert(ctx, unitDAO); err != nil {
+	// 存在模板配置(没有unitId的配置)时复制为单位的默认配置, 不存在时单位没有配置, 由管理员通过ConfigCreate创建
+	// 单位本身即登录主体, 注册时不创建单独的管理员账号
+	template, err := u.ConfigMapper.FindTemplate(ctx)
+	if err != nil {
+		logs.Errorf("find config template error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	var confDAO *config.Config
+	if template != nil {
+		confDAO = defaultConfig(template, unitDAO.ID)
+	}
+
+	// 单位、默认配置及其修订、事件和审计在同一事务中插入
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UnitMapper.Insert(ctx, unitDAO); err != nil {
+			return err
+		}
+		if err := u.AuditService.Record(ctx, "UnitSignUp", entityUnit, unitDAO.ID, unitDAO.ID, nil, unitDAO); err != nil {
+			return err
+		}
+		if confDAO == nil {
+			return nil
+		}
+		if err := u.ConfigMapper.Insert(ctx, confDAO); err != nil {
+			return err
+		}
+		if _, err := u.RevisionService.Record(ctx, confDAO, revision.ActionCreate, 0); err != nil {
+			return err
+		}
+		if err := u.EventService.Record(ctx, outbox.TypeConfigChanged, outbox.AggregateUnit, unitDAO.ID, unitDAO.ID, configChangedPayload(confDAO.ID, revision.ActionCreate)); err != nil {
+			return err
+		}
+		return u.AuditService.Record(ctx, "ConfigCreate", entityConfig, unitDAO.ID, confDAO.ID, nil, confDAO)
+	}); err != nil {
 		logs.Errorf("insert unit error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
+	metrics.IncSignUp(metrics.KindUnit)
 
 	// 获得单位状态
 	statusStr, ok := enum.GetStatus(unitDAO.Status)
@@ -147,15 +200,17 @@ func (u *UnitService) UnitSignIn(ctx context.Context, req *profile.UnitSignInReq
 	case cst.AuthTypePassword:
 		// 获得用户
 		unitDAO, err = u.UnitMapper.FindOneByPhone(ctx, req.AuthId)
-		if err != nil {
+		if err != nil && !errors.Is(err, monc.ErrNotFound) {
 			logs.Errorf("find unit by phone error: %s", errorx.ErrorWithoutStack(err))
 			return nil, err
 		} else if unitDAO == nil {
+			metrics.IncSignInFailure(metrics.KindUnit, metrics.ReasonAccount)
 			return nil, errorx.New(errno.ErrWrongAccountOrPassword)
 		}
 
 		// 获得密码
 		if !encrypt.BcryptCheck(req.VerifyCode, unitDAO.Password) {
+			metrics.IncSignInFailure(metrics.KindUnit, metrics.ReasonPassword)
 			return nil, errorx.New(errno.ErrWrongAccountOrPassword)
 		}
 	// 验证码登录
@@ -193,6 +248,7 @@ func (u *UnitService) UnitGetInfo(ctx context.Context, req *profile.UnitGetInfoR
 	}
 
 	// 构造返回结果
+	meta.SetVersion(ctx, unitDAO.Version)
 	return &profile.UnitGetInfoResp{
 		Unit: &profile.Unit{
 			Id:         unitDAO.ID.Hex(),
@@ -209,11 +265,16 @@ func (u *UnitService) UnitGetInfo(ctx context.Context, req *profile.UnitGetInfoR
 	}, nil
 }
 
+// UnitUpdateInfo 修改单位信息, 需要携带读取时的版本号
 func (u *UnitService) UnitUpdateInfo(ctx context.Context, req *profile.UnitUpdateInfoReq) (*basic.Response, error) {
 	// 参数校验
 	if req.Unit.Id == "" {
 		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "单位ID"))
 	}
+	version, err := requireVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// 不允许修改手机号、密码、验证方式、level、状态
 	// 密码、验证方式需要通过其他接口修改
@@ -222,6 +283,11 @@ func (u *UnitService) UnitUpdateInfo(ctx context.Context, req *profile.UnitUpdat
 		logs.Errorf("parse unit id error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
+	before, err := u.UnitMapper.FindOne(ctx, unitId)
+	if err != nil {
+		logs.Errorf("find unit error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
 
 	// 构建更新字段
 	update := make(bson.M)
@@ -238,10 +304,16 @@ func (u *UnitService) UnitUpdateInfo(ctx context.Context, req *profile.UnitUpdat
 
 	// 一次更新所有字段
 	if len(update) > 0 {
-		if err = u.UnitMapper.UpdateFields(ctx, unitId, update); err != nil {
+		if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := u.UnitMapper.UpdateFieldsIfVersion(ctx, unitId, version, update); err != nil {
+				return err
+			}
+			return u.AuditService.Record(ctx, "UnitUpdateInfo", entityUnit, unitId, unitId, before, update)
+		}); err != nil {
 			logs.Errorf("update unit error: %s", errorx.ErrorWithoutStack(err))
 			return nil, err
 		}
+		meta.SetVersion(ctx, version+1)
 	}
 
 	// 构造返回结果
@@ -265,6 +337,9 @@ func (u *UnitService) UnitUpdatePassword(ctx context.Context, req *profile.UnitU
 	if req.NewPassword == "" {
 		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "新密码"))
 	}
+	if err := checkPassword(req.NewPassword); err != nil {
+		return nil, err
+	}
 
 	unitId, err := primitive.ObjectIDFromHex(req.Id)
 	if err != nil {
@@ -299,9 +374,15 @@ func (u *UnitService) UnitUpdatePassword(ctx context.Context, req *profile.UnitU
 	}
 
 	// 更新密码
-	if err = u.UnitMapper.UpdateFields(ctx, unitDAO.ID, bson.M{
+	update := bson.M{
 		cst.Password:   newPwd,
 		cst.UpdateTime: time.Now().Unix(),
+	}
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UnitMapper.UpdateFields(ctx, unitDAO.ID, update); err != nil {
+			return err
+		}
+		return u.AuditService.Record(ctx, "UnitUpdatePassword", entityUnit, unitDAO.ID, unitDAO.ID, unitDAO, update)
 	}); err != nil {
 		logs.Errorf("update unit error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
@@ -332,8 +413,32 @@ func (u *UnitService) UnitLinkUser(ctx context.Context, req *profile.UnitLinkUse
 		return nil, err
 	}
 
-	// 绑定用户
-	if err := u.UserMapper.UpdateFields(ctx, userId, bson.M{cst.UnitID: unitId}); err != nil {
+	before, err := u.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	// 绑定用户, 假名随单位变化
+	update := bson.M{
+		cst.UnitID:    unitId,
+		cst.Pseudonym: pseudonymOf(unitId, userId),
+	}
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UserMapper.UpdateFields(ctx, userId, update); err != nil {
+			return err
+		}
+		if err := u.AuditService.Record(ctx, "UnitLinkUser", entityUser, unitId, userId, before, update); err != nil {
+			return err
+		}
+		if before.UnitID == unitId {
+			return nil
+		}
+		return u.EventService.Record(ctx, outbox.TypeUserMoved, outbox.AggregateUser, userId, unitId, map[string]any{
+			"fromUnitId": before.UnitID.Hex(),
+			"toUnitId":   unitId.Hex(),
+		})
+	}); err != nil {
 		logs.Errorf("update user error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
@@ -369,6 +474,12 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 	// 验证方式标记
 	isCodeTypePhone := codeType == enum.CodeTypePhone
 
+	// 单位的年龄规则
+	policy, err := loadAgePolicy(ctx, u.UnitMapper, unitId)
+	if err != nil {
+		return nil, err
+	}
+
 	// 找出所有属于这个单位的用户
 	users, err := u.UserMapper.FindAllByUnitID(ctx, unitId)
 	if err != nil {
@@ -382,12 +493,12 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 		existingCodes[userDAO.Code] = true
 	}
 
-	// 记录需要插入的用户数量、成功数量和跳过数量
+	// 记录需要插入的用户数量和跳过数量
 	all := len(req.Users)
-	success := 0
 	skip := 0
 
-	// 插入用户
+	// 校验并构造用户, 全部通过后再插入
+	newUsers := make([]*user.User, 0, len(req.Users))
 	for _, userReq := range req.Users {
 		// 参数校验
 		if userReq.Code == "" && isCodeTypePhone {
@@ -402,11 +513,15 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 		if userReq.Password == "" {
 			return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "密码"))
 		}
+		if err = checkAge(userReq.Birth, policy); err != nil {
+			return nil, err
+		}
 
 		// 检查是否已存在相同的code
 		if existingCodes[userReq.Code] {
 			// 如果在这个unit中已经存在该code，则跳过
 			skip++
+			metrics.IncBulkImportRow(metrics.ImportSkip)
 			continue
 		}
 
@@ -418,6 +533,7 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 			} else if exists {
 				// 如果在这个unit中已经存在该手机号，则跳过
 				skip++
+				metrics.IncBulkImportRow(metrics.ImportSkip)
 				continue
 			}
 
@@ -433,6 +549,7 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 			} else if exists {
 				// 如果在这个unit中已经存在该学号，则跳过
 				skip++
+				metrics.IncBulkImportRow(metrics.ImportSkip)
 				continue
 			}
 		}
@@ -455,8 +572,9 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 		}
 
 		// 构造用户
+		userId := primitive.NewObjectID()
 		userDAO := &user.User{
-			ID:         primitive.NewObjectID(),
+			ID:         userId,
 			CodeType:   codeType,
 			Code:       userReq.Code,
 			Password:   hashedPwd,
@@ -468,26 +586,146 @@ func (u *UnitService) UnitCreateAndLinkUser(ctx context.Context, req *profile.Un
 			Grade:      userReq.Grade,
 			EnrollYear: userReq.EnrollYear,
 			UnitID:     unitId,
+			Pseudonym:  pseudonymOf(unitId, userId),
 			UpdateTime: time.Now().Unix(),
 			CreateTime: time.Now().Unix(),
 		}
 
-		// 插入用户
-		if err = u.UserMapper.Insert(ctx, userDAO); err != nil {
-			logs.Errorf("insert user error: %s", errorx.ErrorWithoutStack(err))
-			return nil, err
-		}
-
-		// 添加到existingCodes map中，避免后续重复创建
+		// 添加到existingCodes map中，避免同一批中重复创建
 		existingCodes[userReq.Code] = true
+		newUsers = append(newUsers, userDAO)
+	}
 
-		// 添加成功数量
-		success++
+	// 所有用户及其事件、审计在同一事务中插入, 任一失败时整批回滚
+	// 并发导入时由唯一索引发现重复的code, 返回对应的错误, 重试时已导入的用户会被跳过
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UserMapper.InsertMany(ctx, newUsers); err != nil {
+			return err
+		}
+		for _, userDAO := range newUsers {
+			if err := u.EventService.Record(ctx, outbox.TypeUserCreated, outbox.AggregateUser, userDAO.ID, unitId, userCreatedPayload(userDAO)); err != nil {
+				return err
+			}
+			if err := u.AuditService.Record(ctx, "UnitCreateAndLinkUser", entityUser, unitId, userDAO.ID, nil, userDAO); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		logs.Errorf("insert users error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	for range newUsers {
+		metrics.IncBulkImportRow(metrics.ImportSuccess)
 	}
 
 	return &profile.UnitCreateAndLinkUserResp{
 		AllCount:     int32(all),
-		SuccessCount: int32(success),
+		SuccessCount: int32(len(newUsers)),
 		SkipCount:    int32(skip),
 	}, nil
 }
+
+// UnitAgePolicyGet 获得单位生效的年龄规则, 并返回单位的版本号供修改时携带
+func (u *UnitService) UnitAgePolicyGet(ctx context.Context, req *dto.UnitAgePolicyGetReq) (*dto.UnitAgePolicyGetResp, error) {
+	// 参数校验
+	if req.UnitId == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "单位ID"))
+	}
+	unitId, err := primitive.ObjectIDFromHex(req.UnitId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+
+	unitDAO, err := u.UnitMapper.FindOne(ctx, unitId)
+	if err != nil {
+		logs.Errorf("find unit error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	policy := agePolicyOf(unitDAO)
+	meta.SetVersion(ctx, unitDAO.Version)
+
+	features := make([]string, 0, len(policy.RestrictedFeatures))
+	for _, f := range policy.RestrictedFeatures {
+		if featureStr, ok := enum.GetFeature(f); ok {
+			features = append(features, featureStr)
+		}
+	}
+	return &dto.UnitAgePolicyGetResp{
+		AgePolicy: &dto.AgePolicy{
+			MinAge:             policy.MinAge,
+			GuardianAge:        policy.GuardianAge,
+			RestrictedAge:      policy.RestrictedAge,
+			RestrictedFeatures: features,
+		},
+		Version: unitDAO.Version,
+	}, nil
+}
+
+// UnitAgePolicyUpdate 整体替换单位的年龄规则, 需要携带读取时的版本号
+func (u *UnitService) UnitAgePolicyUpdate(ctx context.Context, req *dto.UnitAgePolicyUpdateReq) (*basic.Response, error) {
+	// 鉴权
+	if err := checkRole(ctx, "年龄规则", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	if req.UnitId == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "单位ID"))
+	}
+	if req.AgePolicy == nil {
+		return nil, errorx.New(errno.ErrMissingEntity, errorx.KV("entity", "年龄规则"))
+	}
+	version, err := requireVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	unitId, err := primitive.ObjectIDFromHex(req.UnitId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+	before, err := u.UnitMapper.FindOne(ctx, unitId)
+	if err != nil {
+		logs.Errorf("find unit error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	for field, v := range map[string]int32{
+		"最低年龄":    req.AgePolicy.MinAge,
+		"监护人同意年龄": req.AgePolicy.GuardianAge,
+		"功能限制年龄":  req.AgePolicy.RestrictedAge,
+	} {
+		if v < 0 || v > age.MaxAge {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", field))
+		}
+	}
+	features := make([]int, 0, len(req.AgePolicy.RestrictedFeatures))
+	for _, f := range req.AgePolicy.RestrictedFeatures {
+		feature, ok := enum.ParseFeature(f)
+		if !ok {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "受限功能"))
+		}
+		features = append(features, feature)
+	}
+
+	update := bson.M{
+		cst.AgePolicy: &unit.AgePolicy{
+			MinAge:             req.AgePolicy.MinAge,
+			GuardianAge:        req.AgePolicy.GuardianAge,
+			RestrictedAge:      req.AgePolicy.RestrictedAge,
+			RestrictedFeatures: features,
+		},
+		cst.UpdateTime: time.Now().Unix(),
+	}
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UnitMapper.UpdateFieldsIfVersion(ctx, unitId, version, update); err != nil {
+			return err
+		}
+		return u.AuditService.Record(ctx, "UnitAgePolicyUpdate", entityUnit, unitId, unitId, before, update)
+	}); err != nil {
+		logs.Errorf("update unit age policy error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	meta.SetVersion(ctx, version+1)
+
+	return &basic.Response{}, nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUnitSignUp(t *testing.T) {
+	env := newTestEnv(t)
+	env.signUpUnit(t, "13900000001")
+
+	newUnit := func(modify func(u *profile.Unit)) *profile.UnitSignUpReq {
+		u := &profile.Unit{Name: "第二单位", Phone: "13900000002", Password: "unit-password1"}
+		modify(u)
+		return &profile.UnitSignUpReq{Unit: u}
+	}
+	tests := []struct {
+		name string
+		req  *profile.UnitSignUpReq
+		want int
+	}{
+		{"missing unit", &profile.UnitSignUpReq{}, errno.ErrMissingEntity},
+		{"missing name", newUnit(func(u *profile.Unit) { u.Name = "" }), errno.ErrMissingParams},
+		{"missing phone", newUnit(func(u *profile.Unit) { u.Phone = "" }), errno.ErrMissingParams},
+		{"missing password", newUnit(func(u *profile.Unit) { u.Password = "" }), errno.ErrMissingParams},
+		{"invalid phone", newUnit(func(u *profile.Unit) { u.Phone = "23900000002" }), errno.ErrInvalidParams},
+		{"duplicate phone", newUnit(func(u *profile.Unit) { u.Phone = "13900000001" }), errno.ErrPhoneAlreadyExist},
+		{"success", newUnit(func(u *profile.Unit) {}), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.unit.UnitSignUp(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, tt.req.Unit.Phone, resp.Unit.Phone)
+				assert.Equal(t, "active", resp.Unit.Status)
+			}
+		})
+	}
+}
+
+// 存在模板配置时注册的单位复制一份默认配置, 不存在时单位没有配置
+func TestUnitSignUpDefaultConfig(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	withoutTemplate, err := primitive.ObjectIDFromHex(env.signUpUnit(t, "13900000001"))
+	require.NoError(t, err)
+	_, err = env.configs.FindOneByUnitID(ctx, withoutTemplate)
+	assert.Error(t, err)
+	assert.Empty(t, env.eventTypes(t))
+
+	require.NoError(t, env.configs.Insert(ctx, &config.Config{
+		ID:     primitive.NewObjectID(),
+		Type:   enum.ConfigTypeEnd2End,
+		Chat:   &config.Chat{Name: "chat", Provider: "provider", AppID: "chat-app"},
+		Status: enum.Active,
+	}))
+	withTemplate, err := primitive.ObjectIDFromHex(env.signUpUnit(t, "13900000002"))
+	require.NoError(t, err)
+	conf, err := env.configs.FindOneByUnitID(ctx, withTemplate)
+	require.NoError(t, err)
+	assert.Equal(t, enum.ConfigTypeEnd2End, conf.Type)
+	assert.Equal(t, "chat-app", conf.Chat.AppID)
+	assert.Nil(t, conf.TTS)
+	latest, err := env.revisions.FindLatest(ctx, conf.ID)
+	require.NoError(t, err)
+	require.NotNil(t, latest)
+	assert.EqualValues(t, 1, latest.Number)
+	assert.Equal(t, []string{outbox.TypeConfigChanged}, env.eventTypes(t))
+}
+
+func TestUnitSignIn(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+
+	tests := []struct {
+		name string
+		req  *profile.UnitSignInReq
+		want int
+	}{
+		{"missing phone", &profile.UnitSignInReq{VerifyCode: "unit-password1"}, errno.ErrMissingParams},
+		{"missing password", &profile.UnitSignInReq{AuthId: "13900000001"}, errno.ErrMissingParams},
+		{"missing code", &profile.UnitSignInReq{AuthId: "13900000001", AuthType: cst.AuthTypeCode}, errno.ErrMissingParams},
+		{"invalid phone", &profile.UnitSignInReq{AuthId: "139", VerifyCode: "unit-password1"}, errno.ErrInvalidParams},
+		{"code not implemented", &profile.UnitSignInReq{AuthId: "13900000001", AuthType: cst.AuthTypeCode, VerifyCode: "123456"}, errno.ErrUnImplement},
+		{"unknown account", &profile.UnitSignInReq{AuthId: "13900000009", VerifyCode: "unit-password1"}, errno.ErrWrongAccountOrPassword},
+		{"wrong password", &profile.UnitSignInReq{AuthId: "13900000001", VerifyCode: "wrong-password"}, errno.ErrWrongAccountOrPassword},
+		{"success", &profile.UnitSignInReq{AuthId: "13900000001", VerifyCode: "unit-password1"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.unit.UnitSignIn(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, unitId, resp.UnitId)
+			}
+		})
+	}
+}
+
+func TestUnitCreateAndLinkUser(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	env.signUpUser(t, unitId, "13800000001", 0)
+
+	row := func(code string) *profile.User {
+		return &profile.User{Code: code, Name: "学生" + code, Password: "init", Gender: "unknown"}
+	}
+	tests := []struct {
+		name     string
+		codeType string
+		users    []*profile.User
+		want     int
+		// 期望的总数、成功数及跳过数
+		all, success, skip int32
+	}{
+		{name: "missing code type", users: []*profile.User{row("13800000002")}, want: errno.ErrMissingParams},
+		{name: "missing users", codeType: "phone", want: errno.ErrMissingParams},
+		{name: "invalid code type", codeType: "email", users: []*profile.User{row("13800000002")}, want: errno.ErrInvalidParams},
+		{name: "missing phone", codeType: "phone", users: []*profile.User{row("")}, want: errno.ErrMissingParams},
+		{name: "missing student id", codeType: "studentId", users: []*profile.User{row("")}, want: errno.ErrMissingParams},
+		{name: "missing name", codeType: "phone", users: []*profile.User{{Code: "13800000002", Password: "init"}}, want: errno.ErrMissingParams},
+		{name: "missing password", codeType: "phone", users: []*profile.User{{Code: "13800000002", Name: "学生"}}, want: errno.ErrMissingParams},
+		// 任一行不合法时整批都不导入
+		{name: "invalid phone", codeType: "phone", users: []*profile.User{row("13800000002"), row("12345")}, want: errno.ErrInvalidParams},
+		{name: "invalid gender", codeType: "phone", users: []*profile.User{row("13800000002"), {Code: "13800000003", Name: "学生", Password: "init", Gender: "other"}}, want: errno.ErrInvalidParams},
+		{
+			// 已存在及同一批中重复的code被跳过
+			name:     "phone",
+			codeType: "phone",
+			users:    []*profile.User{row("13800000001"), row("13800000002"), row("13800000002"), row("13800000003")},
+			all:      4, success: 2, skip: 2,
+		},
+		{
+			name:     "student id",
+			codeType: "studentId",
+			users:    []*profile.User{row("S001"), row("S001"), row("S002")},
+			all:      3, success: 2, skip: 1,
+		},
+		{
+			name:     "all skipped",
+			codeType: "studentId",
+			users:    []*profile.User{row("S001"), row("S002")},
+			all:      2, success: 0, skip: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.unit.UnitCreateAndLinkUser(context.Background(), &profile.UnitCreateAndLinkUserReq{
+				UnitId:   unitId,
+				CodeType: tt.codeType,
+				Users:    tt.users,
+			})
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, tt.all, resp.AllCount)
+				assert.Equal(t, tt.success, resp.SuccessCount)
+				assert.Equal(t, tt.skip, resp.SkipCount)
+			}
+		})
+	}
+
+	id, err := primitive.ObjectIDFromHex(unitId)
+	require.NoError(t, err)
+	users, err := env.users.FindAllByUnitID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Len(t, users, 5)
+
+	// 导入的用户可以使用初始密码登录
+	_, err = env.user.UserSignIn(context.Background(), &profile.UserSignInReq{AuthId: "S002", UnitId: unitId, VerifyCode: "init"})
+	assert.NoError(t, err)
+}
+
+func TestUnitAgePolicy(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+
+	policy := func(modify func(p *dto.AgePolicy)) *dto.AgePolicy {
+		p := &dto.AgePolicy{MinAge: 12, GuardianAge: 16, RestrictedAge: 14, RestrictedFeatures: []string{"tts"}}
+		modify(p)
+		return p
+	}
+	admin := withVersion(withRole("admin"), env.unitVersion(t, unitId))
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		policy *dto.AgePolicy
+		want   int
+	}{
+		{"counselor", withVersion(withRole("counselor"), env.unitVersion(t, unitId)), policy(func(p *dto.AgePolicy) {}), errno.ErrPermissionDenied},
+		{"missing policy", admin, nil, errno.ErrMissingEntity},
+		// 修改单位必须携带版本号
+		{"missing version", withRole("admin"), policy(func(p *dto.AgePolicy) {}), errno.ErrMissingParams},
+		{"stale version", withVersion(withRole("admin"), 99), policy(func(p *dto.AgePolicy) {}), errno.ErrVersionConflict},
+		{"negative age", admin, policy(func(p *dto.AgePolicy) { p.MinAge = -1 }), errno.ErrInvalidParams},
+		{"too old", admin, policy(func(p *dto.AgePolicy) { p.GuardianAge = 200 }), errno.ErrInvalidParams},
+		{"invalid feature", admin, policy(func(p *dto.AgePolicy) { p.RestrictedFeatures = []string{"video"} }), errno.ErrInvalidParams},
+		{"success", admin, policy(func(p *dto.AgePolicy) {}), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.unit.UnitAgePolicyUpdate(tt.ctx, &dto.UnitAgePolicyUpdateReq{UnitId: unitId, AgePolicy: tt.policy})
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	resp, err := env.unit.UnitAgePolicyGet(context.Background(), &dto.UnitAgePolicyGetReq{UnitId: unitId})
+	require.NoError(t, err)
+	assert.Equal(t, policy(func(p *dto.AgePolicy) {}), resp.AgePolicy)
+
+	// 单位要求最低年龄后, 低于该年龄或未填写出生日期的用户不能注册
+	for _, tt := range []struct {
+		name  string
+		birth int64
+		want  int
+	}{
+		{"below minimum", yearsAgo(10), errno.ErrBelowMinimumAge},
+		{"missing birth", 0, errno.ErrMissingParams},
+		{"old enough", yearsAgo(12), 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.user.UserSignUp(context.Background(), &profile.UserSignUpReq{User: &profile.User{
+				Code: "13800000001", Password: "user-password1", Name: "张三", Gender: "male", Birth: tt.birth, UnitId: unitId,
+			}})
+			assertErrno(t, tt.want, err)
+		})
+	}
+}
@@ -2,23 +2,35 @@ package service
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/google/wire"
 	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
 	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
 	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/age"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/convert"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/encrypt"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
 	"github.com/xh-polaris/psych-profile/biz/infra/util/reg"
 	"github.com/xh-polaris/psych-profile/pkg/errorx"
 	"github.com/xh-polaris/psych-profile/pkg/logs"
 	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 var _ IUserService = (*UserService)(nil)
@@ -29,11 +41,17 @@ type IUserService interface {
 	UserGetInfo(ctx context.Context, req *profile.UserGetInfoReq) (*profile.UserGetInfoResp, error)
 	UserUpdateInfo(ctx context.Context, req *profile.UserUpdateInfoReq) (*basic.Response, error)
 	UserUpdatePassword(ctx context.Context, req *profile.UserUpdatePasswordReq) (*basic.Response, error)
+	UserContactList(ctx context.Context, req *dto.UserContactListReq) (*dto.UserContactListResp, error)
+	UserContactUpdate(ctx context.Context, req *dto.UserContactUpdateReq) (*basic.Response, error)
+	UserPseudonymResolve(ctx context.Context, req *dto.UserPseudonymResolveReq) (*dto.UserPseudonymResolveResp, error)
 }
 
 type UserService struct {
-	UserMapper user.IMongoMapper
-	UnitMapper unit.IMongoMapper
+	UserMapper   user.IMongoMapper
+	UnitMapper   unit.IMongoMapper
+	Transactor   mapper.Transactor
+	AuditService *AuditService
+	EventService *EventService
 }
 
 var UserServiceSet = wire.NewSet(
@@ -54,6 +72,9 @@ func (u *UserService) UserSignUp(ctx context.Context, req *profile.UserSignUpReq
 	if req.User.Password == "" {
 		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "密码"))
 	}
+	if err := checkPassword(req.User.Password); err != nil {
+		return nil, err
+	}
 	if req.User.Name == "" {
 		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "姓名"))
 	}
@@ -94,9 +115,19 @@ func (u *UserService) UserSignUp(ctx context.Context, req *profile.UserSignUpReq
 		}
 	}
 
+	// 年龄校验
+	policy, err := loadAgePolicy(ctx, u.UnitMapper, unitId)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAge(req.User.Birth, policy); err != nil {
+		return nil, err
+	}
+
 	// 构造用户
+	userId := primitive.NewObjectID()
 	userDAO := &user.User{
-		ID:         primitive.NewObjectID(),
+		ID:         userId,
 		CodeType:   enum.CodeTypePhone,
 		Code:       req.User.Code,
 		Password:   hashedPwd,
@@ -108,15 +139,25 @@ func (u *UserService) UserSignUp(ctx context.Context, req *profile.UserSignUpReq
 		Grade:      req.User.Grade,
 		EnrollYear: req.User.EnrollYear,
 		UnitID:     unitId,
+		Pseudonym:  pseudonymOf(unitId, userId),
 		UpdateTime: time.Now().Unix(),
 		CreateTime: time.Now().Unix(),
 	}
 
-	// 插入用户
-	if err = u.UserMapper.Insert(ctx, userDAO); err != nil {
+	// 插入用户, 同时记录事件
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UserMapper.Insert(ctx, userDAO); err != nil {
+			return err
+		}
+		if err := u.EventService.Record(ctx, outbox.TypeUserCreated, outbox.AggregateUser, userId, unitId, userCreatedPayload(userDAO)); err != nil {
+			return err
+		}
+		return u.AuditService.Record(ctx, "UserSignUp", entityUser, unitId, userId, nil, userDAO)
+	}); err != nil {
 		logs.Errorf("insert user error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
+	metrics.IncSignUp(metrics.KindUser)
 
 	// 获得枚举值
 	genderStr, ok := enum.GetGender(userDAO.Gender)
@@ -181,18 +222,31 @@ func (u *UserService) UserSignIn(ctx context.Context, req *profile.UserSignInReq
 
 	// 获得用户
 	userDAO, err := u.UserMapper.FindOneByCodeAndUnitID(ctx, req.AuthId, unitId)
-	if err != nil {
+	if err != nil && !errors.Is(err, monc.ErrNotFound) {
 		logs.Errorf("find user by code and unit id error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
 	if userDAO == nil {
+		metrics.IncSignInFailure(metrics.KindUser, metrics.ReasonAccount)
 		return nil, errorx.New(errno.ErrWrongAccountOrPassword)
 	}
 
 	// 密码验证
 	if !encrypt.BcryptCheck(req.VerifyCode, userDAO.Password) {
+		metrics.IncSignInFailure(metrics.KindUser, metrics.ReasonPassword)
 		return nil, errorx.New(errno.ErrWrongAccountOrPassword)
 	}
+
+	// 年龄校验, 单位可能在用户注册后提高了最低年龄
+	policy, err := loadAgePolicy(ctx, u.UnitMapper, userDAO.UnitID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAge(userDAO.Birth, policy); err != nil {
+		metrics.IncSignInFailure(metrics.KindUser, metrics.ReasonAge)
+		return nil, err
+	}
+
 	codeType, _ := enum.GetCodeType(userDAO.CodeType)
 	return &profile.UserSignInResp{
 		UnitId:   userDAO.UnitID.Hex(),
@@ -202,6 +256,7 @@ func (u *UserService) UserSignIn(ctx context.Context, req *profile.UserSignInReq
 	}, nil
 }
 
+// UserGetInfo 获得用户信息, metainfo中view为redacted时返回脱敏视图
 func (u *UserService) UserGetInfo(ctx context.Context, req *profile.UserGetInfoReq) (*profile.UserGetInfoResp, error) {
 	// 参数校验
 	if req.UserId == "" {
@@ -231,6 +286,9 @@ func (u *UserService) UserGetInfo(ctx context.Context, req *profile.UserGetInfoR
 	if !ok {
 		return nil, errorx.New(errno.ErrInternalError)
 	}
+	if meta.View(ctx) == meta.ViewRedacted {
+		return &profile.UserGetInfoResp{User: redactedUser(userDAO, genderStr, statusStr)}, nil
+	}
 	codeTypeStr, ok := enum.GetCodeType(userDAO.CodeType)
 	if !ok {
 		return nil, errorx.New(errno.ErrInternalError)
@@ -241,6 +299,7 @@ func (u *UserService) UserGetInfo(ctx context.Context, req *profile.UserGetInfoR
 		return nil, err
 	}
 
+	meta.SetVersion(ctx, userDAO.Version)
 	return &profile.UserGetInfoResp{
 		User: &profile.User{
 			Id:         userDAO.ID.Hex(),
@@ -275,6 +334,11 @@ func (u *UserService) UserUpdateInfo(ctx context.Context, req *profile.UserUpdat
 		logs.Errorf("parse user id error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
 	}
+	before, err := u.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
 
 	// 构建更新字段
 	update := make(bson.M)
@@ -289,6 +353,9 @@ func (u *UserService) UserUpdateInfo(ctx context.Context, req *profile.UserUpdat
 		update[cst.Gender] = gender
 	}
 	if req.User.Birth != 0 {
+		if !age.Valid(req.User.Birth, time.Now()) {
+			return nil, errorx.New(errno.ErrInvalidBirth)
+		}
 		update[cst.Birth] = req.User.Birth
 	}
 	if req.User.EnrollYear != 0 {
@@ -300,6 +367,18 @@ func (u *UserService) UserUpdateInfo(ctx context.Context, req *profile.UserUpdat
 	if req.User.Grade != 0 {
 		update[cst.Grade] = req.User.Grade
 	}
+	// 注销只能通过删除个人数据完成
+	graduated := false
+	if req.User.Status != "" {
+		status, ok := enum.ParseStatus(req.User.Status)
+		if !ok || status == enum.Deleted {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "状态"))
+		}
+		if status != before.Status {
+			update[cst.Status] = status
+			graduated = status == enum.Graduated
+		}
+	}
 	if req.User.Options != nil {
 		optionsAnypb, err := convert.Anypb2Any(req.User.Options)
 		if err != nil {
@@ -312,10 +391,24 @@ func (u *UserService) UserUpdateInfo(ctx context.Context, req *profile.UserUpdat
 
 	// 一次更新所有字段
 	if len(update) > 0 {
-		if err = u.UserMapper.UpdateFields(ctx, userId, update); err != nil {
+		version := expectedVersion(ctx, "UserUpdateInfo", before.Version)
+		if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := u.UserMapper.UpdateFieldsIfVersion(ctx, userId, version, update); err != nil {
+				return err
+			}
+			if graduated {
+				if err := u.EventService.Record(ctx, outbox.TypeUserGraduated, outbox.AggregateUser, userId, before.UnitID, map[string]any{
+					"unitId": before.UnitID.Hex(),
+				}); err != nil {
+					return err
+				}
+			}
+			return u.AuditService.Record(ctx, "UserUpdateInfo", entityUser, before.UnitID, userId, before, update)
+		}); err != nil {
 			logs.Errorf("update user error: %s", errorx.ErrorWithoutStack(err))
 			return nil, err
 		}
+		meta.SetVersion(ctx, version+1)
 	}
 
 	// 构造返回结果
@@ -339,6 +432,9 @@ func (u *UserService) UserUpdatePassword(ctx context.Context, req *profile.UserU
 	if req.NewPassword == "" {
 		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "新密码"))
 	}
+	if err := checkPassword(req.NewPassword); err != nil {
+		return nil, err
+	}
 
 	userId, err := primitive.ObjectIDFromHex(req.Id)
 	if err != nil {
@@ -373,9 +469,15 @@ func (u *UserService) UserUpdatePassword(ctx context.Context, req *profile.UserU
 	}
 
 	// 更新密码
-	if err = u.UserMapper.UpdateFields(ctx, userDAO.ID, bson.M{
+	update := bson.M{
 		cst.Password:   newPwd,
 		cst.UpdateTime: time.Now().Unix(),
+	}
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UserMapper.UpdateFields(ctx, userDAO.ID, update); err != nil {
+			return err
+		}
+		return u.AuditService.Record(ctx, "UserUpdatePassword", entityUser, userDAO.UnitID, userDAO.ID, userDAO, update)
 	}); err != nil {
 		logs.Errorf("update user error: %s", errorx.ErrorWithoutStack(err))
 		return nil, err
@@ -384,3 +486,179 @@ func (u *UserService) UserUpdatePassword(ctx context.Context, req *profile.UserU
 	// 构造返回结果
 	return &basic.Response{}, nil
 }
+
+// UserContactList 获取用户的监护人及紧急联系人, 仅咨询师和管理员可见
+func (u *UserService) UserContactList(ctx context.Context, req *dto.UserContactListReq) (*dto.UserContactListResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "联系人", enum.RoleCounselor, enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	if req.UserId == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "用户ID"))
+	}
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+
+	// 获得用户
+	userDAO, err := u.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	// 构造返回结果
+	contacts := make([]*dto.Contact, 0, len(userDAO.Contacts))
+	for _, c := range userDAO.Contacts {
+		typeStr, ok := enum.GetContactType(c.Type)
+		if !ok {
+			return nil, errorx.New(errno.ErrInternalError)
+		}
+		contacts = append(contacts, &dto.Contact{
+			Type:     typeStr,
+			Name:     c.Name,
+			Relation: c.Relation,
+			Phone:    c.Phone,
+			Priority: c.Priority,
+		})
+	}
+	return &dto.UserContactListResp{Contacts: contacts, Version: userDAO.Version}, nil
+}
+
+// UserContactUpdate 整体替换用户的监护人及紧急联系人, 仅咨询师和管理员可修改
+func (u *UserService) UserContactUpdate(ctx context.Context, req *dto.UserContactUpdateReq) (*basic.Response, error) {
+	// 鉴权
+	if err := checkRole(ctx, "联系人", enum.RoleCounselor, enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	if req.UserId == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "用户ID"))
+	}
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "用户ID"))
+	}
+	before, err := u.UserMapper.FindOne(ctx, userId)
+	if err != nil {
+		logs.Errorf("find user error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	contacts := make([]*user.Contact, 0, len(req.Contacts))
+	for _, c := range req.Contacts {
+		if c == nil {
+			return nil, errorx.New(errno.ErrMissingEntity, errorx.KV("entity", "联系人"))
+		}
+		if c.Name == "" {
+			return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "联系人姓名"))
+		}
+		if c.Relation == "" {
+			return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "联系人关系"))
+		}
+		if !reg.CheckMobile(c.Phone) {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "联系人电话"))
+		}
+		if c.Priority < 0 {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "联系优先级"))
+		}
+		contactType, ok := enum.ParseContactType(c.Type)
+		if !ok {
+			return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "联系人类型"))
+		}
+		contacts = append(contacts, &user.Contact{
+			Type:     contactType,
+			Name:     c.Name,
+			Relation: c.Relation,
+			Phone:    c.Phone,
+			Priority: c.Priority,
+		})
+	}
+	// 按优先级排序存储, 危机时按顺序联系
+	sort.SliceStable(contacts, func(i, j int) bool { return contacts[i].Priority < contacts[j].Priority })
+
+	update := bson.M{
+		cst.Contacts:   contacts,
+		cst.UpdateTime: time.Now().Unix(),
+	}
+	version := expectedVersion(ctx, "UserContactUpdate", before.Version)
+	if err = u.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := u.UserMapper.UpdateFieldsIfVersion(ctx, userId, version, update); err != nil {
+			return err
+		}
+		return u.AuditService.Record(ctx, "UserContactUpdate", entityUser, before.UnitID, userId, before, update)
+	}); err != nil {
+		logs.Errorf("update user contacts error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	meta.SetVersion(ctx, version+1)
+
+	return &basic.Response{}, nil
+}
+
+// UserPseudonymResolve 根据假名反查用户, 仅咨询师和管理员可用
+func (u *UserService) UserPseudonymResolve(ctx context.Context, req *dto.UserPseudonymResolveReq) (*dto.UserPseudonymResolveResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "假名", enum.RoleCounselor, enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	if req.Pseudonym == "" {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "假名"))
+	}
+
+	userDAO, err := u.UserMapper.FindOneByPseudonym(ctx, req.Pseudonym)
+	if err != nil {
+		logs.Errorf("find user by pseudonym error: %s", errorx.ErrorWithoutStack(err))
+		return nil, errorx.New(errno.ErrNotFound, errorx.KV("field", "假名"))
+	}
+
+	return &dto.UserPseudonymResolveResp{
+		UserId: userDAO.ID.Hex(),
+		UnitId: userDAO.UnitID.Hex(),
+	}, nil
+}
+
+// redactedUser 用户的脱敏视图, 以假名作为ID, 只返回年龄而不返回出生日期, 不返回姓名、学号、手机号及自定义选项
+// 假名由ID计算得到, 历史用户的假名由迁移补齐, 查询本身不产生写入
+func redactedUser(userDAO *user.User, genderStr, statusStr string) *profile.User {
+	var options map[string]*anypb.Any
+	if userDAO.Birth != 0 {
+		userAge, _ := anypb.New(wrapperspb.Int32(int32(age.Of(userDAO.Birth, time.Now()))))
+		options = map[string]*anypb.Any{"age": userAge}
+	}
+	return &profile.User{
+		Id:         pseudonymOf(userDAO.UnitID, userDAO.ID),
+		UnitId:     userDAO.UnitID.Hex(),
+		Gender:     genderStr,
+		Status:     statusStr,
+		EnrollYear: userDAO.EnrollYear,
+		Grade:      userDAO.Grade,
+		Class:      userDAO.Class,
+		Options:    options,
+	}
+}
+
+// pseudonymOf 计算用户在单位内的假名
+func pseudonymOf(unitId, userId primitive.ObjectID) string {
+	return encrypt.Pseudonym(config.GetConfig().Pseudonym.Key, unitId.Hex(), userId.Hex())
+}
+
+// checkRole 校验metainfo中经鉴权的调用方角色是否在允许的角色之中
+func checkRole(ctx context.Context, field string, allowed ...int) error {
+	r, ok := enum.ParseRole(meta.Role(ctx))
+	if !ok {
+		return errorx.New(errno.ErrPermissionDenied, errorx.KV("field", field))
+	}
+	for _, a := range allowed {
+		if r == a {
+			return nil
+		}
+	}
+	return errorx.New(errno.ErrPermissionDenied, errorx.KV("field", field))
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-idl/kitex_gen/profile"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
+	"github.com/xh-polaris/psych-profile/types/errno"
+)
+
+func TestUserSignUp(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	env.signUpUser(t, unitId, "13800000001", 0)
+
+	newUser := func(modify func(u *profile.User)) *profile.UserSignUpReq {
+		u := &profile.User{Code: "13800000002", Password: "user-password1", Name: "李四", Gender: "female", UnitId: unitId}
+		modify(u)
+		return &profile.UserSignUpReq{User: u}
+	}
+	tests := []struct {
+		name string
+		req  *profile.UserSignUpReq
+		want int
+	}{
+		{"missing user", &profile.UserSignUpReq{}, errno.ErrMissingEntity},
+		{"missing code", newUser(func(u *profile.User) { u.Code = "" }), errno.ErrMissingParams},
+		{"missing password", newUser(func(u *profile.User) { u.Password = "" }), errno.ErrMissingParams},
+		{"missing name", newUser(func(u *profile.User) { u.Name = "" }), errno.ErrMissingParams},
+		{"invalid phone", newUser(func(u *profile.User) { u.Code = "12345" }), errno.ErrInvalidParams},
+		{"duplicate phone", newUser(func(u *profile.User) { u.Code = "13800000001" }), errno.ErrPhoneAlreadyExist},
+		{"invalid gender", newUser(func(u *profile.User) { u.Gender = "other" }), errno.ErrInvalidParams},
+		{"future birth", newUser(func(u *profile.User) { u.Birth = yearsAgo(-1) }), errno.ErrInvalidBirth},
+		{"success", newUser(func(u *profile.User) {}), 0},
+		{"success without unit", newUser(func(u *profile.User) { u.Code, u.UnitId = "13800000003", "" }), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.user.UserSignUp(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, tt.req.User.Code, resp.User.Code)
+				assert.Equal(t, "active", resp.User.Status)
+				assert.Equal(t, "phone", resp.User.CodeType)
+			}
+		})
+	}
+	assert.Equal(t, []string{outbox.TypeUserCreated, outbox.TypeUserCreated, outbox.TypeUserCreated}, env.eventTypes(t))
+}
+
+func TestUserSignIn(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	otherUnitId := env.signUpUnit(t, "13900000002")
+	userId := env.signUpUser(t, unitId, "13800000001", 0)
+
+	tests := []struct {
+		name string
+		req  *profile.UserSignInReq
+		want int
+	}{
+		{"missing account", &profile.UserSignInReq{UnitId: unitId, VerifyCode: "user-password1"}, errno.ErrMissingParams},
+		{"missing unit", &profile.UserSignInReq{AuthId: "13800000001", VerifyCode: "user-password1"}, errno.ErrMissingParams},
+		{"missing password", &profile.UserSignInReq{AuthId: "13800000001", UnitId: unitId}, errno.ErrMissingParams},
+		{"code not implemented", &profile.UserSignInReq{AuthId: "13800000001", UnitId: unitId, AuthType: cst.AuthTypeCode}, errno.ErrUnImplement},
+		{"invalid auth type", &profile.UserSignInReq{AuthId: "13800000001", UnitId: unitId, AuthType: 9}, errno.ErrInvalidParams},
+		{"unknown account", &profile.UserSignInReq{AuthId: "13800000009", UnitId: unitId, VerifyCode: "user-password1"}, errno.ErrWrongAccountOrPassword},
+		{"other unit", &profile.UserSignInReq{AuthId: "13800000001", UnitId: otherUnitId, VerifyCode: "user-password1"}, errno.ErrWrongAccountOrPassword},
+		{"wrong password", &profile.UserSignInReq{AuthId: "13800000001", UnitId: unitId, VerifyCode: "wrong-password"}, errno.ErrWrongAccountOrPassword},
+		{"success", &profile.UserSignInReq{AuthId: "13800000001", UnitId: unitId, VerifyCode: "user-password1"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.user.UserSignIn(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, userId, resp.UserId)
+				assert.Equal(t, unitId, resp.UnitId)
+			}
+		})
+	}
+}
+
+// 脱敏视图以假名作为ID, 咨询师可以通过假名反查用户
+func TestUserGetInfoRedacted(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	userId := env.signUpUser(t, unitId, "13800000001", yearsAgo(20))
+
+	full, err := env.user.UserGetInfo(context.Background(), &profile.UserGetInfoReq{UserId: userId})
+	require.NoError(t, err)
+	assert.Equal(t, userId, full.User.Id)
+	assert.Equal(t, "13800000001", full.User.Code)
+	assert.Equal(t, "张三", full.User.Name)
+
+	ctx := metainfo.WithPersistentValue(context.Background(), meta.KeyView, meta.ViewRedacted)
+	redacted, err := env.user.UserGetInfo(ctx, &profile.UserGetInfoReq{UserId: userId})
+	require.NoError(t, err)
+	assert.NotEqual(t, userId, redacted.User.Id)
+	assert.Empty(t, redacted.User.Code)
+	assert.Empty(t, redacted.User.Name)
+	assert.Zero(t, redacted.User.Birth)
+	assert.Contains(t, redacted.User.Options, "age")
+
+	for _, tt := range []struct {
+		role string
+		want int
+	}{
+		{"", errno.ErrPermissionDenied},
+		{"student", errno.ErrPermissionDenied},
+		{"counselor", 0},
+		{"admin", 0},
+	} {
+		t.Run(tt.role, func(t *testing.T) {
+			resp, err := env.user.UserPseudonymResolve(withRole(tt.role), &dto.UserPseudonymResolveReq{Pseudonym: redacted.User.Id})
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.Equal(t, userId, resp.UserId)
+				assert.Equal(t, unitId, resp.UnitId)
+			}
+		})
+	}
+	_, err = env.user.UserPseudonymResolve(withRole("admin"), &dto.UserPseudonymResolveReq{Pseudonym: "unknown"})
+	assertErrno(t, errno.ErrNotFound, err)
+}
+
+func TestUserUpdateInfo(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	userId := env.signUpUser(t, unitId, "13800000001", 0)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		user *profile.User
+		want int
+	}{
+		{"missing id", context.Background(), &profile.User{}, errno.ErrMissingParams},
+		{"invalid gender", context.Background(), &profile.User{Id: userId, Gender: "other"}, errno.ErrInvalidParams},
+		{"future birth", context.Background(), &profile.User{Id: userId, Birth: yearsAgo(-1)}, errno.ErrInvalidBirth},
+		{"invalid status", context.Background(), &profile.User{Id: userId, Status: "unknown"}, errno.ErrInvalidParams},
+		{"deleted status", context.Background(), &profile.User{Id: userId, Status: "deleted"}, errno.ErrInvalidParams},
+		{"stale version", withVersion(context.Background(), 99), &profile.User{Id: userId, Name: "王五"}, errno.ErrVersionConflict},
+		{"rename", withVersion(context.Background(), 0), &profile.User{Id: userId, Name: "王五"}, 0},
+		{"graduate", context.Background(), &profile.User{Id: userId, Status: "graduated"}, 0},
+		// 状态未变化时不重复发出事件
+		{"graduate again", context.Background(), &profile.User{Id: userId, Status: "graduated"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.user.UserUpdateInfo(tt.ctx, &profile.UserUpdateInfoReq{User: tt.user})
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	resp, err := env.user.UserGetInfo(context.Background(), &profile.UserGetInfoReq{UserId: userId})
+	require.NoError(t, err)
+	assert.Equal(t, "王五", resp.User.Name)
+	assert.Equal(t, "graduated", resp.User.Status)
+	assert.Equal(t, []string{outbox.TypeUserCreated, outbox.TypeUserGraduated}, env.eventTypes(t))
+}
+
+func TestUserUpdatePassword(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	userId := env.signUpUser(t, unitId, "13800000001", 0)
+
+	tests := []struct {
+		name string
+		req  *profile.UserUpdatePasswordReq
+		want int
+	}{
+		{"missing id", &profile.UserUpdatePasswordReq{VerifyCode: "user-password1", NewPassword: "new-password1"}, errno.ErrMissingParams},
+		{"missing old password", &profile.UserUpdatePasswordReq{Id: userId, NewPassword: "new-password1"}, errno.ErrMissingParams},
+		{"missing new password", &profile.UserUpdatePasswordReq{Id: userId, VerifyCode: "user-password1"}, errno.ErrMissingParams},
+		{"wrong password", &profile.UserUpdatePasswordReq{Id: userId, VerifyCode: "wrong-password", NewPassword: "new-password1"}, errno.ErrWrongPassword},
+		{"success", &profile.UserUpdatePasswordReq{Id: userId, VerifyCode: "user-password1", NewPassword: "new-password1"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.user.UserUpdatePassword(context.Background(), tt.req)
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	_, err := env.user.UserSignIn(context.Background(), &profile.UserSignInReq{AuthId: "13800000001", UnitId: unitId, VerifyCode: "new-password1"})
+	assert.NoError(t, err)
+}
+
+func TestUserContact(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := env.signUpUnit(t, "13900000001")
+	userId := env.signUpUser(t, unitId, "13800000001", 0)
+
+	contact := func(modify func(c *dto.Contact)) []*dto.Contact {
+		c := &dto.Contact{Type: "guardian", Name: "张父", Relation: "父亲", Phone: "13700000001", Priority: 1}
+		modify(c)
+		return []*dto.Contact{c}
+	}
+	tests := []struct {
+		name     string
+		role     string
+		contacts []*dto.Contact
+		want     int
+	}{
+		{"no role", "", contact(func(c *dto.Contact) {}), errno.ErrPermissionDenied},
+		{"student", "student", contact(func(c *dto.Contact) {}), errno.ErrPermissionDenied},
+		{"nil contact", "admin", []*dto.Contact{nil}, errno.ErrMissingEntity},
+		{"missing name", "admin", contact(func(c *dto.Contact) { c.Name = "" }), errno.ErrMissingParams},
+		{"missing relation", "admin", contact(func(c *dto.Contact) { c.Relation = "" }), errno.ErrMissingParams},
+		{"invalid phone", "admin", contact(func(c *dto.Contact) { c.Phone = "12345" }), errno.ErrInvalidParams},
+		{"negative priority", "admin", contact(func(c *dto.Contact) { c.Priority = -1 }), errno.ErrInvalidParams},
+		{"invalid type", "admin", contact(func(c *dto.Contact) { c.Type = "friend" }), errno.ErrInvalidParams},
+		{"success", "counselor", []*dto.Contact{
+			{Type: "emergency", Name: "班主任", Relation: "老师", Phone: "13700000002", Priority: 2},
+			{Type: "guardian", Name: "张父", Relation: "父亲", Phone: "13700000001", Priority: 1},
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.user.UserContactUpdate(withRole(tt.role), &dto.UserContactUpdateReq{UserId: userId, Contacts: tt.contacts})
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	_, err := env.user.UserContactList(withRole("student"), &dto.UserContactListReq{UserId: userId})
+	assertErrno(t, errno.ErrPermissionDenied, err)
+	resp, err := env.user.UserContactList(withRole("counselor"), &dto.UserContactListReq{UserId: userId})
+	require.NoError(t, err)
+	require.Len(t, resp.Contacts, 2)
+	// 按优先级排序
+	assert.Equal(t, "guardian", resp.Contacts[0].Type)
+	assert.Equal(t, "emergency", resp.Contacts[1].Type)
+	assert.EqualValues(t, 1, resp.Version)
+}
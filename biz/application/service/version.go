@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/meta"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+)
+
+// requireVersion 获得调用方通过metainfo传入的读取时的版本号, 未传入时返回ErrMissingParams
+// 管理员修改单位及配置时必须携带, 否则并发的修改会互相覆盖
+func requireVersion(ctx context.Context) (int64, error) {
+	v, ok := meta.Version(ctx)
+	if !ok {
+		return 0, errorx.New(errno.ErrMissingParams, errorx.KV("field", "版本号"))
+	}
+	return v, nil
+}
+
+// expectedVersion 调用方通过metainfo传入读取时的版本号, 未传入时使用本次更新前读取到的版本号
+// 后者只能发现本次读取与写入之间的修改, 无法发现调用方读取之后的修改, 因此记录日志和指标以便推动调用方携带版本号
+func expectedVersion(ctx context.Context, method string, current int64) int64 {
+	if v, ok := meta.Version(ctx); ok {
+		return v
+	}
+	logs.Warnf("%s called without version, fallback to %d", method, current)
+	metrics.IncUnversionedWrite(method)
+	return current
+}
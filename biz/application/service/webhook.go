@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/xh-polaris/psych-idl/kitex_gen/basic"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/event"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/random"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IWebhookService = (*WebhookService)(nil)
+
+type IWebhookService interface {
+	WebhookCreate(ctx context.Context, req *dto.WebhookCreateReq) (*dto.WebhookCreateResp, error)
+	WebhookList(ctx context.Context, req *dto.WebhookListReq) (*dto.WebhookListResp, error)
+	WebhookDelete(ctx context.Context, req *dto.WebhookDeleteReq) (*basic.Response, error)
+	WebhookTest(ctx context.Context, req *dto.WebhookTestReq) (*dto.WebhookTestResp, error)
+	WebhookDeliveryList(ctx context.Context, req *dto.WebhookDeliveryListReq) (*dto.WebhookDeliveryListResp, error)
+	WebhookReplay(ctx context.Context, req *dto.WebhookReplayReq) (*basic.Response, error)
+}
+
+// WebhookService 管理单位登记的事件订阅及其投递记录, 仅管理员可用
+type WebhookService struct {
+	WebhookMapper  webhook.IMongoMapper
+	DeliveryMapper delivery.IMongoMapper
+	Deliverer      *event.Deliverer
+	Transactor     mapper.Transactor
+	AuditService   *AuditService
+}
+
+var WebhookServiceSet = wire.NewSet(
+	wire.Struct(new(WebhookService), "*"),
+	wire.Bind(new(IWebhookService), new(*WebhookService)),
+)
+
+// minSecretLength 自行设置的签名密钥的最小长度
+const minSecretLength = 16
+
+func (w *WebhookService) WebhookCreate(ctx context.Context, req *dto.WebhookCreateReq) (*dto.WebhookCreateResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "Webhook", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	unitId, err := primitive.ObjectIDFromHex(req.UnitId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+	if u, err := url.Parse(req.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "Webhook地址"))
+	}
+	// 不允许登记内网、回环及元数据服务等地址
+	if err = event.CheckURL(ctx, req.Url); err != nil {
+		logs.Warnf("check webhook url error: %s", errorx.ErrorWithoutStack(err))
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "Webhook地址"))
+	}
+	if len(req.Types) == 0 {
+		return nil, errorx.New(errno.ErrMissingParams, errorx.KV("field", "事件类型"))
+	}
+	types := make([]string, 0, len(req.Types))
+	for _, t := range req.Types {
+		if !slices.Contains(outbox.Types, t) {
+			return nil, errorx.New(errno.ErrUnsupportedType, errorx.KV("field", "事件"), errorx.KV("type", t))
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = random.GenerateSecret(); err != nil {
+			logs.Errorf("generate webhook secret error: %s", errorx.ErrorWithoutStack(err))
+			return nil, err
+		}
+	} else if len(secret) < minSecretLength {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "签名密钥"))
+	}
+
+	now := time.Now().Unix()
+	webhookDAO := &webhook.Webhook{
+		ID:         primitive.NewObjectID(),
+		UnitID:     unitId,
+		URL:        req.Url,
+		Types:      types,
+		Secret:     secret,
+		Status:     enum.Active,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	if err = w.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := w.WebhookMapper.Insert(ctx, webhookDAO); err != nil {
+			return err
+		}
+		return w.AuditService.Record(ctx, "WebhookCreate", entityWebhook, unitId, webhookDAO.ID, nil, webhookDAO)
+	}); err != nil {
+		logs.Errorf("insert webhook error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+
+	// 密钥只在创建时返回
+	resp := webhookDTO(webhookDAO)
+	resp.Secret = secret
+	return &dto.WebhookCreateResp{Webhook: resp}, nil
+}
+
+func (w *WebhookService) WebhookList(ctx context.Context, req *dto.WebhookListReq) (*dto.WebhookListResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "Webhook", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	unitId, err := primitive.ObjectIDFromHex(req.UnitId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+
+	webhooks, err := w.WebhookMapper.FindActiveByUnitID(ctx, unitId)
+	if err != nil {
+		logs.Errorf("find webhooks error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	resp := &dto.WebhookListResp{Webhooks: make([]*dto.Webhook, 0, len(webhooks))}
+	for _, h := range webhooks {
+		resp.Webhooks = append(resp.Webhooks, webhookDTO(h))
+	}
+	return resp, nil
+}
+
+func (w *WebhookService) WebhookDelete(ctx context.Context, req *dto.WebhookDeleteReq) (*basic.Response, error) {
+	// 鉴权
+	if err := checkRole(ctx, "Webhook", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	webhookDAO, err := w.findWebhook(ctx, req.UnitId, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	update := bson.M{
+		cst.Status:     enum.Deleted,
+		cst.UpdateTime: now,
+		cst.DeleteTime: now,
+	}
+	if err = w.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := w.WebhookMapper.UpdateFields(ctx, webhookDAO.ID, update); err != nil {
+			return err
+		}
+		return w.AuditService.Record(ctx, "WebhookDelete", entityWebhook, webhookDAO.UnitID, webhookDAO.ID, webhookDAO, update)
+	}); err != nil {
+		logs.Errorf("delete webhook error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	return &basic.Response{}, nil
+}
+
+// WebhookTest 同步发送测试事件并记录结果, 失败时直接进入死信, 可通过重放再次发送
+func (w *WebhookService) WebhookTest(ctx context.Context, req *dto.WebhookTestReq) (*dto.WebhookTestResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "Webhook", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	webhookDAO, err := w.findWebhook(ctx, req.UnitId, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	e := &outbox.Event{
+		ID:          primitive.NewObjectID(),
+		Type:        webhook.TypeTest,
+		Aggregate:   entityWebhook,
+		AggregateID: webhookDAO.ID,
+		UnitID:      webhookDAO.UnitID,
+		CreateTime:  now,
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	deliveryDAO := &delivery.Delivery{
+		ID:         primitive.NewObjectID(),
+		WebhookID:  webhookDAO.ID,
+		UnitID:     webhookDAO.UnitID,
+		EventID:    e.ID,
+		Type:       e.Type,
+		Key:        e.Key(),
+		Body:       string(body),
+		Status:     delivery.StatusDelivered,
+		Attempts:   1,
+		CreateTime: now,
+	}
+	code, err := w.Deliverer.Send(ctx, webhookDAO, deliveryDAO)
+	deliveryDAO.ResponseCode = code
+	deliveryDAO.UpdateTime = time.Now().Unix()
+	if err != nil {
+		deliveryDAO.Status, deliveryDAO.LastError = delivery.StatusDead, err.Error()
+	} else {
+		deliveryDAO.DeliverTime = deliveryDAO.UpdateTime
+	}
+	if err = w.DeliveryMapper.Insert(ctx, deliveryDAO); err != nil {
+		logs.Errorf("insert delivery error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	return &dto.WebhookTestResp{Delivery: deliveryDTO(deliveryDAO)}, nil
+}
+
+func (w *WebhookService) WebhookDeliveryList(ctx context.Context, req *dto.WebhookDeliveryListReq) (*dto.WebhookDeliveryListResp, error) {
+	// 鉴权
+	if err := checkRole(ctx, "Webhook", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验, 已删除的订阅仍可查询投递记录
+	webhookDAO, err := w.findAnyWebhook(ctx, req.UnitId, req.WebhookId)
+	if err != nil {
+		return nil, err
+	}
+	switch req.Status {
+	case "", delivery.StatusPending, delivery.StatusDelivered, delivery.StatusDead:
+	default:
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "投递状态"))
+	}
+	page := &mapper.PageOptions{}
+	if p := req.PaginationOptions; p != nil {
+		page.Page, page.Limit = p.GetPage(), p.GetLimit()
+	}
+
+	deliveries, total, err := w.DeliveryMapper.FindPage(ctx, webhookDAO.ID, req.Status, page)
+	if err != nil {
+		logs.Errorf("find deliveries error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	resp := &dto.WebhookDeliveryListResp{Deliveries: make([]*dto.Delivery, 0, len(deliveries)), Total: total}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, deliveryDTO(d))
+	}
+	return resp, nil
+}
+
+// WebhookReplay 将投递记录重置为待投递, 尚在投递中的记录不受影响
+func (w *WebhookService) WebhookReplay(ctx context.Context, req *dto.WebhookReplayReq) (*basic.Response, error) {
+	// 鉴权
+	if err := checkRole(ctx, "Webhook", enum.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// 参数校验
+	deliveryId, err := primitive.ObjectIDFromHex(req.DeliveryId)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "投递ID"))
+	}
+	deliveryDAO, err := w.DeliveryMapper.FindOne(ctx, deliveryId)
+	if errors.Is(err, monc.ErrNotFound) {
+		return nil, errorx.New(errno.ErrNotFound, errorx.KV("field", "投递记录"))
+	} else if err != nil {
+		logs.Errorf("find delivery error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	// 订阅已删除时重放会直接进入死信
+	if _, err = w.findWebhook(ctx, req.UnitId, deliveryDAO.WebhookID.Hex()); err != nil {
+		return nil, err
+	}
+	if deliveryDAO.Status == delivery.StatusPending {
+		return &basic.Response{}, nil
+	}
+
+	if err = w.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := w.DeliveryMapper.UpdateFields(ctx, deliveryId, bson.M{
+			cst.Status:     delivery.StatusPending,
+			cst.Attempts:   0,
+			cst.NextTime:   0,
+			cst.Replays:    deliveryDAO.Replays + 1,
+			cst.UpdateTime: time.Now().Unix(),
+		}); err != nil {
+			return err
+		}
+		return w.AuditService.Record(ctx, "WebhookReplay", entityWebhook, deliveryDAO.UnitID, deliveryDAO.WebhookID, nil, nil)
+	}); err != nil {
+		logs.Errorf("replay delivery error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	w.Deliverer.Notify()
+	return &basic.Response{}, nil
+}
+
+// findWebhook 查询单位未删除的订阅
+func (w *WebhookService) findWebhook(ctx context.Context, unitIdHex, idHex string) (*webhook.Webhook, error) {
+	webhookDAO, err := w.findAnyWebhook(ctx, unitIdHex, idHex)
+	if err != nil {
+		return nil, err
+	}
+	if webhookDAO.Status == enum.Deleted {
+		return nil, errorx.New(errno.ErrNotFound, errorx.KV("field", "Webhook"))
+	}
+	return webhookDAO, nil
+}
+
+// findAnyWebhook 查询单位的订阅, 包括已删除的订阅, 不属于该单位时无权访问
+func (w *WebhookService) findAnyWebhook(ctx context.Context, unitIdHex, idHex string) (*webhook.Webhook, error) {
+	unitId, err := primitive.ObjectIDFromHex(unitIdHex)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "单位ID"))
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, errorx.New(errno.ErrInvalidParams, errorx.KV("field", "Webhook ID"))
+	}
+	webhookDAO, err := w.WebhookMapper.FindOne(ctx, id)
+	if errors.Is(err, monc.ErrNotFound) {
+		return nil, errorx.New(errno.ErrNotFound, errorx.KV("field", "Webhook"))
+	} else if err != nil {
+		logs.Errorf("find webhook error: %s", errorx.ErrorWithoutStack(err))
+		return nil, err
+	}
+	if webhookDAO.UnitID != unitId {
+		return nil, errorx.New(errno.ErrPermissionDenied, errorx.KV("field", "Webhook"))
+	}
+	return webhookDAO, nil
+}
+
+// webhookDTO 不返回签名密钥
+func webhookDTO(w *webhook.Webhook) *dto.Webhook {
+	return &dto.Webhook{
+		Id:         w.ID.Hex(),
+		UnitId:     w.UnitID.Hex(),
+		Url:        w.URL,
+		Types:      w.Types,
+		CreateTime: w.CreateTime,
+		UpdateTime: w.UpdateTime,
+	}
+}
+
+func deliveryDTO(d *delivery.Delivery) *dto.Delivery {
+	return &dto.Delivery{
+		Id:           d.ID.Hex(),
+		WebhookId:    d.WebhookID.Hex(),
+		EventId:      d.EventID.Hex(),
+		Type:         d.Type,
+		Body:         d.Body,
+		Status:       d.Status,
+		Attempts:     int32(d.Attempts),
+		NextTime:     d.NextTime,
+		ResponseCode: int32(d.ResponseCode),
+		LastError:    d.LastError,
+		Replays:      int32(d.Replays),
+		CreateTime:   d.CreateTime,
+		UpdateTime:   d.UpdateTime,
+		DeliverTime:  d.DeliverTime,
+	}
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/biz/application/dto"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// publicURL 公网地址, 登记时不需要解析域名
+const publicURL = "https://93.184.215.14/hook"
+
+func (e *testEnv) createWebhook(t *testing.T, unitId string) *dto.Webhook {
+	resp, err := e.webhook.WebhookCreate(withRole("admin"), &dto.WebhookCreateReq{UnitId: unitId, Url: publicURL, Types: []string{outbox.TypeUserCreated}})
+	require.NoError(t, err)
+	return resp.Webhook
+}
+
+func TestWebhookCreate(t *testing.T) {
+	env := newTestEnv(t)
+	unitId := primitive.NewObjectID().Hex()
+
+	newReq := func(modify func(r *dto.WebhookCreateReq)) *dto.WebhookCreateReq {
+		r := &dto.WebhookCreateReq{UnitId: unitId, Url: publicURL, Types: []string{outbox.TypeUserCreated}}
+		modify(r)
+		return r
+	}
+	tests := []struct {
+		name string
+		role string
+		req  *dto.WebhookCreateReq
+		want int
+	}{
+		{"counselor", "counselor", newReq(func(r *dto.WebhookCreateReq) {}), errno.ErrPermissionDenied},
+		{"invalid unit", "admin", newReq(func(r *dto.WebhookCreateReq) { r.UnitId = "unit" }), errno.ErrInvalidParams},
+		{"invalid scheme", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Url = "ftp://93.184.215.14/hook" }), errno.ErrInvalidParams},
+		{"missing host", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Url = "https:///hook" }), errno.ErrInvalidParams},
+		{"loopback", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Url = "http://127.0.0.1:8080/hook" }), errno.ErrInvalidParams},
+		{"private", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Url = "http://10.0.0.8/hook" }), errno.ErrInvalidParams},
+		{"metadata", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Url = "http://169.254.169.254/latest/meta-data" }), errno.ErrInvalidParams},
+		{"missing types", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Types = nil }), errno.ErrMissingParams},
+		{"unknown type", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Types = []string{"user.unknown"} }), errno.ErrUnsupportedType},
+		{"short secret", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Secret = "short" }), errno.ErrInvalidParams},
+		{"custom secret", "admin", newReq(func(r *dto.WebhookCreateReq) { r.Secret = "whsec-0123456789abcdef" }), 0},
+		{"generated secret", "admin", newReq(func(r *dto.WebhookCreateReq) {
+			r.Types = []string{outbox.TypeUserCreated, outbox.TypeUserGraduated, outbox.TypeUserCreated}
+		}), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.webhook.WebhookCreate(withRole(tt.role), tt.req)
+			assertErrno(t, tt.want, err)
+			if tt.want == 0 {
+				assert.GreaterOrEqual(t, len(resp.Webhook.Secret), minSecretLength)
+				if tt.req.Secret != "" {
+					assert.Equal(t, tt.req.Secret, resp.Webhook.Secret)
+				}
+			}
+		})
+	}
+
+	// 重复的事件类型只保留一个, 列表中不返回密钥
+	list, err := env.webhook.WebhookList(withRole("admin"), &dto.WebhookListReq{UnitId: unitId})
+	require.NoError(t, err)
+	require.Len(t, list.Webhooks, 2)
+	assert.Equal(t, []string{outbox.TypeUserCreated, outbox.TypeUserGraduated}, list.Webhooks[1].Types)
+	for _, w := range list.Webhooks {
+		assert.Empty(t, w.Secret)
+	}
+}
+
+// 只能管理本单位的订阅, 删除后不再列出但仍可查询投递记录
+func TestWebhookPermission(t *testing.T) {
+	env := newTestEnv(t)
+	unitId, otherUnitId := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	w := env.createWebhook(t, unitId)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		fn   func(ctx context.Context) error
+		want int
+	}{
+		{"list as student", withRole("student"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookList(ctx, &dto.WebhookListReq{UnitId: unitId})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"delete from other unit", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDelete(ctx, &dto.WebhookDeleteReq{UnitId: otherUnitId, Id: w.Id})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"test from other unit", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookTest(ctx, &dto.WebhookTestReq{UnitId: otherUnitId, Id: w.Id})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"deliveries from other unit", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDeliveryList(ctx, &dto.WebhookDeliveryListReq{UnitId: otherUnitId, WebhookId: w.Id})
+			return err
+		}, errno.ErrPermissionDenied},
+		{"invalid delivery status", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDeliveryList(ctx, &dto.WebhookDeliveryListReq{UnitId: unitId, WebhookId: w.Id, Status: "unknown"})
+			return err
+		}, errno.ErrInvalidParams},
+		{"delete unknown", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDelete(ctx, &dto.WebhookDeleteReq{UnitId: unitId, Id: primitive.NewObjectID().Hex()})
+			return err
+		}, errno.ErrNotFound},
+		{"delete", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDelete(ctx, &dto.WebhookDeleteReq{UnitId: unitId, Id: w.Id})
+			return err
+		}, 0},
+		{"delete again", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDelete(ctx, &dto.WebhookDeleteReq{UnitId: unitId, Id: w.Id})
+			return err
+		}, errno.ErrNotFound},
+		{"test deleted", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookTest(ctx, &dto.WebhookTestReq{UnitId: unitId, Id: w.Id})
+			return err
+		}, errno.ErrNotFound},
+		{"deliveries of deleted", withRole("admin"), func(ctx context.Context) error {
+			_, err := env.webhook.WebhookDeliveryList(ctx, &dto.WebhookDeliveryListReq{UnitId: unitId, WebhookId: w.Id})
+			return err
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertErrno(t, tt.want, tt.fn(tt.ctx))
+		})
+	}
+
+	list, err := env.webhook.WebhookList(withRole("admin"), &dto.WebhookListReq{UnitId: unitId})
+	require.NoError(t, err)
+	assert.Empty(t, list.Webhooks)
+}
+
+// 登记后地址解析到内网时, 测试投递在连接前被拒绝并进入死信, 重放后重新等待投递
+func TestWebhookTestAndReplay(t *testing.T) {
+	ctx := withRole("admin")
+	env := newTestEnv(t)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+	}))
+	t.Cleanup(server.Close)
+
+	unitId := primitive.NewObjectID()
+	w := &webhook.Webhook{
+		ID:     primitive.NewObjectID(),
+		UnitID: unitId,
+		URL:    server.URL,
+		Types:  []string{outbox.TypeUserCreated},
+		Secret: "whsec-0123456789abcdef",
+		Status: enum.Active,
+	}
+	require.NoError(t, env.webhooks.Insert(context.Background(), w))
+
+	resp, err := env.webhook.WebhookTest(ctx, &dto.WebhookTestReq{UnitId: unitId.Hex(), Id: w.ID.Hex()})
+	require.NoError(t, err)
+	assert.Equal(t, delivery.StatusDead, resp.Delivery.Status)
+	assert.Equal(t, webhook.TypeTest, resp.Delivery.Type)
+	assert.Contains(t, resp.Delivery.LastError, "forbidden")
+	assert.Zero(t, resp.Delivery.ResponseCode)
+	assert.Zero(t, calls.Load())
+
+	deliveryId := resp.Delivery.Id
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *dto.WebhookReplayReq
+		want int
+	}{
+		{"counselor", withRole("counselor"), &dto.WebhookReplayReq{UnitId: unitId.Hex(), DeliveryId: deliveryId}, errno.ErrPermissionDenied},
+		{"invalid delivery", ctx, &dto.WebhookReplayReq{UnitId: unitId.Hex(), DeliveryId: "delivery"}, errno.ErrInvalidParams},
+		{"unknown delivery", ctx, &dto.WebhookReplayReq{UnitId: unitId.Hex(), DeliveryId: primitive.NewObjectID().Hex()}, errno.ErrNotFound},
+		{"other unit", ctx, &dto.WebhookReplayReq{UnitId: primitive.NewObjectID().Hex(), DeliveryId: deliveryId}, errno.ErrPermissionDenied},
+		{"replay", ctx, &dto.WebhookReplayReq{UnitId: unitId.Hex(), DeliveryId: deliveryId}, 0},
+		// 待投递的记录不重复重放
+		{"replay pending", ctx, &dto.WebhookReplayReq{UnitId: unitId.Hex(), DeliveryId: deliveryId}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.webhook.WebhookReplay(tt.ctx, tt.req)
+			assertErrno(t, tt.want, err)
+		})
+	}
+
+	list, err := env.webhook.WebhookDeliveryList(ctx, &dto.WebhookDeliveryListReq{UnitId: unitId.Hex(), WebhookId: w.ID.Hex(), Status: delivery.StatusPending})
+	require.NoError(t, err)
+	require.Len(t, list.Deliveries, 1)
+	assert.Equal(t, deliveryId, list.Deliveries[0].Id)
+	assert.Zero(t, list.Deliveries[0].Attempts)
+	assert.EqualValues(t, 1, list.Deliveries[0].Replays)
+}
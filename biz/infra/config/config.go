@@ -2,32 +2,97 @@ package config
 
 import (
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/zeromicro/go-zero/core/conf"
 	"github.com/zeromicro/go-zero/core/service"
 	"github.com/zeromicro/go-zero/core/stores/cache"
 )
 
-var config *Config
+// 存储后端
+const (
+	StorageMongo  = "mongo"
+	StorageMemory = "memory" // 数据只保存在进程内, 用于测试及本地运行, 不需要mongo及redis
+)
+
+var (
+	config atomic.Pointer[Config]
+	path   string
+)
 
 type Config struct {
+	// Prometheus指标由DevServer在DevServer.Port的DevServer.MetricsPath上暴露
 	service.ServiceConf
 	ListenOn string
 	State    string
+	Storage  string `json:",default=mongo,options=mongo|memory"`
 	Mongo    struct {
-		URL string
-		DB  string
+		URL          string `json:",optional"` // Storage为mongo时必填
+		DB           string `json:",optional"`
+		AutoMigrate  bool   `json:",default=true"` // 启动时执行尚未执行的数据迁移
+		AutoIndex    bool   `json:",default=true"` // 启动时创建缺失的索引, 由DBA管理索引时关闭
+		Transactions bool   `json:",default=true"` // 多实体写入使用事务, 默认开启. 事务需要副本集或分片集群, 单机mongo上开启时所有写入都会失败, 单机部署必须关闭, 关闭后不再保证原子性
+	}
+	Cache       cache.CacheConf `json:",optional"` // Storage为mongo时必填
+	MapperCache struct {
+		Enabled        bool          `json:",default=true"` // 按ID及唯一字段查询单个实体时使用缓存
+		Expiry         time.Duration `json:",default=1h"`
+		NotFoundExpiry time.Duration `json:",default=1m"` // 不存在的实体的占位有效期
+	}
+	Pseudonym struct {
+		Key string // 生成用户假名的HMAC密钥, 必填, 更换后所有假名都会改变, 需要删除user_pseudonym迁移记录后重新迁移
+	}
+	Reload struct {
+		Interval time.Duration `json:",default=5s"` // 检查配置文件变化的间隔, 只有Dynamic中列出的配置会热更新
+	}
+	RPCLog struct {
+		SampleRate    float64       `json:",default=1"`  // 成功请求的日志采样率, 失败及慢请求总是记录
+		SlowThreshold time.Duration `json:",default=1s"` // 超过该耗时的请求以warn级别记录
+	}
+	Health struct {
+		ListenOn string        `json:",default=0.0.0.0:8081"` // 健康检查HTTP服务地址, 提供/livez及/readyz
+		Timeout  time.Duration `json:",default=2s"`           // 单项依赖检查的超时时间
+	}
+	Drain struct {
+		Timeout time.Duration `json:",default=30s"` // 收到退出信号后等待进行中请求及后台任务的最长时间
+	}
+	Password struct {
+		MinLength          int  `json:",optional"` // 自行设置密码的最小长度, 0表示不限制
+		RequireLetterDigit bool `json:",optional"` // 是否要求同时包含字母和数字
+	}
+	Encryption struct {
+		KeyFile string `json:",optional"` // 字段级加密的密钥文件, 为空时不加密, 盲索引密钥由Pseudonym.Key派生
+	}
+	Outbox struct {
+		Dispatch    bool          `json:",optional"`                        // 是否在本实例投递事件及单位webhook, 默认关闭, 只能在一个实例开启, 多个实例同时投递会打乱同一聚合内的顺序
+		Publisher   string        `json:",default=log,options=log|webhook"` // 事件发布方式
+		Interval    time.Duration `json:",default=1s"`                      // 轮询待投递事件的间隔, 也是重试退避的初始间隔
+		BatchSize   int           `json:",default=100"`                     // 每轮最多投递的事件数
+		MaxAttempts int           `json:",default=10"`                      // 超过后事件不再投递
+		MaxBackoff  time.Duration `json:",default=10m"`                     // 重试退避的最长间隔
+		Webhook     struct {
+			URL     string        `json:",optional"` // Publisher为webhook时必填
+			Timeout time.Duration `json:",default=5s"`
+		}
+	}
+	ConfigRevision struct {
+		Keep int `json:",default=50"` // 每个配置保留的修订数, 超出时删除最早的修订, 0表示不删除
+	}
+	UnitWebhook struct {
+		Timeout     time.Duration `json:",default=5s"`  // 单次投递的超时时间
+		BatchSize   int           `json:",default=100"` // 每轮最多投递的记录数, 轮询间隔与Outbox.Interval相同
+		MaxAttempts int           `json:",default=8"`   // 超过后进入死信, 需要手动重放
+		MaxBackoff  time.Duration `json:",default=1h"`  // 重试退避的最长间隔
 	}
-	Cache cache.CacheConf
 }
 
 func NewConfig() (*Config, error) {
-	c := new(Config)
-	path := os.Getenv("CONFIG_PATH")
+	path = os.Getenv("CONFIG_PATH")
 	if path == "" {
 		path = "etc/config.yaml"
 	}
-	err := conf.Load(path, c)
+	c, err := load(path)
 	if err != nil {
 		return nil, err
 	}
@@ -35,10 +100,23 @@ func NewConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	config = c
+	config.Store(c)
+	loaded = c
 	return c, nil
 }
 
+// GetConfig 获得当前生效的配置, 热更新后返回新的实例, 调用方不应长期持有
 func GetConfig() *Config {
-	return config
+	return config.Load()
+}
+
+func load(path string) (*Config, error) {
+	c := new(Config)
+	if err := conf.Load(path, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
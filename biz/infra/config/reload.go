@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// Dynamic 允许热更新的配置项, 其余配置的修改需要重启才能生效
+var Dynamic = []string{"Log.Level", "RPCLog", "Password"}
+
+var (
+	mu        sync.Mutex
+	listeners []func(prev, cur *Config)
+	loaded    *Config // 最近一次从文件加载的配置, 用于发现需要重启的修改
+)
+
+// Validate 校验配置中go-zero标签无法表达的约束
+func (c *Config) Validate() error {
+	if c.Storage == StorageMongo && (c.Mongo.URL == "" || c.Mongo.DB == "" || len(c.Cache) == 0) {
+		return errors.New("Mongo.URL, Mongo.DB and Cache are required when Storage is mongo")
+	}
+	// 空密钥生成的假名可以被任何人重新计算
+	if c.Pseudonym.Key == "" {
+		return errors.New("Pseudonym.Key is required")
+	}
+	if c.RPCLog.SampleRate < 0 || c.RPCLog.SampleRate > 1 {
+		return errors.New("RPCLog.SampleRate must be in [0, 1]")
+	}
+	if c.RPCLog.SlowThreshold < 0 {
+		return errors.New("RPCLog.SlowThreshold must not be negative")
+	}
+	// bcrypt只使用密码的前72字节
+	if c.Password.MinLength < 0 || c.Password.MinLength > 72 {
+		return errors.New("Password.MinLength must be in [0, 72]")
+	}
+	if c.Outbox.Publisher == "webhook" && c.Outbox.Webhook.URL == "" {
+		return errors.New("Outbox.Webhook.URL is required when Outbox.Publisher is webhook")
+	}
+	if c.Outbox.Interval <= 0 || c.Outbox.BatchSize <= 0 || c.Outbox.MaxAttempts <= 0 {
+		return errors.New("Outbox.Interval, Outbox.BatchSize and Outbox.MaxAttempts must be positive")
+	}
+	if c.UnitWebhook.BatchSize <= 0 || c.UnitWebhook.MaxAttempts <= 0 {
+		return errors.New("UnitWebhook.BatchSize and UnitWebhook.MaxAttempts must be positive")
+	}
+	return nil
+}
+
+// Watch 订阅配置中某一部分的变化, 订阅时以当前值调用一次, 之后仅在取值变化时调用
+func Watch[T comparable](selector func(*Config) T, fn func(T)) {
+	mu.Lock()
+	defer mu.Unlock()
+	fn(selector(GetConfig()))
+	listeners = append(listeners, func(prev, cur *Config) {
+		if v := selector(cur); v != selector(prev) {
+			fn(v)
+		}
+	})
+}
+
+// StartReload 定期检查配置文件, 内容变化时重新加载Dynamic中的配置
+func StartReload() {
+	interval := GetConfig().Reload.Interval
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		last, _ := digest(path)
+		for range time.Tick(interval) {
+			sum, err := digest(path)
+			if err != nil {
+				logs.Errorf("read config %s error: %s", path, err)
+				continue
+			}
+			if bytes.Equal(sum, last) {
+				continue
+			}
+			last = sum
+			if err = Reload(); err != nil {
+				logs.Errorf("reload config error, keep previous config: %s", err)
+			}
+		}
+	}()
+}
+
+// Reload 加载并校验配置文件, 通过后替换Dynamic中的配置并通知订阅者, 失败时保持原配置
+func Reload() error {
+	next, err := load(path)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(static(loaded), static(next)) {
+		logs.Warnf("config changed outside dynamic settings %v, restart to apply them", Dynamic)
+	}
+	loaded = next
+
+	prev := GetConfig()
+	cur := *prev
+	cur.Log.Level = next.Log.Level
+	cur.RPCLog = next.RPCLog
+	cur.Password = next.Password
+	config.Store(&cur)
+	logs.Infof("config reloaded: log.level=%s, rpcLog=%+v, password=%+v", cur.Log.Level, cur.RPCLog, cur.Password)
+	for _, l := range listeners {
+		l(prev, &cur)
+	}
+	return nil
+}
+
+// static 去掉Dynamic中的配置后的副本
+func static(c *Config) *Config {
+	var zero Config
+	s := *c
+	s.Log.Level = zero.Log.Level
+	s.RPCLog, s.Password = zero.RPCLog, zero.Password
+	return &s
+}
+
+func digest(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
@@ -0,0 +1,226 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+)
+
+// 密文格式: enc:v2:<主密钥ID>:<base64(被主密钥加密的数据密钥)>:<base64(数据密文)>
+// v2的数据密文以AAD绑定所属的字段及文档, v1没有绑定, 只用于读取历史数据
+const (
+	cipherPrefix    = "enc:"
+	cipherVersion   = "v2"
+	cipherVersionV1 = "v1"
+	dekSize         = 32
+)
+
+// indexLabel 未配置密钥文件时从假名密钥派生盲索引密钥
+const indexLabel = "psych-profile/blind-index"
+
+var ErrMalformedCipher = errors.New("crypto: malformed ciphertext")
+
+// Keyring 字段级信封加密的密钥环
+// 每个值使用随机的数据密钥(DEK)加密, DEK再由主密钥(KEK)加密后与密文一起存储
+// 未配置密钥文件时密钥环处于关闭状态, 加密直接返回明文, 盲索引仍使用派生的密钥计算
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+	index  []byte
+}
+
+// keyFile 密钥文件的格式, 所有密钥均为base64编码的32字节随机数
+// 轮换密钥时新增一个主密钥并修改active, 旧密钥需保留到重新加密任务完成
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+	Index  string            `json:"index"` // 盲索引密钥, 更换或首次启用加密后需要执行重新加密任务重建盲索引
+}
+
+func NewKeyring(c *config.Config) (*Keyring, error) {
+	if c.Encryption.KeyFile == "" {
+		mac := hmac.New(sha256.New, []byte(c.Pseudonym.Key))
+		mac.Write([]byte(indexLabel))
+		return &Keyring{index: mac.Sum(nil)}, nil
+	}
+	return LoadKeyring(c.Encryption.KeyFile)
+}
+
+// LoadKeyring 从本地密钥文件加载密钥环
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{active: f.Active, keys: make(map[string][]byte, len(f.Keys))}
+	for id, encoded := range f.Keys {
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("crypto: invalid key id %q", id)
+		}
+		if k.keys[id], err = decodeKey(encoded); err != nil {
+			return nil, fmt.Errorf("crypto: key %q: %w", id, err)
+		}
+	}
+	if _, ok := k.keys[k.active]; !ok {
+		return nil, fmt.Errorf("crypto: active key %q not found", k.active)
+	}
+	if k.index, err = decodeKey(f.Index); err != nil {
+		return nil, fmt.Errorf("crypto: index key: %w", err)
+	}
+	return k, nil
+}
+
+// Enabled 是否配置了密钥
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.active != ""
+}
+
+// AAD 密文的附加数据, 绑定集合、字段及文档ID, 被复制到其他字段或文档的密文无法解密
+func AAD(collection, field, id string) string {
+	return collection + "." + field + ":" + id
+}
+
+// Encrypt 使用当前主密钥加密明文并绑定aad, 空串不加密
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	if !k.Enabled() || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.active], dek, nil)
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dek, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return cipherPrefix + cipherVersion + ":" + k.active + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt 解密Encrypt的结果, aad须与加密时一致, 未加密的历史数据原样返回
+func (k *Keyring) Decrypt(ciphertext, aad string) (string, error) {
+	if !IsEncrypted(ciphertext) {
+		return ciphertext, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(ciphertext, cipherPrefix), ":")
+	if len(parts) != 4 || (parts[0] != cipherVersion && parts[0] != cipherVersionV1) {
+		return "", ErrMalformedCipher
+	}
+	if parts[0] == cipherVersionV1 {
+		aad = ""
+	}
+	if k == nil {
+		return "", fmt.Errorf("crypto: key %q not found", parts[1])
+	}
+	kek, ok := k.keys[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("crypto: key %q not found", parts[1])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformedCipher
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", ErrMalformedCipher
+	}
+	dek, err := open(kek, wrapped, nil)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, data, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Stale 值是否需要重新加密: 未加密(且密钥环已开启)、不是由当前主密钥加密或为没有绑定AAD的v1密文
+func (k *Keyring) Stale(value string) bool {
+	if !k.Enabled() || value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, cipherPrefix+cipherVersion+":"+k.active+":")
+}
+
+// BlindIndex 计算确定性的盲索引, 用于对加密字段做精确匹配查询
+// 密钥环关闭时同样计算, 开启加密前后索引的形式一致, 不会留下明文副本
+func (k *Keyring) BlindIndex(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted 判断值是否为Encrypt生成的密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, cipherPrefix)
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != dekSize {
+		return nil, fmt.Errorf("key must be %d bytes", dekSize)
+	}
+	return key, nil
+}
+
+// seal AES-256-GCM加密, nonce置于密文之前
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrMalformedCipher
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
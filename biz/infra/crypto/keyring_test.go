@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, dekSize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	data, err := json.Marshal(keyFile{Active: "k1", Keys: map[string]string{"k1": newKey(t)}, Index: newKey(t)})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	k, err := LoadKeyring(path)
+	require.NoError(t, err)
+	return k
+}
+
+func TestEncryptBindsAAD(t *testing.T) {
+	k := newKeyring(t)
+	aad := AAD("user", "name", "1")
+	enc, err := k.Encrypt("张三", aad)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enc, "enc:v2:k1:"))
+	assert.False(t, k.Stale(enc))
+
+	dec, err := k.Decrypt(enc, aad)
+	require.NoError(t, err)
+	assert.Equal(t, "张三", dec)
+
+	tests := []struct {
+		name string
+		aad  string
+	}{
+		{"other field", AAD("user", "code", "1")},
+		{"other document", AAD("user", "name", "2")},
+		{"other collection", AAD("unit", "name", "1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := k.Decrypt(enc, tt.aad)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDecryptV1(t *testing.T) {
+	k := newKeyring(t)
+	dek := make([]byte, dekSize)
+	_, err := rand.Read(dek)
+	require.NoError(t, err)
+	wrapped, err := seal(k.keys["k1"], dek, nil)
+	require.NoError(t, err)
+	data, err := seal(dek, []byte("13800000000"), nil)
+	require.NoError(t, err)
+	v1 := "enc:v1:k1:" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(data)
+
+	dec, err := k.Decrypt(v1, AAD("unit", "phone", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "13800000000", dec)
+	assert.True(t, k.Stale(v1))
+}
+
+func TestDisabledKeyring(t *testing.T) {
+	c := &config.Config{}
+	c.Pseudonym.Key = "secret"
+	k, err := NewKeyring(c)
+	require.NoError(t, err)
+	assert.False(t, k.Enabled())
+
+	enc, err := k.Encrypt("张三", AAD("user", "name", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "张三", enc)
+
+	// 未开启加密时盲索引同样是带密钥的HMAC, 不保存明文
+	index := k.BlindIndex("13800000000")
+	assert.NotEqual(t, "13800000000", index)
+	assert.Len(t, index, 64)
+	assert.Equal(t, index, k.BlindIndex("13800000000"))
+	assert.Empty(t, k.BlindIndex(""))
+
+	c.Pseudonym.Key = "other"
+	other, err := NewKeyring(c)
+	require.NoError(t, err)
+	assert.NotEqual(t, index, other.BlindIndex("13800000000"))
+}
@@ -2,25 +2,52 @@ package cst
 
 // 数据库相关
 const (
-	ID         = "_id"
-	Status     = "status"
-	Phone      = "phone"
-	StudentID  = "studentId"
-	Code       = "code"
-	Name       = "name"
-	UnitID     = "unitId"
-	Gender     = "gender"
-	Birth      = "birth"
-	EnrollYear = "enrollYear"
-	Grade      = "grade"
-	Class      = "class"
-	Address    = "address"
-	Contact    = "contact"
-	Options    = "options"
-	CreateTime = "createTime"
-	UpdateTime = "updateTime"
-	DeleteTime = "deleteTime"
-	Password   = "password"
+	ID           = "_id"
+	Status       = "status"
+	Phone        = "phone"
+	StudentID    = "studentId"
+	Code         = "code"
+	Name         = "name"
+	UnitID       = "unitId"
+	Gender       = "gender"
+	Birth        = "birth"
+	EnrollYear   = "enrollYear"
+	Grade        = "grade"
+	Class        = "class"
+	Address      = "address"
+	Contact      = "contact"
+	Contacts     = "contacts"
+	Options      = "options"
+	CreateTime   = "createTime"
+	UpdateTime   = "updateTime"
+	DeleteTime   = "deleteTime"
+	Password     = "password"
+	Type         = "type"
+	Version      = "version"
+	UserID       = "userId"
+	AgePolicy    = "agePolicy"
+	Pseudonym    = "pseudonym"
+	CodeIndex    = "codeIndex"
+	PhoneIndex   = "phoneIndex"
+	BirthCipher  = "birthCipher"
+	Actor        = "actor"
+	Entity       = "entity"
+	EntityID     = "entityId"
+	Attempts     = "attempts"
+	NextTime     = "nextTime"
+	LastError    = "lastError"
+	DeliverTime  = "deliverTime"
+	WebhookID    = "webhookId"
+	EventID      = "eventId"
+	Replays      = "replays"
+	ResponseCode = "responseCode"
+	ConfigID     = "configId"
+	Number       = "number"
+	Secret       = "secret"
+	Aggregate    = "aggregate"
+	AggregateID  = "aggregateId"
+	Published    = "published"
+	Key          = "key"
 )
 
 // 前端字段相关
@@ -0,0 +1,152 @@
+package event
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/graceful"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var dispatcher *Dispatcher
+
+// Dispatcher 定期投递outbox中待投递的事件, 只能在一个实例上开启
+// 同一聚合的事件按产生顺序投递, 前一个事件失败、等待重试或进入死信时后续事件不投递
+// 死信需要人工处理, 将其status重置为pending重新投递, 或确认无需投递后置为delivered, 之后同一聚合的事件恢复投递
+type Dispatcher struct {
+	mapper      outbox.IMongoMapper
+	publishers  []Publisher // 依次发布, 失败时只重试尚未成功的发布方式
+	enabled     bool
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	maxBackoff  time.Duration
+	wake        chan struct{}
+}
+
+// NewDispatcher 事件发布到Outbox.Publisher后, 再由deliverer投递给订阅了该事件的单位webhook
+func NewDispatcher(c *config.Config, mapper outbox.IMongoMapper, deliverer *Deliverer) *Dispatcher {
+	dispatcher = &Dispatcher{
+		mapper:      mapper,
+		publishers:  []Publisher{NewPublisher(c), deliverer},
+		enabled:     c.Outbox.Dispatch,
+		interval:    c.Outbox.Interval,
+		batchSize:   c.Outbox.BatchSize,
+		maxAttempts: c.Outbox.MaxAttempts,
+		maxBackoff:  c.Outbox.MaxBackoff,
+		wake:        make(chan struct{}, 1),
+	}
+	return dispatcher
+}
+
+// GetDispatcher 获得进程内的投递任务, 在NewDispatcher之前调用返回nil
+func GetDispatcher() *Dispatcher {
+	return dispatcher
+}
+
+// Start 启动投递任务, 开始排空后退出, 未开启Outbox.Dispatch时不投递
+func (d *Dispatcher) Start() {
+	if !d.enabled {
+		logs.Infof("outbox dispatch disabled on this instance")
+		return
+	}
+	graceful.Go("outbox dispatcher", func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for !graceful.Draining() {
+			d.Dispatch(context.Background())
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			}
+		}
+	})
+}
+
+// Notify 有新事件时提前开始下一轮投递, 不会阻塞
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch 执行一轮投递
+func (d *Dispatcher) Dispatch(ctx context.Context) {
+	events, err := d.mapper.FindPending(ctx, d.batchSize)
+	if err != nil {
+		logs.Errorf("find pending events error: %s", errorx.ErrorWithoutStack(err))
+		return
+	}
+	// 等待重试及有死信的聚合已被排除, 本轮失败的聚合跳过后续事件
+	blocked := map[string]bool{}
+	for _, e := range events {
+		if blocked[e.Key()] {
+			continue
+		}
+		now := time.Now()
+		if err = d.publish(ctx, e); err != nil {
+			blocked[e.Key()] = true
+			d.retry(ctx, e, err)
+			continue
+		}
+		metrics.IncEvent(e.Type, metrics.EventDelivered)
+		if err = d.mapper.UpdateFields(ctx, e.ID, bson.M{
+			cst.Status:      outbox.StatusDelivered,
+			cst.DeliverTime: now.Unix(),
+		}); err != nil {
+			// 下一轮会重复投递, 订阅方按ID去重
+			logs.Errorf("mark event %s delivered error: %s", e.ID.Hex(), errorx.ErrorWithoutStack(err))
+			blocked[e.Key()] = true
+		}
+	}
+}
+
+// publish 依次发布到尚未成功的发布方式, 成功的记录在e.Published中, 避免重试时重复发布
+func (d *Dispatcher) publish(ctx context.Context, e *outbox.Event) error {
+	for _, p := range d.publishers {
+		if slices.Contains(e.Published, p.Name()) {
+			continue
+		}
+		if err := p.Publish(ctx, e); err != nil {
+			return err
+		}
+		e.Published = append(e.Published, p.Name())
+	}
+	return nil
+}
+
+// retry 按指数退避安排重试, 同时保存已成功的发布方式
+// 超过最大次数后进入死信, 同一聚合的后续事件在人工处理死信前不再投递
+func (d *Dispatcher) retry(ctx context.Context, e *outbox.Event, cause error) {
+	attempts := e.Attempts + 1
+	update := bson.M{cst.Attempts: attempts, cst.LastError: cause.Error(), cst.Published: e.Published}
+	if attempts >= d.maxAttempts {
+		update[cst.Status] = outbox.StatusDead
+		metrics.IncEvent(e.Type, metrics.EventDead)
+		logs.Errorf("event %s dead after %d attempts: %s", e.ID.Hex(), attempts, cause)
+	} else {
+		update[cst.NextTime] = time.Now().Add(backoff(d.interval, d.maxBackoff, attempts)).Unix()
+		metrics.IncEvent(e.Type, metrics.EventRetry)
+		logs.Warnf("publish event %s error, attempts=%d: %s", e.ID.Hex(), attempts, cause)
+	}
+	if err := d.mapper.UpdateFields(ctx, e.ID, update); err != nil {
+		logs.Errorf("update event %s error: %s", e.ID.Hex(), errorx.ErrorWithoutStack(err))
+	}
+}
+
+// backoff 第n次失败后的等待时间, 从base开始翻倍, 不超过max
+func backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	return min(d, max)
+}
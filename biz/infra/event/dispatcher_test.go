@@ -0,0 +1,144 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testPublisher 记录发布过的事件, fail为true时返回错误
+type testPublisher struct {
+	name      string
+	fail      bool
+	published []primitive.ObjectID
+}
+
+func (p *testPublisher) Name() string {
+	return p.name
+}
+
+func (p *testPublisher) Publish(_ context.Context, e *outbox.Event) error {
+	if p.fail {
+		return errors.New("publish failed")
+	}
+	p.published = append(p.published, e.ID)
+	return nil
+}
+
+func newTestDispatcher(publishers ...Publisher) (*Dispatcher, outbox.IMongoMapper) {
+	m := outbox.NewMemoryMapper()
+	return &Dispatcher{
+		mapper:      m,
+		publishers:  publishers,
+		interval:    time.Millisecond,
+		batchSize:   10,
+		maxAttempts: 2,
+		maxBackoff:  time.Millisecond,
+	}, m
+}
+
+func insertEvent(t *testing.T, m outbox.IMongoMapper, aggregateID primitive.ObjectID, createTime int64) *outbox.Event {
+	e := &outbox.Event{
+		ID:          primitive.NewObjectID(),
+		Type:        outbox.TypeUserCreated,
+		Aggregate:   outbox.AggregateUser,
+		AggregateID: aggregateID,
+		Status:      outbox.StatusPending,
+		CreateTime:  createTime,
+	}
+	require.NoError(t, m.Insert(context.Background(), e))
+	return e
+}
+
+func TestDispatchOrder(t *testing.T) {
+	ctx := context.Background()
+	p := &testPublisher{name: "test"}
+	d, m := newTestDispatcher(p)
+	aggregate := primitive.NewObjectID()
+	second := insertEvent(t, m, aggregate, 2)
+	first := insertEvent(t, m, aggregate, 1)
+
+	d.Dispatch(ctx)
+	assert.Equal(t, []primitive.ObjectID{first.ID, second.ID}, p.published)
+	pending, err := m.FindPending(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestDispatchSkipsPublished(t *testing.T) {
+	ctx := context.Background()
+	ok := &testPublisher{name: "ok"}
+	failing := &testPublisher{name: "failing", fail: true}
+	d, m := newTestDispatcher(ok, failing)
+	d.maxAttempts = 3
+	e := insertEvent(t, m, primitive.NewObjectID(), 1)
+
+	d.Dispatch(ctx)
+	// 到重试时间后才会被查询到
+	time.Sleep(time.Second)
+	pending, err := m.FindPending(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, []string{"ok"}, pending[0].Published)
+
+	// 重试时只发布尚未成功的发布方式
+	failing.fail = false
+	d.Dispatch(ctx)
+	assert.Equal(t, []primitive.ObjectID{e.ID}, ok.published)
+	assert.Equal(t, []primitive.ObjectID{e.ID}, failing.published)
+}
+
+func TestDispatchDeadBlocksAggregate(t *testing.T) {
+	ctx := context.Background()
+	p := &testPublisher{name: "test", fail: true}
+	d, m := newTestDispatcher(p)
+	d.maxAttempts = 1
+	aggregate, other := primitive.NewObjectID(), primitive.NewObjectID()
+	dead := insertEvent(t, m, aggregate, 1)
+
+	d.Dispatch(ctx)
+	pending, err := m.FindPending(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// 死信之后同一聚合的事件不投递, 其他聚合不受影响
+	p.fail = false
+	blocked := insertEvent(t, m, aggregate, 2)
+	free := insertEvent(t, m, other, 3)
+	d.Dispatch(ctx)
+	assert.Equal(t, []primitive.ObjectID{free.ID}, p.published)
+
+	// 人工将死信重置为pending后恢复投递
+	require.NoError(t, m.UpdateFields(ctx, dead.ID, bson.M{cst.Status: outbox.StatusPending}))
+	d.Dispatch(ctx)
+	assert.Equal(t, []primitive.ObjectID{free.ID, dead.ID, blocked.ID}, p.published)
+}
+
+// 等待重试及排在死信之后的事件超过一轮的数量时, 不占用查询窗口, 其他聚合仍能投递
+func TestDispatchBlockedBeyondBatch(t *testing.T) {
+	ctx := context.Background()
+	p := &testPublisher{name: "test"}
+	d, m := newTestDispatcher(p)
+	dead, waiting, free := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	deadEvent := insertEvent(t, m, dead, 1)
+	require.NoError(t, m.UpdateFields(ctx, deadEvent.ID, bson.M{cst.Status: outbox.StatusDead}))
+	retrying := insertEvent(t, m, waiting, 1)
+	require.NoError(t, m.UpdateFields(ctx, retrying.ID, bson.M{cst.NextTime: time.Now().Add(time.Hour).Unix()}))
+	for i := 0; i < d.batchSize; i++ {
+		insertEvent(t, m, dead, int64(2+i))
+		insertEvent(t, m, waiting, int64(2+i))
+	}
+	unrelated := insertEvent(t, m, free, int64(2+d.batchSize))
+
+	d.Dispatch(ctx)
+	assert.Equal(t, []primitive.ObjectID{unrelated.ID}, p.published)
+}
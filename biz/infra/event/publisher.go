@@ -0,0 +1,104 @@
+// Package event 投递outbox中的领域事件
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+// Publisher 发布一个事件, 返回错误时事件会被重试
+type Publisher interface {
+	// Name 发布方式的名称, 用于记录事件已发布成功的发布方式
+	Name() string
+	Publish(ctx context.Context, e *outbox.Event) error
+}
+
+// 发布方式
+const (
+	PublisherLog     = "log"
+	PublisherWebhook = "webhook"
+	PublisherUnit    = "unitWebhook" // 单位登记的webhook, 由Deliverer投递
+)
+
+// NewPublisher 按Outbox.Publisher选择发布方式
+func NewPublisher(c *config.Config) Publisher {
+	if c.Outbox.Publisher == PublisherWebhook {
+		return NewWebhookPublisher(c.Outbox.Webhook.URL, c.Outbox.Webhook.Timeout)
+	}
+	return NewLogPublisher()
+}
+
+// LogPublisher 在进程内分发给订阅者并记录日志, 用于本地运行及尚未接入其他服务时
+type LogPublisher struct {
+	handlers map[string][]func(ctx context.Context, e *outbox.Event) error
+}
+
+func NewLogPublisher() *LogPublisher {
+	return &LogPublisher{handlers: map[string][]func(ctx context.Context, e *outbox.Event) error{}}
+}
+
+// Subscribe 订阅某类事件, 需要在投递开始前调用, 任一订阅者返回错误时整个事件会被重试
+func (p *LogPublisher) Subscribe(typ string, fn func(ctx context.Context, e *outbox.Event) error) {
+	p.handlers[typ] = append(p.handlers[typ], fn)
+}
+
+func (p *LogPublisher) Name() string {
+	return PublisherLog
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, e *outbox.Event) error {
+	for _, fn := range p.handlers[e.Type] {
+		if err := fn(ctx, e); err != nil {
+			return err
+		}
+	}
+	logs.CtxInfof(ctx, "publish event id=%s, type=%s, aggregate=%s", e.ID.Hex(), e.Type, e.Key())
+	return nil
+}
+
+// WebhookPublisher 以JSON POST到固定地址, 非2xx响应视为失败
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookPublisher(url string, timeout time.Duration) *WebhookPublisher {
+	return &WebhookPublisher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (p *WebhookPublisher) Name() string {
+	return PublisherWebhook
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, e *outbox.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", e.ID.Hex())
+	req.Header.Set("X-Event-Type", e.Type)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/graceful"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 投递请求的签名相关请求头
+// 签名为 sha256=hex(HMAC-SHA256(secret, 时间戳 + "." + 请求体)), 接收方应同时校验时间戳以防重放
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderDelivery  = "X-Delivery-Id"
+)
+
+// errWebhookDeleted 订阅删除后未完成的投递直接进入死信
+var errWebhookDeleted = errors.New("webhook deleted")
+
+// errForbiddenAddress webhook地址解析到内网、回环、链路本地或元数据服务等地址时拒绝投递, 防止借投递访问内部服务
+var errForbiddenAddress = errors.New("webhook address forbidden")
+
+// forbiddenNets 运营商级NAT等net.IP未覆盖的保留网段, 部分云厂商的元数据服务位于其中
+var forbiddenNets = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("198.18.0.0/15"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// forbiddenIP 是否为不允许投递的地址
+func forbiddenIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range forbiddenNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL 解析webhook地址的主机, 任一地址不允许投递时返回错误
+// 解析结果可能在登记后变化, 投递时连接前会再次检查
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if forbiddenIP(ip.IP) {
+			return errForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// newClient 连接前检查解析后的地址, 重定向及DNS变化后的地址同样受限
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || forbiddenIP(ip) {
+				return errForbiddenAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // 经代理时检查的是代理的地址
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+var deliverer *Deliverer
+
+// Deliverer 将单位内的事件投递到单位登记的webhook
+// 作为Publisher在事件发布时为每个匹配的订阅创建投递记录, 再由后台任务按顺序投递并重试
+type Deliverer struct {
+	webhooks    webhook.IMongoMapper
+	deliveries  delivery.IMongoMapper
+	client      *http.Client
+	enabled     bool
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	maxBackoff  time.Duration
+	wake        chan struct{}
+}
+
+func NewDeliverer(c *config.Config, webhooks webhook.IMongoMapper, deliveries delivery.IMongoMapper) *Deliverer {
+	deliverer = &Deliverer{
+		webhooks:    webhooks,
+		deliveries:  deliveries,
+		client:      newClient(c.UnitWebhook.Timeout),
+		enabled:     c.Outbox.Dispatch,
+		interval:    c.Outbox.Interval,
+		batchSize:   c.UnitWebhook.BatchSize,
+		maxAttempts: c.UnitWebhook.MaxAttempts,
+		maxBackoff:  c.UnitWebhook.MaxBackoff,
+		wake:        make(chan struct{}, 1),
+	}
+	return deliverer
+}
+
+// GetDeliverer 获得进程内的投递任务, 在NewDeliverer之前调用返回nil
+func GetDeliverer() *Deliverer {
+	return deliverer
+}
+
+func (d *Deliverer) Name() string {
+	return PublisherUnit
+}
+
+// Publish 为订阅了该事件的webhook创建投递记录, 不属于单位的事件不投递
+func (d *Deliverer) Publish(ctx context.Context, e *outbox.Event) error {
+	if e.UnitID.IsZero() {
+		return nil
+	}
+	webhooks, err := d.webhooks.FindActiveByUnitID(ctx, e.UnitID)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	for _, w := range webhooks {
+		if !w.Subscribed(e.Type) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(e); err != nil {
+				return err
+			}
+		}
+		now := time.Now().Unix()
+		// 事件被重复发布时不会重复创建
+		if err = d.deliveries.Insert(ctx, &delivery.Delivery{
+			ID:         primitive.NewObjectID(),
+			WebhookID:  w.ID,
+			UnitID:     e.UnitID,
+			EventID:    e.ID,
+			Type:       e.Type,
+			Key:        e.Key(),
+			Body:       string(body),
+			Status:     delivery.StatusPending,
+			CreateTime: now,
+			UpdateTime: now,
+		}); err != nil {
+			return err
+		}
+	}
+	if body != nil {
+		d.Notify()
+	}
+	return nil
+}
+
+// Start 启动投递任务, 开始排空后退出, 与事件投递一样只在开启Outbox.Dispatch的实例上运行
+func (d *Deliverer) Start() {
+	if !d.enabled {
+		return
+	}
+	graceful.Go("webhook deliverer", func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for !graceful.Draining() {
+			d.Deliver(context.Background())
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			}
+		}
+	})
+}
+
+// Notify 有新的投递记录时提前开始下一轮投递, 不会阻塞
+func (d *Deliverer) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver 执行一轮投递, 最多投递batchSize条记录
+// 与事件投递一致, 同一订阅同一聚合的记录按创建顺序投递, 前一条等待重试或进入死信时后续记录不投递, 重放死信后恢复
+// 被阻塞的记录不计入数量, 翻页越过它们继续查询, 个别单位的接收方故障不会影响其他单位
+func (d *Deliverer) Deliver(ctx context.Context) {
+	webhooks := map[primitive.ObjectID]*webhook.Webhook{}
+	blocked := map[string]bool{}
+	checked := map[string]bool{} // 每轮每个订阅的每个聚合只检查一次
+	var after *delivery.Delivery
+	for sent := 0; sent < d.batchSize; {
+		deliveries, err := d.deliveries.FindPending(ctx, after, d.batchSize)
+		if err != nil {
+			logs.Errorf("find pending deliveries error: %s", errorx.ErrorWithoutStack(err))
+			return
+		}
+		for _, r := range deliveries {
+			after = r
+			if sent >= d.batchSize {
+				return
+			}
+			key := r.WebhookID.Hex() + ":" + r.Key
+			if blocked[key] {
+				continue
+			}
+			w, ok := webhooks[r.WebhookID]
+			if !ok {
+				if w, err = d.webhooks.FindOne(ctx, r.WebhookID); err != nil && !errors.Is(err, monc.ErrNotFound) {
+					logs.Errorf("find webhook error: %s", errorx.ErrorWithoutStack(err))
+					blocked[key] = true
+					continue
+				}
+				webhooks[r.WebhookID] = w
+			}
+			// 订阅删除后不再保持顺序, 未完成的记录逐条进入死信
+			if w == nil || w.Status == enum.Deleted {
+				d.fail(ctx, r, 0, errWebhookDeleted, true)
+				continue
+			}
+			if !checked[key] {
+				checked[key] = true
+				if blocked[key], err = d.deliveries.IsBlocked(ctx, r.WebhookID, r.Key); err != nil || blocked[key] {
+					if err != nil {
+						logs.Errorf("check blocked deliveries of %s error: %s", key, errorx.ErrorWithoutStack(err))
+						blocked[key] = true
+					}
+					continue
+				}
+			}
+			sent++
+			code, err := d.Send(ctx, w, r)
+			if err != nil {
+				blocked[key] = true
+				d.fail(ctx, r, code, err, false)
+				continue
+			}
+			d.succeed(ctx, r, code)
+		}
+		if len(deliveries) < d.batchSize {
+			return
+		}
+	}
+}
+
+// Send 签名并发送一次投递, 非2xx响应视为失败, 返回响应状态码
+// 响应内容可能包含接收方的内部信息, 不读取也不记录
+func (d *Deliverer) Send(ctx context.Context, w *webhook.Webhook, r *delivery.Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader([]byte(r.Body)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", r.EventID.Hex())
+	req.Header.Set("X-Event-Type", r.Type)
+	req.Header.Set(HeaderDelivery, r.ID.Hex())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, []byte(r.Body)))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign 计算投递请求的签名
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Deliverer) succeed(ctx context.Context, r *delivery.Delivery, code int) {
+	metrics.IncWebhookDelivery(metrics.EventDelivered)
+	now := time.Now().Unix()
+	if err := d.deliveries.UpdateFields(ctx, r.ID, bson.M{
+		cst.Status:       delivery.StatusDelivered,
+		cst.Attempts:     r.Attempts + 1,
+		cst.ResponseCode: code,
+		cst.LastError:    "",
+		cst.UpdateTime:   now,
+		cst.DeliverTime:  now,
+	}); err != nil {
+		// 下一轮会重复投递, 接收方按X-Event-Id去重
+		logs.Errorf("mark delivery %s delivered error: %s", r.ID.Hex(), errorx.ErrorWithoutStack(err))
+	}
+}
+
+// fail 按指数退避安排重试, 超过最大次数或dead为true时进入死信
+func (d *Deliverer) fail(ctx context.Context, r *delivery.Delivery, code int, cause error, dead bool) {
+	attempts := r.Attempts + 1
+	update := bson.M{
+		cst.Attempts:     attempts,
+		cst.ResponseCode: code,
+		cst.LastError:    cause.Error(),
+		cst.UpdateTime:   time.Now().Unix(),
+	}
+	if dead || attempts >= d.maxAttempts {
+		update[cst.Status] = delivery.StatusDead
+		metrics.IncWebhookDelivery(metrics.EventDead)
+		logs.Warnf("delivery %s dead after %d attempts: %s", r.ID.Hex(), attempts, cause)
+	} else {
+		update[cst.NextTime] = time.Now().Add(backoff(d.interval, d.maxBackoff, attempts)).Unix()
+		metrics.IncWebhookDelivery(metrics.EventRetry)
+	}
+	if err := d.deliveries.UpdateFields(ctx, r.ID, update); err != nil {
+		logs.Errorf("update delivery %s error: %s", r.ID.Hex(), errorx.ErrorWithoutStack(err))
+	}
+}
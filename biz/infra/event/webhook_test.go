@@ -0,0 +1,275 @@
+package event
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testSecret = "whsec-0123456789abcdef"
+
+// receiver 本地的webhook接收方, status为返回的状态码
+type receiver struct {
+	server *httptest.Server
+	status atomic.Int32
+	calls  atomic.Int32
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{}
+	r.status.Store(int32(status))
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.calls.Add(1)
+		body, _ := io.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte(testSecret))
+		mac.Write([]byte(req.Header.Get(HeaderTimestamp) + "." + string(body)))
+		if req.Header.Get(HeaderSignature) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(int(r.status.Load()))
+		_, _ = w.Write([]byte("internal detail"))
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// newTestDeliverer httptest监听在回环地址, 使用不限制地址的客户端
+func newTestDeliverer(t *testing.T, url string) (*Deliverer, *webhook.Webhook) {
+	c := &config.Config{}
+	c.Pseudonym.Key = "secret"
+	keyring, err := crypto.NewKeyring(c)
+	require.NoError(t, err)
+	d := &Deliverer{
+		webhooks:    webhook.NewMemoryMapper(keyring),
+		deliveries:  delivery.NewMemoryMapper(),
+		client:      &http.Client{Timeout: time.Second},
+		interval:    time.Minute,
+		batchSize:   10,
+		maxAttempts: 2,
+		maxBackoff:  time.Hour,
+		wake:        make(chan struct{}, 1),
+	}
+	w := &webhook.Webhook{
+		ID:     primitive.NewObjectID(),
+		UnitID: primitive.NewObjectID(),
+		URL:    url,
+		Types:  []string{outbox.TypeUserCreated},
+		Secret: testSecret,
+		Status: enum.Active,
+	}
+	require.NoError(t, d.webhooks.Insert(context.Background(), w))
+	return d, w
+}
+
+// publish 发布一个单位内的事件, 返回创建的投递记录
+func publish(t *testing.T, d *Deliverer, w *webhook.Webhook) *delivery.Delivery {
+	ctx := context.Background()
+	e := &outbox.Event{
+		ID:          primitive.NewObjectID(),
+		Type:        outbox.TypeUserCreated,
+		Aggregate:   outbox.AggregateUser,
+		AggregateID: primitive.NewObjectID(),
+		UnitID:      w.UnitID,
+		CreateTime:  time.Now().Unix(),
+	}
+	require.NoError(t, d.Publish(ctx, e))
+	// 重复发布不重复创建
+	require.NoError(t, d.Publish(ctx, e))
+	deliveries, total, err := d.deliveries.FindPage(ctx, w.ID, "", &mapper.PageOptions{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	return deliveries[0]
+}
+
+func findDelivery(t *testing.T, d *Deliverer, id primitive.ObjectID) *delivery.Delivery {
+	r, err := d.deliveries.FindOne(context.Background(), id)
+	require.NoError(t, err)
+	return r
+}
+
+func TestDeliverSigned(t *testing.T) {
+	rcv := newReceiver(t, http.StatusOK)
+	d, w := newTestDeliverer(t, rcv.server.URL)
+	r := publish(t, d, w)
+
+	d.Deliver(context.Background())
+	r = findDelivery(t, d, r.ID)
+	assert.Equal(t, delivery.StatusDelivered, r.Status)
+	assert.Equal(t, http.StatusOK, r.ResponseCode)
+	assert.EqualValues(t, 1, rcv.calls.Load())
+
+	// 签名不匹配时接收方拒绝
+	w.Secret = "whsec-another-secret-x"
+	code, err := d.Send(context.Background(), w, r)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestDeliverRetryAndDead(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	d, w := newTestDeliverer(t, rcv.server.URL)
+	r := publish(t, d, w)
+
+	// 第一次失败后按退避安排重试, 不记录响应内容
+	before := time.Now().Unix()
+	d.Deliver(ctx)
+	r = findDelivery(t, d, r.ID)
+	assert.Equal(t, delivery.StatusPending, r.Status)
+	assert.Equal(t, 1, r.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, r.ResponseCode)
+	assert.NotContains(t, r.LastError, "internal detail")
+	assert.GreaterOrEqual(t, r.NextTime, before+int64(d.interval/time.Second))
+
+	// 未到重试时间不投递
+	d.Deliver(ctx)
+	assert.EqualValues(t, 1, rcv.calls.Load())
+
+	// 超过最大次数后进入死信
+	require.NoError(t, d.deliveries.UpdateFields(ctx, r.ID, bson.M{cst.NextTime: 0}))
+	d.Deliver(ctx)
+	r = findDelivery(t, d, r.ID)
+	assert.Equal(t, delivery.StatusDead, r.Status)
+	assert.Equal(t, 2, r.Attempts)
+
+	// 重放后重新投递
+	rcv.status.Store(http.StatusNoContent)
+	require.NoError(t, d.deliveries.UpdateFields(ctx, r.ID, bson.M{
+		cst.Status:   delivery.StatusPending,
+		cst.Attempts: 0,
+		cst.NextTime: 0,
+		cst.Replays:  r.Replays + 1,
+	}))
+	d.Deliver(ctx)
+	r = findDelivery(t, d, r.ID)
+	assert.Equal(t, delivery.StatusDelivered, r.Status)
+	assert.Equal(t, 1, r.Replays)
+	assert.EqualValues(t, 3, rcv.calls.Load())
+}
+
+func TestDeliverDeletedWebhook(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusOK)
+	d, w := newTestDeliverer(t, rcv.server.URL)
+	r := publish(t, d, w)
+
+	require.NoError(t, d.webhooks.UpdateFields(ctx, w.ID, bson.M{cst.Status: enum.Deleted}))
+	d.Deliver(ctx)
+	r = findDelivery(t, d, r.ID)
+	assert.Equal(t, delivery.StatusDead, r.Status)
+	assert.Zero(t, rcv.calls.Load())
+}
+
+// insertDelivery 直接插入一条投递记录
+func insertDelivery(t *testing.T, d *Deliverer, w *webhook.Webhook, key string, createTime int64, status string) *delivery.Delivery {
+	r := &delivery.Delivery{
+		ID:         primitive.NewObjectID(),
+		WebhookID:  w.ID,
+		UnitID:     w.UnitID,
+		EventID:    primitive.NewObjectID(),
+		Type:       outbox.TypeUserCreated,
+		Key:        key,
+		Body:       "{}",
+		Status:     status,
+		CreateTime: createTime,
+	}
+	require.NoError(t, d.deliveries.Insert(context.Background(), r))
+	return r
+}
+
+// 死信及等待重试的记录阻塞同一订阅同一聚合的后续记录, 被阻塞的记录超过一轮的数量时其他单位仍能投递
+func TestDeliverBlockedBeyondBatch(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusOK)
+	d, w := newTestDeliverer(t, rcv.server.URL)
+	other := &webhook.Webhook{ID: primitive.NewObjectID(), UnitID: primitive.NewObjectID(), URL: rcv.server.URL, Secret: testSecret, Status: enum.Active}
+	require.NoError(t, d.webhooks.Insert(ctx, other))
+
+	dead := insertDelivery(t, d, w, "user:dead", 1, delivery.StatusDead)
+	retrying := insertDelivery(t, d, w, "user:retrying", 1, delivery.StatusPending)
+	require.NoError(t, d.deliveries.UpdateFields(ctx, retrying.ID, bson.M{cst.NextTime: time.Now().Add(time.Hour).Unix()}))
+	var behind []*delivery.Delivery
+	for i := 0; i < d.batchSize; i++ {
+		behind = append(behind, insertDelivery(t, d, w, "user:dead", int64(2+i), delivery.StatusPending))
+		insertDelivery(t, d, w, "user:retrying", int64(2+i), delivery.StatusPending)
+	}
+	unrelated := insertDelivery(t, d, other, "user:other", int64(2+d.batchSize), delivery.StatusPending)
+
+	d.Deliver(ctx)
+	assert.Equal(t, delivery.StatusDelivered, findDelivery(t, d, unrelated.ID).Status)
+	assert.EqualValues(t, 1, rcv.calls.Load())
+	for _, r := range behind {
+		assert.Equal(t, delivery.StatusPending, findDelivery(t, d, r.ID).Status)
+	}
+
+	// 重放死信后按顺序恢复投递
+	require.NoError(t, d.deliveries.UpdateFields(ctx, dead.ID, bson.M{cst.Status: delivery.StatusPending}))
+	d.Deliver(ctx)
+	assert.Equal(t, delivery.StatusDelivered, findDelivery(t, d, dead.ID).Status)
+	assert.Equal(t, delivery.StatusDelivered, findDelivery(t, d, behind[d.batchSize-2].ID).Status)
+	assert.Equal(t, delivery.StatusPending, findDelivery(t, d, behind[d.batchSize-1].ID).Status)
+}
+
+func TestBackoff(t *testing.T) {
+	for n, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if n == 0 {
+			continue
+		}
+		assert.Equal(t, want, backoff(time.Second, 5*time.Second, n), "attempt %d", n)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://93.184.215.14/hook", true},
+		{"http://127.0.0.1:8080/hook", false},
+		{"http://localhost/hook", false},
+		{"http://10.0.0.8/hook", false},
+		{"http://172.16.0.1/hook", false},
+		{"http://192.168.1.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://100.100.100.200/latest/meta-data", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://[fd00:ec2::254]/hook", false},
+	} {
+		err := CheckURL(context.Background(), tt.url)
+		if tt.allowed {
+			assert.NoError(t, err, tt.url)
+		} else {
+			assert.Error(t, err, tt.url)
+		}
+	}
+}
+
+// 登记后解析结果变化时, 投递前的连接检查同样拒绝
+func TestClientRejectsForbiddenAddress(t *testing.T) {
+	rcv := newReceiver(t, http.StatusOK)
+	_, err := newClient(time.Second).Get(rcv.server.URL)
+	assert.ErrorIs(t, err, errForbiddenAddress)
+	assert.Zero(t, rcv.calls.Load())
+}
@@ -0,0 +1,84 @@
+// Package graceful 跟踪进行中的请求及后台任务, 使进程退出前可以等待它们完成
+package graceful
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+)
+
+var (
+	draining atomic.Bool
+	deadline atomic.Int64 // 排空截止时间的UnixNano
+	requests atomic.Int64
+	tasks    sync.WaitGroup
+	running  atomic.Int64
+)
+
+// Drain 开始排空, 之后到达的请求被拒绝, timeout后不再等待
+func Drain(timeout time.Duration) {
+	if draining.CompareAndSwap(false, true) {
+		deadline.Store(time.Now().Add(timeout).UnixNano())
+		logs.Infof("draining, in-flight requests: %d, background tasks: %d", requests.Load(), running.Load())
+	}
+}
+
+// Draining 是否处于排空过程中
+func Draining() bool {
+	return draining.Load()
+}
+
+// Begin 记录一个进行中的请求, 返回的函数在请求结束时调用
+func Begin() func() {
+	requests.Add(1)
+	return func() { requests.Add(-1) }
+}
+
+// Go 启动一个受跟踪的后台任务, 退出前会等待其完成
+func Go(name string, fn func()) {
+	tasks.Add(1)
+	running.Add(1)
+	go func() {
+		defer tasks.Done()
+		defer running.Add(-1)
+		defer func() {
+			if r := recover(); r != nil {
+				logs.Errorf("background task %s panic: %v", name, r)
+			}
+		}()
+		fn()
+	}()
+}
+
+// Wait 等待进行中的请求及后台任务完成, 超过排空截止时间时返回false
+func Wait() bool {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Unix(0, deadline.Load()))
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		tasks.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logs.Errorf("drain timeout, abandon in-flight requests: %d, background tasks: %d", requests.Load(), running.Load())
+			return false
+		case <-ticker.C:
+			if requests.Load() > 0 {
+				continue
+			}
+			select {
+			case <-done:
+				return true
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package health 提供存活及就绪检查
+// 存活只表示进程可以响应, 就绪要求启动完成、未处于关闭过程且所有依赖均可访问
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/acceptance"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/audit"
+	confmapper "github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/consent"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// 检查结果的状态, 与gRPC health协议的取值一致
+const (
+	StatusServing    = "SERVING"
+	StatusNotServing = "NOT_SERVING"
+)
+
+var errNotReady = errors.New("service is starting or shutting down")
+
+var checker *Checker
+
+// CheckFunc 检查一项依赖, 不可用时返回错误
+type CheckFunc func(ctx context.Context) error
+
+// Result 单项检查的结果
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Checker 汇总各项依赖的检查, 就绪标记在启动完成后打开, 开始关闭时关闭
+type Checker struct {
+	timeout time.Duration
+	ready   atomic.Bool
+	names   []string
+	checks  map[string]CheckFunc
+}
+
+// NewChecker 注册所有mongo集合及缓存节点的检查
+func NewChecker(c *config.Config, userMapper user.IMongoMapper, unitMapper unit.IMongoMapper,
+	configMapper confmapper.IMongoMapper, consentMapper consent.IMongoMapper,
+	acceptanceMapper acceptance.IMongoMapper, auditMapper audit.IMongoMapper, outboxMapper outbox.IMongoMapper,
+	webhookMapper webhook.IMongoMapper, deliveryMapper delivery.IMongoMapper, revisionMapper revision.IMongoMapper) (*Checker, error) {
+	h := &Checker{timeout: c.Health.Timeout, checks: map[string]CheckFunc{}}
+	h.Register("mongo:user", userMapper.Ping)
+	h.Register("mongo:unit", unitMapper.Ping)
+	h.Register("mongo:config", configMapper.Ping)
+	h.Register("mongo:consent", consentMapper.Ping)
+	h.Register("mongo:acceptance", acceptanceMapper.Ping)
+	h.Register("mongo:audit", auditMapper.Ping)
+	h.Register("mongo:outbox", outboxMapper.Ping)
+	h.Register("mongo:webhook", webhookMapper.Ping)
+	h.Register("mongo:delivery", deliveryMapper.Ping)
+	h.Register("mongo:config_revision", revisionMapper.Ping)
+	for _, node := range c.Cache {
+		rds, err := redis.NewRedis(node.RedisConf)
+		if err != nil {
+			return nil, err
+		}
+		h.Register("redis:"+node.Host, func(ctx context.Context) error {
+			if !rds.PingCtx(ctx) {
+				return fmt.Errorf("ping redis %s failed", node.Host)
+			}
+			return nil
+		})
+	}
+	checker = h
+	return h, nil
+}
+
+// GetChecker 获得进程内的检查器, 在NewChecker之前调用返回nil
+func GetChecker() *Checker {
+	return checker
+}
+
+// Register 注册一项依赖检查, 同名检查会被覆盖
+func (h *Checker) Register(name string, check CheckFunc) {
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// SetReady 设置就绪标记, 启动任务完成后置为true, 开始关闭时置为false
+func (h *Checker) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Live 存活检查, 进程能够响应即为存活, 依赖不可用时不应被重启
+func (h *Checker) Live() bool {
+	return true
+}
+
+// Ready 就绪检查, 并发执行所有依赖检查并返回每一项的结果
+func (h *Checker) Ready(ctx context.Context) (bool, []*Result) {
+	results := make([]*Result, len(h.names))
+	var wg sync.WaitGroup
+	for i, name := range h.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			results[i] = result(name, h.checks[name](cctx))
+		}(i, name)
+	}
+	wg.Wait()
+
+	ready := h.ready.Load()
+	if !ready {
+		results = append([]*Result{result("startup", errNotReady)}, results...)
+	}
+	for _, r := range results {
+		if r.Status != StatusServing {
+			ready = false
+		}
+	}
+	return ready, results
+}
+
+func result(name string, err error) *Result {
+	if err != nil {
+		return &Result{Name: name, Status: StatusNotServing, Error: err.Error()}
+	}
+	return &Result{Name: name, Status: StatusServing}
+}
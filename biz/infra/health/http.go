@@ -0,0 +1,41 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 健康检查的HTTP路径
+const (
+	LivePath  = "/livez"
+	ReadyPath = "/readyz"
+)
+
+type response struct {
+	Status string    `json:"status"`
+	Checks []*Result `json:"checks,omitempty"`
+}
+
+// NewServer 创建健康检查的HTTP服务, 不可用时返回503
+func NewServer(addr string, h *Checker) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivePath, func(w http.ResponseWriter, r *http.Request) {
+		write(w, h.Live(), nil)
+	})
+	mux.HandleFunc(ReadyPath, func(w http.ResponseWriter, r *http.Request) {
+		ready, results := h.Ready(r.Context())
+		write(w, ready, results)
+	})
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+func write(w http.ResponseWriter, ok bool, results []*Result) {
+	resp := &response{Status: StatusServing, Checks: results}
+	code := http.StatusOK
+	if !ok {
+		resp.Status, code = StatusNotServing, http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
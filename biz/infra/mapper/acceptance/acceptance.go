@@ -0,0 +1,22 @@
+package acceptance
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Acceptance 用户对某一版本同意书的签署记录
+type Acceptance struct {
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	UnitID       primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	ConsentID    primitive.ObjectID `json:"consentId,omitempty" bson:"consentId,omitempty"`
+	Type         int                `json:"type" bson:"type"` // 同意书类型
+	Version      int32              `json:"version,omitempty" bson:"version,omitempty"`
+	Acceptor     int                `json:"acceptor" bson:"acceptor"` // Self | Guardian
+	AcceptorName string             `json:"acceptorName,omitempty" bson:"acceptorName,omitempty"`
+	Relation     string             `json:"relation,omitempty" bson:"relation,omitempty"` // 监护人与用户的关系
+	Status       int                `json:"status" bson:"status"`                         // Active | Deleted(已撤回)
+	CreateTime   int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+	UpdateTime   int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
+	DeleteTime   int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
+}
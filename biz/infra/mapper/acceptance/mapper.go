@@ -0,0 +1,66 @@
+package acceptance
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixAcceptanceCacheKey = "cache:acceptance"
+	collectionName           = "acceptance"
+)
+
+var indexes = []mapper.Index{
+	{Name: "idx_userId_type", Keys: []string{cst.UserID, cst.Type}},
+}
+
+type IMongoMapper interface {
+	Insert(ctx context.Context, acceptance *Acceptance) error
+	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	FindAllByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Acceptance, error)
+	FindActiveByUserIDAndType(ctx context.Context, userID primitive.ObjectID, consentType int) ([]*Acceptance, error)
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Acceptance]
+	conn *monc.Model
+}
+
+func NewMongoMapper(config *config.Config) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Acceptance](conn, collectionName),
+		conn:         conn,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper() IMongoMapper {
+	return &mongoMapper{IMongoMapper: mapper.NewMemoryMapper[Acceptance](collectionName, indexes)}
+}
+
+// FindAllByUserID 查询用户的所有签署记录
+func (m *mongoMapper) FindAllByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Acceptance, error) {
+	return m.FindAllByFields(ctx, bson.M{cst.UserID: userID})
+}
+
+// FindActiveByUserIDAndType 查询用户某类同意书中未撤回的签署记录
+func (m *mongoMapper) FindActiveByUserIDAndType(ctx context.Context, userID primitive.ObjectID, consentType int) ([]*Acceptance, error) {
+	return m.FindAllByFields(ctx, bson.M{cst.UserID: userID, cst.Type: consentType, cst.Status: enum.Active})
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Change 单个字段的变更, 敏感字段只记录发生了变更而不记录值
+type Change struct {
+	Field    string `json:"field" bson:"field"`
+	Before   any    `json:"before,omitempty" bson:"before,omitempty"`
+	After    any    `json:"after,omitempty" bson:"after,omitempty"`
+	Redacted bool   `json:"redacted,omitempty" bson:"redacted,omitempty"`
+}
+
+// Audit 审计记录, 只追加不修改
+type Audit struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UnitID     primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	Actor      string             `json:"actor,omitempty" bson:"actor,omitempty"`   // 操作人
+	Action     string             `json:"action,omitempty" bson:"action,omitempty"` // 接口名
+	Entity     string             `json:"entity,omitempty" bson:"entity,omitempty"` // 被操作实体所在集合
+	EntityID   primitive.ObjectID `json:"entityId,omitempty" bson:"entityId,omitempty"`
+	Diff       []*Change          `json:"diff,omitempty" bson:"diff,omitempty"`
+	TraceID    string             `json:"traceId,omitempty" bson:"traceId,omitempty"`
+	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+}
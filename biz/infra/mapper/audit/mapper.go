@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixAuditCacheKey = "cache:audit"
+	collectionName      = "audit"
+)
+
+var indexes = []mapper.Index{
+	{Name: "idx_createTime", Keys: []string{"-" + cst.CreateTime}},
+	{Name: "idx_unitId_createTime", Keys: []string{cst.UnitID, "-" + cst.CreateTime}},
+	{Name: "idx_entity_entityId_createTime", Keys: []string{cst.Entity, cst.EntityID, "-" + cst.CreateTime}},
+}
+
+// IMongoMapper 审计记录只允许追加, 不提供修改和删除
+type IMongoMapper interface {
+	Insert(ctx context.Context, audit *Audit) error
+	FindPage(ctx context.Context, filter *Filter, opts *mapper.PageOptions) ([]*Audit, int64, error)
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+// Filter 审计记录的查询条件, 零值表示不限制
+type Filter struct {
+	UnitID    primitive.ObjectID
+	Actor     string
+	Entity    string
+	EntityID  primitive.ObjectID
+	StartTime int64
+	EndTime   int64
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Audit]
+	conn *monc.Model
+}
+
+func NewMongoMapper(config *config.Config) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Audit](conn, collectionName),
+		conn:         conn,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper() IMongoMapper {
+	return &mongoMapper{IMongoMapper: mapper.NewMemoryMapper[Audit](collectionName, indexes)}
+}
+
+// FindPage 按时间倒序分页查询审计记录
+func (m *mongoMapper) FindPage(ctx context.Context, filter *Filter, opts *mapper.PageOptions) ([]*Audit, int64, error) {
+	f := bson.M{}
+	if !filter.UnitID.IsZero() {
+		f[cst.UnitID] = filter.UnitID
+	}
+	if filter.Actor != "" {
+		f[cst.Actor] = filter.Actor
+	}
+	if filter.Entity != "" {
+		f[cst.Entity] = filter.Entity
+	}
+	if !filter.EntityID.IsZero() {
+		f[cst.EntityID] = filter.EntityID
+	}
+	if filter.StartTime != 0 || filter.EndTime != 0 {
+		t := bson.M{}
+		if filter.StartTime != 0 {
+			t["$gte"] = filter.StartTime
+		}
+		if filter.EndTime != 0 {
+			t["$lt"] = filter.EndTime
+		}
+		f[cst.CreateTime] = t
+	}
+	return m.FindPageByFields(ctx, f, opts)
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
@@ -0,0 +1,124 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"github.com/zeromicro/go-zero/core/syncx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// singleFlight 所有集合共用, 相同的缓存键只查询一次数据库
+var singleFlight = syncx.NewSingleFlight()
+
+// NewModel 创建集合的model, 开启MapperCache时同时返回缓存, 否则返回nil
+func NewModel(c *config.Config, collection string) (*monc.Model, cache.Cache) {
+	if !c.MapperCache.Enabled {
+		return monc.MustNewModel(c.Mongo.URL, c.Mongo.DB, collection, c.Cache), nil
+	}
+	cc := cache.New(c.Cache, singleFlight, cache.NewStat(collection), monc.ErrNotFound,
+		cache.WithExpiry(c.MapperCache.Expiry), cache.WithNotFoundExpiry(c.MapperCache.NotFoundExpiry))
+	conn, err := monc.NewModelWithCache(c.Mongo.URL, c.Mongo.DB, collection, cc)
+	if err != nil {
+		panic(err)
+	}
+	return conn, cc
+}
+
+// CacheKey 构造缓存键, 格式为 前缀:字段:值, 读取与失效必须使用相同的构造方式
+func CacheKey(prefix string, parts ...string) string {
+	return prefix + ":" + strings.Join(parts, ":")
+}
+
+// NewCachedMongoMapper 按ID及唯一字段的单个查询走缓存, keys返回数据库中的实体对应的全部缓存键, 写入后逐一删除
+// c为nil时等同于NewMongoMapper
+func NewCachedMongoMapper[T any](conn *monc.Model, c cache.Cache, collection, prefix string, keys func(data *T) []string) IMongoMapper[T] {
+	return &mongoMapper[T]{conn: conn, collection: collection, cache: c, prefix: prefix, keys: keys}
+}
+
+// FindOneByKey 先读缓存, 未命中时按filter查询并写入缓存, 不存在时写入占位
+// filter必须唯一确定key对应的实体, 且实体变更时key在keys的返回值中
+func (m *mongoMapper[T]) FindOneByKey(ctx context.Context, key string, filter bson.M) (*T, error) {
+	// 事务内可能读到未提交的数据, 不能写入缓存
+	if m.cache == nil || inTransaction(ctx) {
+		return m.FindOneByFields(ctx, filter)
+	}
+	result := new(T)
+	queried := false
+	err := m.cache.TakeCtx(ctx, result, key, func(v any) (err error) {
+		queried = true
+		defer metrics.ObserveMongo(m.collection, "findOne", time.Now(), &err)
+		return m.conn.FindOneNoCache(ctx, v, filter)
+	})
+	switch {
+	case queried:
+		metrics.IncCache(m.collection, metrics.CacheMiss)
+	case errors.Is(err, monc.ErrNotFound):
+		metrics.IncCache(m.collection, metrics.CacheNegativeHit)
+	case err == nil:
+		metrics.IncCache(m.collection, metrics.CacheHit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// idKey 按ID查询的缓存键
+func (m *mongoMapper[T]) idKey(id primitive.ObjectID) string {
+	return CacheKey(m.prefix, cst.ID, id.Hex())
+}
+
+// invalidate 删除实体的全部缓存键, 事务中在提交后删除, 失败时只记录日志, 过期后自动恢复一致
+func (m *mongoMapper[T]) invalidate(ctx context.Context, docs ...*T) {
+	if m.cache == nil {
+		return
+	}
+	var keys []string
+	for _, d := range docs {
+		if d != nil {
+			keys = append(keys, m.keys(d)...)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	afterCommit(ctx, func(ctx context.Context) {
+		if err := m.cache.DelCtx(ctx, keys...); err != nil {
+			logs.CtxErrorf(ctx, "delete %s cache error: %s", m.collection, err)
+		}
+	})
+}
+
+// updateOne 更新满足filter的实体, 开启缓存时删除更新前后的缓存键, 返回是否有实体被更新
+// filter必须包含_id
+func (m *mongoMapper[T]) updateOne(ctx context.Context, filter, op bson.M) (bool, error) {
+	if m.cache == nil {
+		res, err := m.conn.UpdateOneNoCache(ctx, filter, op)
+		if err != nil {
+			return false, err
+		}
+		return res.MatchedCount > 0, nil
+	}
+	before, after := new(T), new(T)
+	if err := m.conn.FindOneAndUpdateNoCache(ctx, before, filter, op); err != nil {
+		if errors.Is(err, monc.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := m.conn.FindOneNoCache(ctx, after, bson.M{cst.ID: filter[cst.ID]}); err != nil {
+		after = nil
+	}
+	m.invalidate(ctx, before, after)
+	return true, nil
+}
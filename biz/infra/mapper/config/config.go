@@ -34,6 +34,7 @@ type Config struct {
 	TTS        *TTS               `json:"tts,omitempty" bson:"tts,omitempty"`
 	Report     *Report            `json:"report,omitempty" bson:"report,omitempty"`
 	Status     int                `json:"status,omitempty" bson:"status,omitempty"`
+	Version    int64              `json:"version,omitempty" bson:"version,omitempty"` // 每次更新递增, 用于乐观并发控制, 历史数据视为0
 	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
 	UpdateTime int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
 	DeleteTime int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
@@ -18,11 +18,20 @@ const (
 	collectionName       = "config"
 )
 
+// indexes 每个单位只有一份配置, 没有unitId的模板配置不受约束
+var indexes = []mapper.Index{
+	{Name: "uniq_unitId", Keys: []string{cst.UnitID}, Unique: true, Partial: true},
+}
+
 type IMongoMapper interface {
 	FindOne(ctx context.Context, id primitive.ObjectID) (*Config, error) // 继承模板类
 	FindOneByUnitID(ctx context.Context, unitID primitive.ObjectID) (*Config, error)
+	FindTemplate(ctx context.Context) (*Config, error)
 	Insert(ctx context.Context, unit *Config) error
 	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
 }
 
 type mongoMapper struct {
@@ -31,13 +40,56 @@ type mongoMapper struct {
 }
 
 func NewMongoMapper(config *config.Config) IMongoMapper {
-	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	conn, c := mapper.NewModel(config, collectionName)
 	return &mongoMapper{
-		IMongoMapper: mapper.NewMongoMapper[Config](conn),
+		IMongoMapper: mapper.NewCachedMongoMapper[Config](conn, c, collectionName, prefixConfigCacheKey, cacheKeys),
 		conn:         conn,
 	}
 }
 
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper() IMongoMapper {
+	return &mongoMapper{IMongoMapper: mapper.NewMemoryMapper[Config](collectionName, indexes)}
+}
+
+// cacheKeys 按ID及UnitID缓存, 没有unitId的模板配置只按ID缓存
+func cacheKeys(c *Config) []string {
+	keys := []string{mapper.CacheKey(prefixConfigCacheKey, cst.ID, c.ID.Hex())}
+	if !c.UnitID.IsZero() {
+		keys = append(keys, unitKey(c.UnitID))
+	}
+	return keys
+}
+
+func unitKey(unitID primitive.ObjectID) string {
+	return mapper.CacheKey(prefixConfigCacheKey, cst.UnitID, unitID.Hex())
+}
+
+// UpdateFields 更新字段并递增版本号
+func (m *mongoMapper) UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	return m.IMongoMapper.Update(ctx, &mapper.FieldsUpdate{ID: id, Set: update, IncVersion: true})
+}
+
 func (m *mongoMapper) FindOneByUnitID(ctx context.Context, unitID primitive.ObjectID) (*Config, error) {
-	return m.FindOneByFields(ctx, bson.M{cst.UnitID: unitID})
+	return m.FindOneByKey(ctx, unitKey(unitID), bson.M{cst.UnitID: unitID})
+}
+
+// FindTemplate 查询最新的模板配置, 即没有unitId的配置, 不存在时返回nil
+func (m *mongoMapper) FindTemplate(ctx context.Context) (*Config, error) {
+	templates, err := m.FindAllByFields(ctx, bson.M{cst.UnitID: bson.M{"$exists": false}})
+	if err != nil {
+		return nil, err
+	}
+	var latest *Config
+	for _, t := range templates {
+		if latest == nil || t.UpdateTime > latest.UpdateTime {
+			latest = t
+		}
+	}
+	return latest, nil
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
 }
@@ -0,0 +1,19 @@
+package consent
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Consent 单位发布的知情同意书, 同一单位同一类型的同意书按版本递增
+type Consent struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UnitID     primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	Type       int                `json:"type" bson:"type"` // AICounseling | Recording | DataProcessing
+	Version    int32              `json:"version,omitempty" bson:"version,omitempty"`
+	Title      string             `json:"title,omitempty" bson:"title,omitempty"`
+	Content    string             `json:"content,omitempty" bson:"content,omitempty"`
+	Status     int                `json:"status,omitempty" bson:"status,omitempty"`
+	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+	UpdateTime int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
+	DeleteTime int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
+}
@@ -0,0 +1,81 @@
+package consent
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixConsentCacheKey = "cache:consent"
+	collectionName        = "consent"
+)
+
+// indexTypeVersion 同一单位同一类型的版本号唯一
+var indexTypeVersion = mapper.Index{Name: "uniq_unitId_type_version", Keys: []string{cst.UnitID, cst.Type, cst.Version}, Unique: true}
+
+var indexes = []mapper.Index{indexTypeVersion}
+
+type IMongoMapper interface {
+	FindOne(ctx context.Context, id primitive.ObjectID) (*Consent, error)
+	FindLatestByUnitIDAndType(ctx context.Context, unitID primitive.ObjectID, consentType int) (*Consent, error)
+	Insert(ctx context.Context, consent *Consent) error
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Consent]
+	conn *monc.Model
+}
+
+func NewMongoMapper(config *config.Config) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Consent](conn, collectionName),
+		conn:         conn,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper() IMongoMapper {
+	return &mongoMapper{IMongoMapper: mapper.NewMemoryMapper[Consent](collectionName, indexes)}
+}
+
+// FindLatestByUnitIDAndType 查询单位某类同意书的最新版本, 不存在时返回nil
+func (m *mongoMapper) FindLatestByUnitIDAndType(ctx context.Context, unitID primitive.ObjectID, consentType int) (*Consent, error) {
+	consents, err := m.FindAllByFields(ctx, bson.M{cst.UnitID: unitID, cst.Type: consentType})
+	if err != nil {
+		return nil, err
+	}
+	var latest *Consent
+	for _, c := range consents {
+		if latest == nil || c.Version > latest.Version {
+			latest = c
+		}
+	}
+	return latest, nil
+}
+
+// Insert 插入同意书, 并发发布导致同一版本号重复时返回ErrConsentVersionConflict
+func (m *mongoMapper) Insert(ctx context.Context, consent *Consent) error {
+	err := m.IMongoMapper.Insert(ctx, consent)
+	if mapper.IsDuplicateKey(err, indexTypeVersion) {
+		return errorx.New(errno.ErrConsentVersionConflict)
+	}
+	return err
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
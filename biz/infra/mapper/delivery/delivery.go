@@ -0,0 +1,32 @@
+package delivery
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 投递状态
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead" // 超过最大重试次数或订阅已删除, 可通过重放重新投递, 重放前同一订阅同一聚合的后续记录不投递
+)
+
+// Delivery 一个事件向一个订阅的投递记录, Body在创建时固定, 重试及重放发送相同的内容
+type Delivery struct {
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	WebhookID    primitive.ObjectID `json:"webhookId,omitempty" bson:"webhookId,omitempty"`
+	UnitID       primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	EventID      primitive.ObjectID `json:"eventId,omitempty" bson:"eventId,omitempty"`
+	Type         string             `json:"type,omitempty" bson:"type,omitempty"`
+	Key          string             `json:"key,omitempty" bson:"key,omitempty"` // 事件所属的聚合, 同一订阅同一聚合的投递按顺序进行
+	Body         string             `json:"body,omitempty" bson:"body,omitempty"`
+	Status       string             `json:"status,omitempty" bson:"status,omitempty"`
+	Attempts     int                `json:"attempts,omitempty" bson:"attempts,omitempty"`
+	NextTime     int64              `json:"nextTime,omitempty" bson:"nextTime,omitempty"` // 下次投递时间, 早于该时间不投递
+	ResponseCode int                `json:"responseCode,omitempty" bson:"responseCode,omitempty"`
+	LastError    string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	Replays      int                `json:"replays,omitempty" bson:"replays,omitempty"` // 手动重放的次数
+	CreateTime   int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+	UpdateTime   int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
+	DeliverTime  int64              `json:"deliverTime,omitempty" bson:"deliverTime,omitempty"`
+}
@@ -0,0 +1,102 @@
+package delivery
+
+import (
+	"context"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixDeliveryCacheKey = "cache:delivery"
+	collectionName         = "delivery"
+)
+
+// indexWebhookEvent 事件至少投递一次, 重复发布同一事件时不重复创建投递
+var indexWebhookEvent = mapper.Index{Name: "uniq_webhookId_eventId", Keys: []string{cst.WebhookID, cst.EventID}, Unique: true}
+
+var indexes = []mapper.Index{
+	indexWebhookEvent,
+	{Name: "idx_status_createTime", Keys: []string{cst.Status, cst.CreateTime, cst.ID}},
+	{Name: "idx_webhookId_createTime", Keys: []string{cst.WebhookID, "-" + cst.CreateTime}},
+	{Name: "idx_webhookId_key_status", Keys: []string{cst.WebhookID, cst.Key, cst.Status}},
+}
+
+type IMongoMapper interface {
+	FindOne(ctx context.Context, id primitive.ObjectID) (*Delivery, error)
+	FindPending(ctx context.Context, after *Delivery, limit int) ([]*Delivery, error)
+	IsBlocked(ctx context.Context, webhookID primitive.ObjectID, key string) (bool, error)
+	FindPage(ctx context.Context, webhookID primitive.ObjectID, status string, opts *mapper.PageOptions) ([]*Delivery, int64, error)
+	Insert(ctx context.Context, delivery *Delivery) error
+	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Delivery]
+	conn *monc.Model
+}
+
+func NewMongoMapper(config *config.Config) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Delivery](conn, collectionName),
+		conn:         conn,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper() IMongoMapper {
+	return &mongoMapper{IMongoMapper: mapper.NewMemoryMapper[Delivery](collectionName, indexes)}
+}
+
+// Insert 插入投递记录, 同一订阅同一事件的记录已存在时忽略
+func (m *mongoMapper) Insert(ctx context.Context, delivery *Delivery) error {
+	if err := m.IMongoMapper.Insert(ctx, delivery); !mapper.IsDuplicateKey(err, indexWebhookEvent) {
+		return err
+	}
+	return nil
+}
+
+// FindPending 按创建顺序查询已到投递时间的记录, after不为nil时从其之后开始, 用于跳过被阻塞的记录继续翻页
+// 同一秒内按ID排序, 排序由idx_status_createTime支持
+func (m *mongoMapper) FindPending(ctx context.Context, after *Delivery, limit int) ([]*Delivery, error) {
+	filter := bson.M{cst.Status: StatusPending, "$nor": bson.A{bson.M{cst.NextTime: bson.M{"$gt": time.Now().Unix()}}}}
+	if after != nil {
+		filter["$or"] = bson.A{
+			bson.M{cst.CreateTime: bson.M{"$gt": after.CreateTime}},
+			bson.M{cst.CreateTime: after.CreateTime, cst.ID: bson.M{"$gt": after.ID}},
+		}
+	}
+	return m.FindSortedByFields(ctx, filter, []string{cst.CreateTime, cst.ID}, int64(limit))
+}
+
+// IsBlocked 同一订阅同一聚合是否有等待重试或进入死信的记录, 有则后续记录不投递
+func (m *mongoMapper) IsBlocked(ctx context.Context, webhookID primitive.ObjectID, key string) (bool, error) {
+	return m.ExistsByFields(ctx, bson.M{cst.WebhookID: webhookID, cst.Key: key, "$or": bson.A{
+		bson.M{cst.Status: StatusDead},
+		bson.M{cst.Status: StatusPending, cst.NextTime: bson.M{"$gt": time.Now().Unix()}},
+	}})
+}
+
+// FindPage 按时间倒序分页查询订阅的投递记录, status为空时不限制
+func (m *mongoMapper) FindPage(ctx context.Context, webhookID primitive.ObjectID, status string, opts *mapper.PageOptions) ([]*Delivery, int64, error) {
+	filter := bson.M{cst.WebhookID: webhookID}
+	if status != "" {
+		filter[cst.Status] = status
+	}
+	return m.FindPageByFields(ctx, filter, opts)
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
@@ -0,0 +1,338 @@
+package mapper
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 内存实现支持的bson查询及更新语义, 仅覆盖mapper中实际使用的操作符
+// 查询: 字段相等(数组字段任一元素相等即匹配)、$eq $ne $gt $gte $lt $lte $in $nin $exists 以及顶层的 $and $or $nor
+// 更新: $set $unset $inc, 字段名支持以.分隔的嵌套路径
+
+// toDocument 将实体或查询条件转换为bson.M, 嵌套文档统一为bson.M
+func toDocument(v any) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err = bson.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return normalize(m).(bson.M), nil
+}
+
+func normalize(v any) any {
+	switch t := v.(type) {
+	case bson.M:
+		for k, e := range t {
+			t[k] = normalize(e)
+		}
+		return t
+	case bson.D:
+		m := make(bson.M, len(t))
+		for _, e := range t {
+			m[e.Key] = normalize(e.Value)
+		}
+		return m
+	case bson.A:
+		for i, e := range t {
+			t[i] = normalize(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// lookup 按路径获取字段, 路径中的数字可以作为数组下标
+func lookup(doc bson.M, path string) (any, bool) {
+	var cur any = doc
+	for _, key := range strings.Split(path, ".") {
+		switch t := cur.(type) {
+		case bson.M:
+			v, ok := t[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case bson.A:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			cur = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// match 文档是否满足查询条件
+func match(doc, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		ok, err := matchKey(doc, key, cond)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchKey(doc bson.M, key string, cond any) (bool, error) {
+	switch key {
+	case "$and", "$or", "$nor":
+		subs, ok := cond.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s requires an array", key)
+		}
+		for _, sub := range subs {
+			f, ok := sub.(bson.M)
+			if !ok {
+				return false, fmt.Errorf("%s requires an array of documents", key)
+			}
+			matched, err := match(doc, f)
+			if err != nil {
+				return false, err
+			}
+			if key == "$and" && !matched {
+				return false, nil
+			}
+			if key != "$and" && matched {
+				return key == "$or", nil
+			}
+		}
+		return key != "$or", nil
+	}
+	if strings.HasPrefix(key, "$") {
+		return false, fmt.Errorf("unsupported operator %s", key)
+	}
+	v, exists := lookup(doc, key)
+	if ops, ok := cond.(bson.M); ok && isOperator(ops) {
+		for op, arg := range ops {
+			matched, err := matchOperator(v, exists, op, arg)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return matchEqual(v, exists, cond), nil
+}
+
+func isOperator(m bson.M) bool {
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(v any, exists bool, op string, arg any) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEqual(v, exists, arg), nil
+	case "$ne":
+		return !matchEqual(v, exists, arg), nil
+	case "$exists":
+		want, ok := arg.(bool)
+		if !ok {
+			return false, fmt.Errorf("$exists requires a bool")
+		}
+		return exists == want, nil
+	case "$in", "$nin":
+		args, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s requires an array", op)
+		}
+		in := false
+		for _, a := range args {
+			if matchEqual(v, exists, a) {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in"), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		if !exists {
+			return false, nil
+		}
+		for _, e := range elements(v) {
+			c, ok := compare(e, arg)
+			if ok && ((op == "$gt" && c > 0) || (op == "$gte" && c >= 0) || (op == "$lt" && c < 0) || (op == "$lte" && c <= 0)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported operator %s", op)
+	}
+}
+
+// matchEqual 与mongo一致, null匹配不存在的字段, 数组字段的任一元素相等即匹配
+func matchEqual(v any, exists bool, want any) bool {
+	if want == nil {
+		return !exists || v == nil
+	}
+	if !exists {
+		return false
+	}
+	if equal(v, want) {
+		return true
+	}
+	if arr, ok := v.(bson.A); ok {
+		for _, e := range arr {
+			if equal(e, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// elements 数组字段比较其中的每个元素
+func elements(v any) []any {
+	if arr, ok := v.(bson.A); ok {
+		return arr
+	}
+	return []any{v}
+}
+
+func equal(a, b any) bool {
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare 比较同类型的值, 不同宽度的数字按数值比较, 无法比较时ok为false
+func compare(a, b any) (int, bool) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return cmp.Compare(x, y), true
+		}
+		return 0, false
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(x.Hex(), y.Hex()), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return cmp.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// applyUpdate 在文档上执行更新操作, update需已经过toDocument转换
+func applyUpdate(doc, update bson.M) error {
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s requires a document", op)
+		}
+		for path, v := range fields {
+			if path == "_id" {
+				return fmt.Errorf("_id is immutable")
+			}
+			switch op {
+			case "$set":
+				setPath(doc, path, v)
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				delta, ok := number(v)
+				if !ok {
+					return fmt.Errorf("$inc requires a number")
+				}
+				cur, exists := lookup(doc, path)
+				if !exists {
+					setPath(doc, path, v)
+					continue
+				}
+				if _, ok = number(cur); !ok {
+					return fmt.Errorf("cannot $inc non-numeric field %s", path)
+				}
+				setPath(doc, path, add(cur, delta))
+			default:
+				return fmt.Errorf("unsupported operator %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+// add 保持原字段的数字类型
+func add(v any, delta float64) any {
+	switch n := v.(type) {
+	case int32:
+		return n + int32(delta)
+	case int64:
+		return n + int64(delta)
+	default:
+		return v.(float64) + delta
+	}
+}
+
+func setPath(doc bson.M, path string, v any) {
+	keys := strings.Split(path, ".")
+	cur := doc
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(bson.M)
+		if !ok {
+			next = bson.M{}
+			cur[key] = next
+		}
+		cur = next
+	}
+	cur[keys[len(keys)-1]] = v
+}
+
+func unsetPath(doc bson.M, path string) {
+	keys := strings.Split(path, ".")
+	cur := doc
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(bson.M)
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, keys[len(keys)-1])
+}
@@ -0,0 +1,180 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	bsonv2 "go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Index 声明式的索引定义, 启动时按名称创建缺失的索引
+type Index struct {
+	Name    string
+	Keys    []string // 字段名, 以-开头表示倒序
+	Unique  bool
+	Partial bool // 只索引包含全部字段的文档, 用于兼容缺少字段的历史数据
+}
+
+// IndexReport 索引检查的结果, 已存在但定义不一致的索引不会被自动重建
+type IndexReport struct {
+	Collection string
+	Created    []string
+	Changed    []string // 与声明不一致, 需要人工处理
+	Unmanaged  []string // 数据库中存在但未声明
+	Failed     []string // 创建失败, 如已有重复数据时无法创建唯一索引
+}
+
+// Drifted 是否存在与声明不一致的情况
+func (r *IndexReport) Drifted() bool {
+	return len(r.Changed) > 0 || len(r.Unmanaged) > 0 || len(r.Failed) > 0
+}
+
+func (r *IndexReport) String() string {
+	return fmt.Sprintf("collection=%s, created=%v, changed=%v, unmanaged=%v, failed=%v",
+		r.Collection, r.Created, r.Changed, r.Unmanaged, r.Failed)
+}
+
+// existingIndex 数据库中已有的索引
+type existingIndex struct {
+	Name    string     `bson:"name"`
+	Key     bsonv2.D   `bson:"key"`
+	Unique  bool       `bson:"unique"`
+	Partial bsonv2.Raw `bson:"partialFilterExpression"`
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper[T]) EnsureIndexes(ctx context.Context, indexes []Index) (*IndexReport, error) {
+	return ensureIndexes(ctx, m.conn, m.collection, indexes)
+}
+
+func ensureIndexes(ctx context.Context, conn *monc.Model, collection string, indexes []Index) (*IndexReport, error) {
+	report := &IndexReport{Collection: collection}
+	cursor, err := conn.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var existing []*existingIndex
+	if err = cursor.All(ctx, &existing); err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*existingIndex, len(existing))
+	for _, e := range existing {
+		byName[e.Name] = e
+	}
+
+	declared := make(map[string]bool, len(indexes))
+	for _, idx := range indexes {
+		declared[idx.Name] = true
+		if e, ok := byName[idx.Name]; ok {
+			if !idx.matches(e) {
+				report.Changed = append(report.Changed, idx.Name)
+			}
+			continue
+		}
+		if _, err = conn.Indexes().CreateOne(ctx, idx.model()); err != nil {
+			logs.Errorf("create index %s.%s error: %s", collection, idx.Name, err)
+			report.Failed = append(report.Failed, idx.Name)
+			continue
+		}
+		report.Created = append(report.Created, idx.Name)
+	}
+	for _, e := range existing {
+		if e.Name != "_id_" && !declared[e.Name] {
+			report.Unmanaged = append(report.Unmanaged, e.Name)
+		}
+	}
+	return report, nil
+}
+
+func (idx *Index) model() mongo.IndexModel {
+	opts := options.Index().SetName(idx.Name)
+	if idx.Unique {
+		opts.SetUnique(true)
+	}
+	if idx.Partial {
+		filter := bsonv2.D{}
+		for _, k := range idx.Keys {
+			filter = append(filter, bsonv2.E{Key: strings.TrimPrefix(k, "-"), Value: bsonv2.M{"$exists": true}})
+		}
+		opts.SetPartialFilterExpression(filter)
+	}
+	return mongo.IndexModel{Keys: idx.keys(), Options: opts}
+}
+
+func (idx *Index) keys() bsonv2.D {
+	return sortKeys(idx.Keys)
+}
+
+// sortKeys 将字段名转换为索引或排序的键, 以-开头表示倒序
+func sortKeys(fields []string) bsonv2.D {
+	keys := make(bsonv2.D, 0, len(fields))
+	for _, k := range fields {
+		if strings.HasPrefix(k, "-") {
+			keys = append(keys, bsonv2.E{Key: k[1:], Value: -1})
+		} else {
+			keys = append(keys, bsonv2.E{Key: k, Value: 1})
+		}
+	}
+	return keys
+}
+
+// matches 比较字段、顺序、唯一性及是否为部分索引
+func (idx *Index) matches(e *existingIndex) bool {
+	if idx.Unique != e.Unique || idx.Partial != (len(e.Partial) > 0) || len(idx.Keys) != len(e.Key) {
+		return false
+	}
+	for i, k := range idx.keys() {
+		if e.Key[i].Key != k.Key || fmt.Sprint(e.Key[i].Value) != fmt.Sprint(k.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsDuplicateKey 错误是否由违反指定的唯一索引引起, 按服务端返回的keyPattern与索引的字段比较
+func IsDuplicateKey(err error, idx Index) bool {
+	if !mongo.IsDuplicateKeyError(err) {
+		return false
+	}
+	var writeErrors []mongo.WriteError
+	var we mongo.WriteException
+	var bwe mongo.BulkWriteException
+	switch {
+	case errors.As(err, &we):
+		writeErrors = we.WriteErrors
+	case errors.As(err, &bwe):
+		for _, e := range bwe.WriteErrors {
+			writeErrors = append(writeErrors, e.WriteError)
+		}
+	}
+	for _, e := range writeErrors {
+		if idx.matchesKeyPattern(e.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesKeyPattern 重复键错误中的keyPattern是否为该索引的字段, 不比较排序方向
+func (idx *Index) matchesKeyPattern(raw bsonv2.Raw) bool {
+	doc, ok := raw.Lookup("keyPattern").DocumentOK()
+	if !ok {
+		return false
+	}
+	elems, err := doc.Elements()
+	if err != nil || len(elems) != len(idx.Keys) {
+		return false
+	}
+	for i, k := range idx.keys() {
+		if elems[i].Key() != k.Key {
+			return false
+		}
+	}
+	return true
+}
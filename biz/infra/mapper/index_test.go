@@ -0,0 +1,29 @@
+package mapper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bsonv2 "go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestIsDuplicateKey(t *testing.T) {
+	code := Index{Name: "uniq_unitId_code", Keys: []string{"unitId", "code"}, Unique: true}
+	phone := Index{Name: "uniq_phone", Keys: []string{"phone"}, Unique: true}
+	// 索引名包含另一个索引名时不能误判
+	codeType := Index{Name: "uniq_unitId_code_type", Keys: []string{"unitId", "code", "type"}, Unique: true}
+
+	err := duplicateKeyError("user", code)
+	assert.True(t, IsDuplicateKey(err, code))
+	assert.False(t, IsDuplicateKey(err, phone))
+	assert.False(t, IsDuplicateKey(duplicateKeyError("user", codeType), code))
+
+	raw, _ := bsonv2.Marshal(bsonv2.D{{Key: "code", Value: 11000}, {Key: "keyPattern", Value: bsonv2.D{{Key: "phone", Value: 1}}}})
+	bulk := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 11000, Raw: raw}}}}
+	assert.True(t, IsDuplicateKey(bulk, phone))
+
+	assert.False(t, IsDuplicateKey(nil, code))
+	assert.False(t, IsDuplicateKey(errors.New("E11000 duplicate key error index: uniq_unitId_code"), code))
+}
@@ -3,67 +3,317 @@ package mapper
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+	"github.com/zeromicro/go-zero/core/stores/mon"
 	"github.com/zeromicro/go-zero/core/stores/monc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
+// PageOptions 分页参数, Page从1开始
+type PageOptions struct {
+	Page  int64
+	Limit int64
+	Sort  []string // 排序字段, 以-开头表示倒序, 为空时按创建时间倒序, 排序应有索引支持
+}
+
+// 默认及最大分页大小
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// BatchSize 分批遍历整个集合时每批的实体数
+const BatchSize = 500
+
+// MaxVersionRetries 后台任务按版本号更新遇到并发修改时, 重新读取后重试的次数
+const MaxVersionRetries = 3
+
+// IsVersionConflict 是否为UpdateFieldsIfVersion的版本冲突
+func IsVersionConflict(err error) bool {
+	var se errorx.StatusError
+	return errors.As(err, &se) && se.Code() == errno.ErrVersionConflict
+}
+
+// Skip 计算需要跳过的记录数, 同时修正不合法的分页参数
+func (p *PageOptions) Skip() int64 {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
+	return (p.Page - 1) * p.Limit
+}
+
+// sortFields 分页的排序字段, 未指定时按创建时间倒序
+func (p *PageOptions) sortFields() []string {
+	if len(p.Sort) == 0 {
+		return []string{"-" + cst.CreateTime}
+	}
+	return p.Sort
+}
+
 type IMongoMapper[T any] interface {
 	FindOneByFields(ctx context.Context, filter bson.M) (*T, error)
 	FindOne(ctx context.Context, id primitive.ObjectID) (*T, error)
+	FindOneByKey(ctx context.Context, key string, filter bson.M) (*T, error)
 	FindAllByFields(ctx context.Context, filter bson.M) ([]*T, error)
+	FindSortedByFields(ctx context.Context, filter bson.M, sort []string, limit int64) ([]*T, error)
+	FindPageByFields(ctx context.Context, filter bson.M, opts *PageOptions) ([]*T, int64, error)
 	Insert(ctx context.Context, data *T) error
 	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error
+	UnsetFields(ctx context.Context, id primitive.ObjectID, fields []string, update bson.M) error
+	Update(ctx context.Context, u *FieldsUpdate) error
+	InsertMany(ctx context.Context, data []*T) error
+	BulkUpdate(ctx context.Context, updates []*FieldsUpdate) (int64, error)
+	DeleteMany(ctx context.Context, filter bson.M) (int64, error)
 	ExistsByFields(ctx context.Context, filter bson.M) (bool, error)
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context, indexes []Index) (*IndexReport, error)
+}
+
+// FieldsUpdate 批量更新中对单个实体的更新, 先删除Unset中的字段再设置Set中的字段
+type FieldsUpdate struct {
+	ID         primitive.ObjectID
+	Set        bson.M
+	Unset      []string
+	IncVersion bool // 同时递增版本号, 用于带版本号的实体的非条件更新
+}
+
+func (u *FieldsUpdate) op() bson.M {
+	op := bson.M{}
+	if len(u.Unset) > 0 {
+		unset := make(bson.M, len(u.Unset))
+		for _, f := range u.Unset {
+			unset[f] = ""
+		}
+		op["$unset"] = unset
+	}
+	if len(u.Set) > 0 {
+		op["$set"] = u.Set
+	}
+	if u.IncVersion {
+		op["$inc"] = bson.M{cst.Version: int64(1)}
+	}
+	return op
 }
 
 type mongoMapper[T any] struct {
-	conn *monc.Model
+	conn       *monc.Model
+	collection string // 用于指标标签
+	cache      cache.Cache
+	prefix     string
+	keys       func(data *T) []string
 }
 
-func NewMongoMapper[T any](conn *monc.Model) IMongoMapper[T] {
-	return &mongoMapper[T]{conn: conn}
+func NewMongoMapper[T any](conn *monc.Model, collection string) IMongoMapper[T] {
+	return &mongoMapper[T]{conn: conn, collection: collection}
 }
 
 // FindOneByFields 根据字段查询实体
-func (m *mongoMapper[T]) FindOneByFields(ctx context.Context, filter bson.M) (*T, error) {
+func (m *mongoMapper[T]) FindOneByFields(ctx context.Context, filter bson.M) (_ *T, err error) {
+	defer metrics.ObserveMongo(m.collection, "findOne", time.Now(), &err)
 	result := new(T)
-	if err := m.conn.FindOneNoCache(ctx, result, filter); err != nil {
+	if err = m.conn.FindOneNoCache(ctx, result, filter); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-// FindOne 根据ID查询实体
+// FindOne 根据ID查询实体, 开启缓存时走缓存
 func (m *mongoMapper[T]) FindOne(ctx context.Context, id primitive.ObjectID) (*T, error) {
-	return m.FindOneByFields(ctx, bson.M{cst.ID: id})
+	return m.FindOneByKey(ctx, m.idKey(id), bson.M{cst.ID: id})
 }
 
 // FindAllByFields 根据字段查询所有实体
-func (m *mongoMapper[T]) FindAllByFields(ctx context.Context, filter bson.M) ([]*T, error) {
+func (m *mongoMapper[T]) FindAllByFields(ctx context.Context, filter bson.M) (_ []*T, err error) {
+	defer metrics.ObserveMongo(m.collection, "find", time.Now(), &err)
 	var result []*T
-	if err := m.conn.Find(ctx, &result, filter); err != nil {
+	if err = m.conn.Find(ctx, &result, filter); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
+// FindSortedByFields 根据字段按sort排序查询前limit个实体, 字段以-开头表示倒序, 排序应有索引支持
+func (m *mongoMapper[T]) FindSortedByFields(ctx context.Context, filter bson.M, sort []string, limit int64) (_ []*T, err error) {
+	defer metrics.ObserveMongo(m.collection, "find", time.Now(), &err)
+	var result []*T
+	if err = m.conn.Find(ctx, &result, filter, options.Find().SetSort(sortKeys(sort)).SetLimit(limit)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FindPageByFields 根据字段按opts.Sort分页查询, 同时返回总数
+func (m *mongoMapper[T]) FindPageByFields(ctx context.Context, filter bson.M, opts *PageOptions) (_ []*T, _ int64, err error) {
+	defer metrics.ObserveMongo(m.collection, "findPage", time.Now(), &err)
+	total, err := m.conn.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	skip := opts.Skip()
+	var result []*T
+	if err = m.conn.Find(ctx, &result, filter, options.Find().
+		SetSort(sortKeys(opts.sortFields())).
+		SetSkip(skip).
+		SetLimit(opts.Limit)); err != nil {
+		return nil, 0, err
+	}
+	return result, total, nil
+}
+
 // Insert 插入实体
-func (m *mongoMapper[T]) Insert(ctx context.Context, data *T) error {
-	_, err := m.conn.InsertOneNoCache(ctx, data)
-	return err
+func (m *mongoMapper[T]) Insert(ctx context.Context, data *T) (err error) {
+	defer metrics.ObserveMongo(m.collection, "insert", time.Now(), &err)
+	if _, err = m.conn.InsertOneNoCache(ctx, data); err != nil {
+		return err
+	}
+	// 删除不存在的占位
+	m.invalidate(ctx, data)
+	return nil
 }
 
 // UpdateFields 更新字段
-func (m *mongoMapper[T]) UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error {
-	_, err := m.conn.UpdateOneNoCache(ctx, bson.M{cst.ID: id}, bson.M{"$set": update})
+func (m *mongoMapper[T]) UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) (err error) {
+	defer metrics.ObserveMongo(m.collection, "update", time.Now(), &err)
+	// 与UpdateOne一致, 没有匹配的实体时不报错
+	_, err = m.updateOne(ctx, bson.M{cst.ID: id}, bson.M{"$set": update})
+	return err
+}
+
+// UpdateFieldsIfVersion 仅在当前版本号为version时更新字段并递增版本号, 否则返回ErrVersionConflict
+func (m *mongoMapper[T]) UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) (err error) {
+	defer metrics.ObserveMongo(m.collection, "update", time.Now(), &err)
+	ok, err := m.updateOne(ctx, versionFilter(id, version), bson.M{"$set": update, "$inc": bson.M{cst.Version: 1}})
+	if err == nil && !ok {
+		err = errorx.New(errno.ErrVersionConflict)
+	}
+	return err
+}
+
+// versionFilter 历史数据没有版本号, 视为版本0
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{cst.ID: id, cst.Version: bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{cst.ID: id, cst.Version: version}
+}
+
+// UnsetFields 删除字段, 同时更新update中的字段
+func (m *mongoMapper[T]) UnsetFields(ctx context.Context, id primitive.ObjectID, fields []string, update bson.M) (err error) {
+	defer metrics.ObserveMongo(m.collection, "unset", time.Now(), &err)
+	u := &FieldsUpdate{ID: id, Set: update, Unset: fields}
+	_, err = m.updateOne(ctx, bson.M{cst.ID: id}, u.op())
+	return err
+}
+
+// Update 按FieldsUpdate更新单个实体
+func (m *mongoMapper[T]) Update(ctx context.Context, u *FieldsUpdate) (err error) {
+	defer metrics.ObserveMongo(m.collection, "update", time.Now(), &err)
+	_, err = m.updateOne(ctx, bson.M{cst.ID: u.ID}, u.op())
 	return err
 }
 
+// InsertMany 按顺序插入实体, 出错时之前的实体已插入, 需要原子性时在事务中调用
+func (m *mongoMapper[T]) InsertMany(ctx context.Context, data []*T) (err error) {
+	defer metrics.ObserveMongo(m.collection, "insertMany", time.Now(), &err)
+	if len(data) == 0 {
+		return nil
+	}
+	docs := make([]any, len(data))
+	for i, d := range data {
+		docs[i] = d
+	}
+	if _, err = m.conn.InsertMany(ctx, docs); err != nil {
+		return err
+	}
+	m.invalidate(ctx, data...)
+	return nil
+}
+
+// BulkUpdate 在一次请求中按顺序更新多个实体, 返回匹配的实体数
+func (m *mongoMapper[T]) BulkUpdate(ctx context.Context, updates []*FieldsUpdate) (_ int64, err error) {
+	defer metrics.ObserveMongo(m.collection, "bulkUpdate", time.Now(), &err)
+	if len(updates) == 0 {
+		return 0, nil
+	}
+	ids := make(bson.A, len(updates))
+	models := make([]mongo.WriteModel, len(updates))
+	for i, u := range updates {
+		ids[i] = u.ID
+		models[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{cst.ID: u.ID}).SetUpdate(u.op())
+	}
+	var before []*T
+	if m.cache != nil {
+		if err = m.conn.Find(ctx, &before, bson.M{cst.ID: bson.M{"$in": ids}}); err != nil {
+			return 0, err
+		}
+	}
+	res, err := m.conn.BulkWrite(ctx, models)
+	if err != nil {
+		return 0, err
+	}
+	if m.cache != nil {
+		var after []*T
+		if err = m.conn.Find(ctx, &after, bson.M{cst.ID: bson.M{"$in": ids}}); err != nil {
+			logs.CtxErrorf(ctx, "find %s after bulk update error: %s", m.collection, err)
+		}
+		m.invalidate(ctx, append(before, after...)...)
+	}
+	return res.MatchedCount, nil
+}
+
+// DeleteMany 删除满足条件的实体, 返回删除的实体数
+// filter按落库的值匹配, 加密字段需要使用对应的盲索引
+func (m *mongoMapper[T]) DeleteMany(ctx context.Context, filter bson.M) (_ int64, err error) {
+	defer metrics.ObserveMongo(m.collection, "deleteMany", time.Now(), &err)
+	var deleted []*T
+	if m.cache != nil {
+		if err = m.conn.Find(ctx, &deleted, filter); err != nil {
+			return 0, err
+		}
+	}
+	count, err := m.conn.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	m.invalidate(ctx, deleted...)
+	return count, nil
+}
+
 // ExistsByFields 根据字段查询是否存在实体
-func (m *mongoMapper[T]) ExistsByFields(ctx context.Context, filter bson.M) (bool, error) {
+func (m *mongoMapper[T]) ExistsByFields(ctx context.Context, filter bson.M) (_ bool, err error) {
+	defer metrics.ObserveMongo(m.collection, "count", time.Now(), &err)
 	count, err := m.conn.CountDocuments(ctx, filter)
 	return count > 0, err
 }
+
+// Ping 检查集合所在的mongo连接是否可用
+func (m *mongoMapper[T]) Ping(ctx context.Context) error {
+	return m.conn.Database().Client().Ping(ctx, nil)
+}
+
+// Disconnect 断开mongo连接, 各集合的monc.Model按URL共用同一个客户端, 断开后所有mapper均不可用
+func Disconnect(ctx context.Context, url string) error {
+	m, err := mon.NewModel(url, "", "")
+	if err != nil {
+		return err
+	}
+	return m.Database().Client().Disconnect(ctx)
+}
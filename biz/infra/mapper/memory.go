@@ -0,0 +1,413 @@
+package mapper
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	bsonv2 "go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// memoryMapper 数据保存在进程内的实现, 用于测试及本地运行, 重启后数据丢失
+// 文档以bson.M保存, 读写时均复制, 唯一索引与mongo一样生效
+type memoryMapper[T any] struct {
+	collection string
+	mu         sync.RWMutex
+	docs       []bson.M // 按插入顺序
+	indexes    []Index
+}
+
+// NewMemoryMapper 创建内存实现, indexes中的唯一索引立即生效
+func NewMemoryMapper[T any](collection string, indexes []Index) IMongoMapper[T] {
+	m := &memoryMapper[T]{collection: collection}
+	_, _ = m.EnsureIndexes(context.Background(), indexes)
+	return m
+}
+
+// FindOneByFields 根据字段查询实体
+func (m *memoryMapper[T]) FindOneByFields(_ context.Context, filter bson.M) (*T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	docs, err := m.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, monc.ErrNotFound
+	}
+	return decode[T](docs[0])
+}
+
+// FindOne 根据ID查询实体
+func (m *memoryMapper[T]) FindOne(ctx context.Context, id primitive.ObjectID) (*T, error) {
+	return m.FindOneByFields(ctx, bson.M{cst.ID: id})
+}
+
+// FindOneByKey 内存实现没有缓存, 直接查询
+func (m *memoryMapper[T]) FindOneByKey(ctx context.Context, _ string, filter bson.M) (*T, error) {
+	return m.FindOneByFields(ctx, filter)
+}
+
+// FindAllByFields 根据字段查询所有实体
+func (m *memoryMapper[T]) FindAllByFields(_ context.Context, filter bson.M) ([]*T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	docs, err := m.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[T](docs)
+}
+
+// FindSortedByFields 根据字段按sort排序查询前limit个实体, 字段以-开头表示倒序
+func (m *memoryMapper[T]) FindSortedByFields(_ context.Context, filter bson.M, sort []string, limit int64) ([]*T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	docs, err := m.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	sortDocs(docs, sort)
+	if limit > 0 && int64(len(docs)) > limit {
+		docs = docs[:limit]
+	}
+	return decodeAll[T](docs)
+}
+
+// FindPageByFields 根据字段按opts.Sort分页查询, 同时返回总数
+func (m *memoryMapper[T]) FindPageByFields(_ context.Context, filter bson.M, opts *PageOptions) ([]*T, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	docs, err := m.find(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	sortDocs(docs, opts.sortFields())
+	total := int64(len(docs))
+	skip := opts.Skip()
+	docs = docs[min(skip, total):min(skip+opts.Limit, total)]
+	result, err := decodeAll[T](docs)
+	return result, total, err
+}
+
+// Insert 插入实体, 与mongo驱动一样在缺少_id时生成
+func (m *memoryMapper[T]) Insert(_ context.Context, data *T) error {
+	doc, err := toDocument(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := doc[cst.ID]; !ok {
+		doc[cst.ID] = primitive.NewObjectID()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.checkUnique(doc, -1); err != nil {
+		return err
+	}
+	m.docs = append(m.docs, doc)
+	return nil
+}
+
+// UpdateFields 更新字段
+func (m *memoryMapper[T]) UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	_, err := m.updateOne(ctx, bson.M{cst.ID: id}, bson.M{"$set": update})
+	return err
+}
+
+// UpdateFieldsIfVersion 仅在当前版本号为version时更新字段并递增版本号, 否则返回ErrVersionConflict
+func (m *memoryMapper[T]) UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error {
+	ok, err := m.updateOne(ctx, versionFilter(id, version), bson.M{"$set": update, "$inc": bson.M{cst.Version: int64(1)}})
+	if err == nil && !ok {
+		err = errorx.New(errno.ErrVersionConflict)
+	}
+	return err
+}
+
+// UnsetFields 删除字段, 同时更新update中的字段
+func (m *memoryMapper[T]) UnsetFields(ctx context.Context, id primitive.ObjectID, fields []string, update bson.M) error {
+	u := &FieldsUpdate{ID: id, Set: update, Unset: fields}
+	_, err := m.updateOne(ctx, bson.M{cst.ID: id}, u.op())
+	return err
+}
+
+// Update 按FieldsUpdate更新单个实体
+func (m *memoryMapper[T]) Update(ctx context.Context, u *FieldsUpdate) error {
+	_, err := m.updateOne(ctx, bson.M{cst.ID: u.ID}, u.op())
+	return err
+}
+
+// InsertMany 按顺序插入实体, 与mongo一样出错时之前的实体已插入
+func (m *memoryMapper[T]) InsertMany(ctx context.Context, data []*T) error {
+	for _, d := range data {
+		if err := m.Insert(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BulkUpdate 按顺序更新多个实体, 返回匹配的实体数
+func (m *memoryMapper[T]) BulkUpdate(ctx context.Context, updates []*FieldsUpdate) (int64, error) {
+	var count int64
+	for _, u := range updates {
+		ok, err := m.updateOne(ctx, bson.M{cst.ID: u.ID}, u.op())
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteMany 删除满足条件的实体, 返回删除的实体数
+func (m *memoryMapper[T]) DeleteMany(_ context.Context, filter bson.M) (int64, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.docs[:0:0]
+	for _, doc := range m.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return 0, fmt.Errorf("memory mapper %s: %w", m.collection, err)
+		}
+		if !ok {
+			kept = append(kept, doc)
+		}
+	}
+	count := int64(len(m.docs) - len(kept))
+	m.docs = kept
+	return count, nil
+}
+
+// updateOne 在副本上更新第一个满足filter的文档, 通过唯一索引检查后替换原文档, 返回是否有文档被更新
+func (m *memoryMapper[T]) updateOne(_ context.Context, filter, op bson.M) (bool, error) {
+	update, err := toDocument(op)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.indexOf(filter)
+	if err != nil || i < 0 {
+		return false, err
+	}
+	doc, err := toDocument(m.docs[i])
+	if err != nil {
+		return false, err
+	}
+	if err = applyUpdate(doc, update); err != nil {
+		return false, err
+	}
+	if err = m.checkUnique(doc, i); err != nil {
+		return false, err
+	}
+	m.docs[i] = doc
+	return true, nil
+}
+
+// ExistsByFields 根据字段查询是否存在实体
+func (m *memoryMapper[T]) ExistsByFields(_ context.Context, filter bson.M) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	docs, err := m.find(filter)
+	return len(docs) > 0, err
+}
+
+// Ping 内存实现总是可用
+func (m *memoryMapper[T]) Ping(context.Context) error {
+	return nil
+}
+
+// EnsureIndexes 记录索引定义, 已有数据违反唯一索引时与mongo一样创建失败
+func (m *memoryMapper[T]) EnsureIndexes(_ context.Context, indexes []Index) (*IndexReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := &IndexReport{Collection: m.collection}
+	for _, idx := range indexes {
+		i := slices.IndexFunc(m.indexes, func(e Index) bool { return e.Name == idx.Name })
+		if i >= 0 {
+			if !slices.Equal(m.indexes[i].Keys, idx.Keys) || m.indexes[i].Unique != idx.Unique || m.indexes[i].Partial != idx.Partial {
+				report.Changed = append(report.Changed, idx.Name)
+			}
+			continue
+		}
+		if m.hasDuplicate(idx) {
+			report.Failed = append(report.Failed, idx.Name)
+			continue
+		}
+		m.indexes = append(m.indexes, idx)
+		report.Created = append(report.Created, idx.Name)
+	}
+	return report, nil
+}
+
+// find 返回满足条件的文档, 调用方需持有锁
+func (m *memoryMapper[T]) find(filter bson.M) ([]bson.M, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	for _, doc := range m.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, fmt.Errorf("memory mapper %s: %w", m.collection, err)
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// indexOf 第一个满足filter的文档的位置, 不存在时返回-1
+func (m *memoryMapper[T]) indexOf(filter bson.M) (int, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return -1, err
+	}
+	for i, doc := range m.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return -1, fmt.Errorf("memory mapper %s: %w", m.collection, err)
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// checkUnique 检查文档是否违反_id及唯一索引, self为被更新文档的位置, 插入时为-1
+func (m *memoryMapper[T]) checkUnique(doc bson.M, self int) error {
+	indexes := append([]Index{{Name: "_id_", Keys: []string{cst.ID}, Unique: true}}, m.indexes...)
+	for _, idx := range indexes {
+		if !idx.Unique {
+			continue
+		}
+		key, ok := idx.values(doc)
+		if !ok {
+			continue
+		}
+		for i, other := range m.docs {
+			if i == self {
+				continue
+			}
+			if v, ok := idx.values(other); ok && slices.EqualFunc(key, v, equal) {
+				return duplicateKeyError(m.collection, idx)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryMapper[T]) hasDuplicate(idx Index) bool {
+	if !idx.Unique {
+		return false
+	}
+	for i, doc := range m.docs {
+		key, ok := idx.values(doc)
+		if !ok {
+			continue
+		}
+		for _, other := range m.docs[i+1:] {
+			if v, ok := idx.values(other); ok && slices.EqualFunc(key, v, equal) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// values 文档在索引字段上的取值, 部分索引在缺少字段时不参与约束
+func (idx *Index) values(doc bson.M) ([]any, bool) {
+	values := make([]any, 0, len(idx.Keys))
+	for _, k := range idx.Keys {
+		v, exists := lookup(doc, strings.TrimPrefix(k, "-"))
+		if !exists && idx.Partial {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+// sortDocs 按字段稳定排序, 与mongo一致, 缺少字段的文档排在最前
+func sortDocs(docs []bson.M, sort []string) {
+	slices.SortStableFunc(docs, func(a, b bson.M) int {
+		for _, k := range sort {
+			field := strings.TrimPrefix(k, "-")
+			x, xok := lookup(a, field)
+			y, yok := lookup(b, field)
+			c, ok := compare(x, y)
+			if !ok {
+				c = cmp.Compare(boolInt(xok && x != nil), boolInt(yok && y != nil))
+			}
+			if strings.HasPrefix(k, "-") {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// duplicateKeyError 构造与mongo相同的错误, 包括服务端返回的keyPattern, 使IsDuplicateKey对两种实现的判断一致
+func duplicateKeyError(collection string, idx Index) error {
+	msg := fmt.Sprintf("E11000 duplicate key error collection: %s index: %s", collection, idx.Name)
+	raw, _ := bsonv2.Marshal(bsonv2.D{
+		{Key: "index", Value: 0},
+		{Key: "code", Value: 11000},
+		{Key: "errmsg", Value: msg},
+		{Key: "keyPattern", Value: idx.keys()},
+	})
+	return mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: msg, Raw: raw}}}
+}
+
+func decode[T any](doc bson.M) (*T, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	result := new(T)
+	if err = bson.Unmarshal(raw, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func decodeAll[T any](docs []bson.M) ([]*T, error) {
+	result := make([]*T, 0, len(docs))
+	for _, doc := range docs {
+		t, err := decode[T](doc)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
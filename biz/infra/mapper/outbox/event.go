@@ -0,0 +1,54 @@
+package outbox
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 事件类型
+const (
+	TypeUserCreated     = "user.created"
+	TypeUserMoved       = "user.moved" // 用户被绑定到其他单位
+	TypeUserDeactivated = "user.deactivated"
+	TypeUserGraduated   = "user.graduated"
+	TypeConsentRevoked  = "consent.revoked"
+	TypeConfigChanged   = "config.changed"
+)
+
+// Types 所有事件类型, 单位webhook可以订阅其中任意类型
+var Types = []string{TypeUserCreated, TypeUserMoved, TypeUserDeactivated, TypeUserGraduated, TypeConsentRevoked, TypeConfigChanged}
+
+// 事件所属的聚合, 同一聚合的事件按产生顺序投递
+const (
+	AggregateUser = "user"
+	AggregateUnit = "unit"
+)
+
+// 投递状态
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead" // 超过最大重试次数, 不再投递, 同一聚合的后续事件在人工处理前也不投递
+)
+
+// Event 与业务写入在同一事务中记录的领域事件, 由投递任务异步发布
+// 投递至少一次, 订阅方应按ID去重
+type Event struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type        string             `json:"type" bson:"type"`
+	Aggregate   string             `json:"aggregate" bson:"aggregate"`
+	AggregateID primitive.ObjectID `json:"aggregateId" bson:"aggregateId"`
+	UnitID      primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	Payload     map[string]any     `json:"payload,omitempty" bson:"payload,omitempty"` // 只包含ID及枚举值, 不包含个人信息
+	Status      string             `json:"-" bson:"status"`
+	Attempts    int                `json:"-" bson:"attempts,omitempty"`
+	NextTime    int64              `json:"-" bson:"nextTime,omitempty"` // 下次投递时间, 早于该时间不投递
+	LastError   string             `json:"-" bson:"lastError,omitempty"`
+	Published   []string           `json:"-" bson:"published,omitempty"` // 已发布成功的Publisher, 重试时跳过
+	CreateTime  int64              `json:"createTime" bson:"createTime"`
+	DeliverTime int64              `json:"-" bson:"deliverTime,omitempty"`
+}
+
+// Key 聚合的唯一标识, 同一Key的事件按顺序投递
+func (e *Event) Key() string {
+	return e.Aggregate + ":" + e.AggregateID.Hex()
+}
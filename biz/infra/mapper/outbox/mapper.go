@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixOutboxCacheKey = "cache:outbox"
+	collectionName       = "outbox"
+)
+
+var indexes = []mapper.Index{
+	{Name: "idx_status_createTime", Keys: []string{cst.Status, cst.CreateTime, cst.ID}},
+	{Name: "idx_aggregate_status", Keys: []string{cst.Aggregate, cst.AggregateID, cst.Status}},
+	{Name: "idx_status_nextTime", Keys: []string{cst.Status, cst.NextTime}},
+}
+
+type IMongoMapper interface {
+	Insert(ctx context.Context, event *Event) error
+	FindPending(ctx context.Context, limit int) ([]*Event, error)
+	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Event]
+	conn *monc.Model
+}
+
+func NewMongoMapper(config *config.Config) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Event](conn, collectionName),
+		conn:         conn,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper() IMongoMapper {
+	return &mongoMapper{IMongoMapper: mapper.NewMemoryMapper[Event](collectionName, indexes)}
+}
+
+// FindPending 按产生顺序查询可以投递的事件
+// 有事件等待重试或进入死信的聚合整体排除在外, 既保持同一聚合内的顺序, 也不会占满查询窗口使其他聚合无法投递
+// 同一秒内按ID排序, ObjectID以时间戳及自增计数开头, 同一进程内与产生顺序一致, 排序由idx_status_createTime支持
+func (m *mongoMapper) FindPending(ctx context.Context, limit int) ([]*Event, error) {
+	now := time.Now().Unix()
+	blocked, err := m.FindAllByFields(ctx, bson.M{"$or": bson.A{
+		bson.M{cst.Status: StatusDead},
+		bson.M{cst.Status: StatusPending, cst.NextTime: bson.M{"$gt": now}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	exclude := bson.A{bson.M{cst.NextTime: bson.M{"$gt": now}}}
+	seen := map[string]bool{}
+	for _, e := range blocked {
+		if !seen[e.Key()] {
+			seen[e.Key()] = true
+			exclude = append(exclude, bson.M{cst.Aggregate: e.Aggregate, cst.AggregateID: e.AggregateID})
+		}
+	}
+	return m.FindSortedByFields(ctx, bson.M{cst.Status: StatusPending, "$nor": exclude}, []string{cst.CreateTime, cst.ID}, int64(limit))
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
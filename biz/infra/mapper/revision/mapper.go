@@ -0,0 +1,161 @@
+package revision
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	confmapper "github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixRevisionCacheKey = "cache:config_revision"
+	collectionName         = "config_revision"
+)
+
+var indexes = []mapper.Index{
+	{Name: "uniq_configId_number", Keys: []string{cst.ConfigID, "-" + cst.Number}, Unique: true},
+}
+
+// IMongoMapper 修订只允许追加, 不提供修改, 只按保留策略删除最早的修订
+type IMongoMapper interface {
+	Insert(ctx context.Context, revision *Revision) error
+	FindByNumber(ctx context.Context, configID primitive.ObjectID, number int64) (*Revision, error)
+	FindLatest(ctx context.Context, configID primitive.ObjectID) (*Revision, error)
+	FindPage(ctx context.Context, configID primitive.ObjectID, opts *mapper.PageOptions) ([]*Revision, int64, error)
+	DeleteBefore(ctx context.Context, configID primitive.ObjectID, number int64) (int64, error)
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Revision]
+	conn    *monc.Model
+	keyring *crypto.Keyring
+}
+
+func NewMongoMapper(config *config.Config, keyring *crypto.Keyring) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Revision](conn, collectionName),
+		conn:         conn,
+		keyring:      keyring,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper(keyring *crypto.Keyring) IMongoMapper {
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMemoryMapper[Revision](collectionName, indexes),
+		keyring:      keyring,
+	}
+}
+
+// Insert 加密快照中的AppID后插入修订, 不修改传入的实体
+func (m *mongoMapper) Insert(ctx context.Context, revision *Revision) error {
+	sealed := *revision
+	if revision.Config != nil {
+		conf, err := m.transform(revision.ID, revision.Config, m.keyring.Encrypt)
+		if err != nil {
+			return err
+		}
+		sealed.Config = conf
+	}
+	return m.IMongoMapper.Insert(ctx, &sealed)
+}
+
+// FindByNumber 查询配置的某个修订
+func (m *mongoMapper) FindByNumber(ctx context.Context, configID primitive.ObjectID, number int64) (*Revision, error) {
+	r, err := m.FindOneByFields(ctx, bson.M{cst.ConfigID: configID, cst.Number: number})
+	if err != nil {
+		return nil, err
+	}
+	return r, m.open(r)
+}
+
+// FindLatest 查询配置的最新修订, 不存在时返回nil
+func (m *mongoMapper) FindLatest(ctx context.Context, configID primitive.ObjectID) (*Revision, error) {
+	revisions, err := m.FindSortedByFields(ctx, bson.M{cst.ConfigID: configID}, []string{"-" + cst.Number}, 1)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return revisions[0], m.open(revisions[0])
+}
+
+// FindPage 按Number倒序分页查询配置的修订, 同一秒内的多次修订也保持顺序, 排序由uniq_configId_number支持
+func (m *mongoMapper) FindPage(ctx context.Context, configID primitive.ObjectID, opts *mapper.PageOptions) ([]*Revision, int64, error) {
+	opts.Sort = []string{"-" + cst.Number}
+	revisions, total, err := m.FindPageByFields(ctx, bson.M{cst.ConfigID: configID}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, r := range revisions {
+		if err = m.open(r); err != nil {
+			return nil, 0, err
+		}
+	}
+	return revisions, total, nil
+}
+
+// DeleteBefore 删除配置中Number小于number的修订, 返回删除的修订数
+func (m *mongoMapper) DeleteBefore(ctx context.Context, configID primitive.ObjectID, number int64) (int64, error) {
+	return m.DeleteMany(ctx, bson.M{cst.ConfigID: configID, cst.Number: bson.M{"$lt": number}})
+}
+
+// open 解密快照中的AppID
+func (m *mongoMapper) open(r *Revision) error {
+	if r.Config == nil {
+		return nil
+	}
+	conf, err := m.transform(r.ID, r.Config, m.keyring.Decrypt)
+	if err != nil {
+		return err
+	}
+	r.Config = conf
+	return nil
+}
+
+// transform 对快照中各应用的AppID执行加密或解密, 返回新的快照, 密文绑定修订ID及字段
+func (m *mongoMapper) transform(id primitive.ObjectID, conf *confmapper.Config, fn func(value, aad string) (string, error)) (*confmapper.Config, error) {
+	out := *conf
+	var err error
+	if conf.Chat != nil {
+		chat := *conf.Chat
+		if chat.AppID, err = fn(chat.AppID, appIDAAD(id, "chat")); err != nil {
+			return nil, err
+		}
+		out.Chat = &chat
+	}
+	if conf.TTS != nil {
+		tts := *conf.TTS
+		if tts.AppID, err = fn(tts.AppID, appIDAAD(id, "tts")); err != nil {
+			return nil, err
+		}
+		out.TTS = &tts
+	}
+	if conf.Report != nil {
+		report := *conf.Report
+		if report.AppID, err = fn(report.AppID, appIDAAD(id, "report")); err != nil {
+			return nil, err
+		}
+		out.Report = &report
+	}
+	return &out, nil
+}
+
+// appIDAAD AppID密文绑定的附加数据
+func appIDAAD(id primitive.ObjectID, app string) string {
+	return crypto.AAD(collectionName, app+".appId", id.Hex())
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
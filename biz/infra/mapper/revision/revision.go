@@ -0,0 +1,28 @@
+package revision
+
+import (
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 产生修订的操作
+const (
+	ActionCreate   = "ConfigCreate"
+	ActionUpdate   = "ConfigUpdateInfo"
+	ActionRollback = "ConfigRollback"
+	ActionBaseline = "Baseline" // 引入修订前已存在的配置, 在首次更新前补记当时的内容
+)
+
+// Revision 配置的一次修订, 只追加不修改, 每个配置只保留最近ConfigRevision.Keep个
+// Number从1开始, 等于写入后配置的Version加1
+type Revision struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ConfigID   primitive.ObjectID `json:"configId,omitempty" bson:"configId,omitempty"`
+	UnitID     primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	Number     int64              `json:"number,omitempty" bson:"number,omitempty"`
+	Config     *config.Config     `json:"config,omitempty" bson:"config,omitempty"` // 修订后配置的完整内容, 开启字段级加密时AppID落库加密
+	Author     string             `json:"author,omitempty" bson:"author,omitempty"`
+	Action     string             `json:"action,omitempty" bson:"action,omitempty"`
+	RollbackOf int64              `json:"rollbackOf,omitempty" bson:"rollbackOf,omitempty"` // 回滚时为目标修订的Number
+	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+}
@@ -0,0 +1,103 @@
+package mapper
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/zeromicro/go-zero/core/stores/mon"
+)
+
+// Transactor 在一个事务中执行跨集合的写入
+type Transactor interface {
+	// WithTransaction fn返回错误时回滚, 遇到临时错误时整个fn会被重试, fn内只应执行数据库操作
+	// fn内必须使用传入的ctx, 事务内的读取不经过缓存, 缓存在提交后失效
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+// tx 事务内登记的提交后操作
+type tx struct {
+	mu          sync.Mutex
+	afterCommit []func(ctx context.Context)
+}
+
+// inTransaction ctx是否处于事务中
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*tx)
+	return ok
+}
+
+// afterCommit 事务中登记提交后执行的操作, 不在事务中时立即执行
+func afterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	t, ok := ctx.Value(txKey{}).(*tx)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.afterCommit = append(t.afterCommit, fn)
+}
+
+// AfterCommit 在事务提交后执行fn, 不在事务中时立即执行, 事务回滚时不执行
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	afterCommit(ctx, fn)
+}
+
+// commit 提交后执行登记的操作
+func (t *tx) commit(ctx context.Context) {
+	for _, fn := range t.afterCommit {
+		fn(context.WithoutCancel(ctx))
+	}
+}
+
+type mongoTransactor struct {
+	url     string
+	db      string
+	enabled bool
+}
+
+// NewMongoTransactor 基于mongo会话的事务, 需要副本集或分片集群, 关闭Mongo.Transactions时直接执行fn
+func NewMongoTransactor(c *config.Config) Transactor {
+	return &mongoTransactor{url: c.Mongo.URL, db: c.Mongo.DB, enabled: c.Mongo.Transactions}
+}
+
+func (m *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !m.enabled {
+		return fn(ctx)
+	}
+	// 各集合的model按URL共用同一个客户端, 会话对所有集合生效
+	model, err := mon.NewModel(m.url, m.db, "")
+	if err != nil {
+		return err
+	}
+	sess, err := model.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	var t *tx
+	if _, err = sess.WithTransaction(ctx, func(sc context.Context) (any, error) {
+		// 重试时丢弃上一次登记的操作
+		t = &tx{}
+		return nil, fn(context.WithValue(sc, txKey{}, t))
+	}); err != nil {
+		return err
+	}
+	t.commit(ctx)
+	return nil
+}
+
+type memoryTransactor struct{}
+
+// NewMemoryTransactor 内存实现不支持回滚, 直接执行fn, 出错时已执行的写入不会撤销
+func NewMemoryTransactor() Transactor {
+	return memoryTransactor{}
+}
+
+func (memoryTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
@@ -0,0 +1,63 @@
+package unit
+
+import (
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// aad 字段密文绑定的附加数据
+func aad(field string, id primitive.ObjectID) string {
+	return crypto.AAD(collectionName, field, id.Hex())
+}
+
+// seal 返回加密后的副本, 加密Phone和Contact, 不修改传入的实体
+func (m *mongoMapper) seal(u *Unit) (*Unit, error) {
+	var err error
+	sealed := *u
+	sealed.PhoneIndex = m.keyring.BlindIndex(u.Phone)
+	if sealed.Phone, err = m.keyring.Encrypt(u.Phone, aad(cst.Phone, u.ID)); err != nil {
+		return nil, err
+	}
+	if sealed.Contact, err = m.keyring.Encrypt(u.Contact, aad(cst.Contact, u.ID)); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// open 原地解密从数据库中读出的实体, 兼容未加密的历史数据
+func (m *mongoMapper) open(u *Unit) error {
+	var err error
+	if u.Phone, err = m.keyring.Decrypt(u.Phone, aad(cst.Phone, u.ID)); err != nil {
+		return err
+	}
+	if u.Contact, err = m.keyring.Decrypt(u.Contact, aad(cst.Contact, u.ID)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sealUpdate 加密对id的更新中涉及的敏感字段, 返回新的更新文档
+func (m *mongoMapper) sealUpdate(id primitive.ObjectID, update bson.M) (bson.M, error) {
+	sealed := make(bson.M, len(update))
+	for k, v := range update {
+		sealed[k] = v
+	}
+	if phone, ok := update[cst.Phone].(string); ok {
+		sealed[cst.PhoneIndex] = m.keyring.BlindIndex(phone)
+		enc, err := m.keyring.Encrypt(phone, aad(cst.Phone, id))
+		if err != nil {
+			return nil, err
+		}
+		sealed[cst.Phone] = enc
+	}
+	if contact, ok := update[cst.Contact].(string); ok {
+		enc, err := m.keyring.Encrypt(contact, aad(cst.Contact, id))
+		if err != nil {
+			return nil, err
+		}
+		sealed[cst.Contact] = enc
+	}
+	return sealed, nil
+}
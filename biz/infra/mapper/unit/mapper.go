@@ -2,10 +2,14 @@ package unit
 
 import (
 	"context"
+	"errors"
 
 	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
 	"github.com/xh-polaris/psych-profile/biz/infra/cst"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
 	"github.com/zeromicro/go-zero/core/stores/monc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,33 +22,246 @@ const (
 	collectionName     = "unit"
 )
 
+// indexPhone 手机号以盲索引唯一, 历史数据的盲索引由迁移补齐
+var indexPhone = mapper.Index{Name: "uniq_phoneIndex", Keys: []string{cst.PhoneIndex}, Unique: true, Partial: true}
+
+var indexes = []mapper.Index{indexPhone}
+
 type IMongoMapper interface {
 	FindOneByPhone(ctx context.Context, phone string) (*Unit, error)
 	FindOne(ctx context.Context, id primitive.ObjectID) (*Unit, error)
 	Insert(ctx context.Context, unit *Unit) error
 	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error
 	ExistsByPhone(ctx context.Context, phone string) (bool, error)
+	Reencrypt(ctx context.Context) (int, error)
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
 }
 
+// mongoMapper 在读写时加解密敏感字段, 业务层只接触明文
 type mongoMapper struct {
 	mapper.IMongoMapper[Unit]
-	conn *monc.Model
+	conn    *monc.Model
+	keyring *crypto.Keyring
+}
+
+func NewMongoMapper(config *config.Config, keyring *crypto.Keyring) IMongoMapper {
+	conn, c := mapper.NewModel(config, collectionName)
+	m := &mongoMapper{conn: conn, keyring: keyring}
+	m.IMongoMapper = mapper.NewCachedMongoMapper[Unit](conn, c, collectionName, prefixUnitCacheKey, m.cacheKeys)
+	return m
 }
 
-func NewMongoMapper(config *config.Config) IMongoMapper {
-	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行, 同样加密敏感字段
+func NewMemoryMapper(keyring *crypto.Keyring) IMongoMapper {
 	return &mongoMapper{
-		IMongoMapper: mapper.NewMongoMapper[Unit](conn),
-		conn:         conn,
+		IMongoMapper: mapper.NewMemoryMapper[Unit](collectionName, indexes),
+		keyring:      keyring,
+	}
+}
+
+// cacheKeys 缓存中的单位为密文, 按ID及手机号缓存
+func (m *mongoMapper) cacheKeys(u *Unit) []string {
+	keys := []string{mapper.CacheKey(prefixUnitCacheKey, cst.ID, u.ID.Hex())}
+	index := u.PhoneIndex
+	if index == "" && u.Phone != "" && !crypto.IsEncrypted(u.Phone) {
+		// 尚未加密的历史数据
+		index = m.keyring.BlindIndex(u.Phone)
+	}
+	if index != "" {
+		keys = append(keys, m.phoneKey(index))
 	}
+	return keys
+}
+
+func (m *mongoMapper) phoneKey(phoneIndex string) string {
+	return mapper.CacheKey(prefixUnitCacheKey, cst.PhoneIndex, phoneIndex)
+}
+
+// findOneByKey 通过缓存查询单位并解密
+func (m *mongoMapper) findOneByKey(ctx context.Context, key string, filter bson.M) (*Unit, error) {
+	u, err := m.IMongoMapper.FindOneByKey(ctx, key, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.open(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindOneByFields 根据字段查询单位并解密
+func (m *mongoMapper) FindOneByFields(ctx context.Context, filter bson.M) (*Unit, error) {
+	u, err := m.IMongoMapper.FindOneByFields(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.open(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindOne 根据ID查询单位并解密
+func (m *mongoMapper) FindOne(ctx context.Context, id primitive.ObjectID) (*Unit, error) {
+	return m.findOneByKey(ctx, mapper.CacheKey(prefixUnitCacheKey, cst.ID, id.Hex()), bson.M{cst.ID: id})
+}
+
+// FindAllByFields 根据字段查询所有单位并解密
+func (m *mongoMapper) FindAllByFields(ctx context.Context, filter bson.M) ([]*Unit, error) {
+	units, err := m.IMongoMapper.FindAllByFields(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range units {
+		if err = m.open(u); err != nil {
+			return nil, err
+		}
+	}
+	return units, nil
+}
+
+// Insert 加密后插入单位, 手机号重复时返回ErrPhoneAlreadyExist
+func (m *mongoMapper) Insert(ctx context.Context, unit *Unit) error {
+	sealed, err := m.seal(unit)
+	if err != nil {
+		return err
+	}
+	return phoneError(m.IMongoMapper.Insert(ctx, sealed))
+}
+
+// InsertMany 加密后按顺序插入单位, 手机号重复时返回ErrPhoneAlreadyExist
+func (m *mongoMapper) InsertMany(ctx context.Context, units []*Unit) error {
+	sealed := make([]*Unit, len(units))
+	for i, u := range units {
+		var err error
+		if sealed[i], err = m.seal(u); err != nil {
+			return err
+		}
+	}
+	return phoneError(m.IMongoMapper.InsertMany(ctx, sealed))
+}
+
+// BulkUpdate 加密后批量更新单位并递增版本号, 返回匹配的单位数
+func (m *mongoMapper) BulkUpdate(ctx context.Context, updates []*mapper.FieldsUpdate) (int64, error) {
+	sealed := make([]*mapper.FieldsUpdate, len(updates))
+	for i, u := range updates {
+		set, err := m.sealUpdate(u.ID, u.Set)
+		if err != nil {
+			return 0, err
+		}
+		sealed[i] = &mapper.FieldsUpdate{ID: u.ID, Set: set, Unset: u.Unset, IncVersion: true}
+	}
+	n, err := m.IMongoMapper.BulkUpdate(ctx, sealed)
+	return n, phoneError(err)
+}
+
+// UpdateFields 加密后更新字段并递增版本号, 手机号重复时返回ErrPhoneAlreadyExist
+func (m *mongoMapper) UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	sealed, err := m.sealUpdate(id, update)
+	if err != nil {
+		return err
+	}
+	return phoneError(m.IMongoMapper.Update(ctx, &mapper.FieldsUpdate{ID: id, Set: sealed, IncVersion: true}))
+}
+
+// UpdateFieldsIfVersion 加密后按版本号条件更新字段, 手机号重复时返回ErrPhoneAlreadyExist
+func (m *mongoMapper) UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error {
+	sealed, err := m.sealUpdate(id, update)
+	if err != nil {
+		return err
+	}
+	return phoneError(m.IMongoMapper.UpdateFieldsIfVersion(ctx, id, version, sealed))
+}
+
+func phoneError(err error) error {
+	if mapper.IsDuplicateKey(err, indexPhone) {
+		return errorx.New(errno.ErrPhoneAlreadyExist)
+	}
+	return err
+}
+
+// phoneFilter 通过盲索引精确匹配手机号, 同时兼容尚未加密的历史数据
+func (m *mongoMapper) phoneFilter(phone string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{cst.PhoneIndex: m.keyring.BlindIndex(phone)},
+		bson.M{cst.Phone: phone},
+	}}
 }
 
 // FindOneByPhone 根据手机号查询单位
 func (m *mongoMapper) FindOneByPhone(ctx context.Context, phone string) (*Unit, error) {
-	return m.FindOneByFields(ctx, bson.M{cst.Phone: phone})
+	return m.findOneByKey(ctx, m.phoneKey(m.keyring.BlindIndex(phone)), m.phoneFilter(phone))
 }
 
 // ExistsByPhone 根据手机号查询单位是否存在
 func (m *mongoMapper) ExistsByPhone(ctx context.Context, phone string) (bool, error) {
-	return m.ExistsByFields(ctx, bson.M{cst.Phone: phone})
+	return m.ExistsByFields(ctx, m.phoneFilter(phone))
+}
+
+// Reencrypt 按ID顺序分批使用当前主密钥重新加密所有单位并补全盲索引, 返回更新的单位数
+func (m *mongoMapper) Reencrypt(ctx context.Context) (int, error) {
+	count := 0
+	filter := bson.M{}
+	for {
+		units, err := m.IMongoMapper.FindSortedByFields(ctx, filter, []string{cst.ID}, mapper.BatchSize)
+		if err != nil {
+			return count, err
+		}
+		for _, u := range units {
+			updated, err := m.reencrypt(ctx, u)
+			if err != nil {
+				return count, err
+			}
+			if updated {
+				count++
+			}
+		}
+		if len(units) < mapper.BatchSize {
+			return count, nil
+		}
+		filter = bson.M{cst.ID: bson.M{"$gt": units[len(units)-1].ID}}
+	}
+}
+
+// reencrypt 重新加密单个单位, 返回是否有更新
+// 按读取时的版本号更新, 期间单位被修改时重新读取后再加密, 避免覆盖其他请求的修改
+func (m *mongoMapper) reencrypt(ctx context.Context, u *Unit) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		updated, err := m.reencryptVersion(ctx, u)
+		if !mapper.IsVersionConflict(err) || attempt == mapper.MaxVersionRetries {
+			return updated, err
+		}
+		if u, err = m.IMongoMapper.FindOne(ctx, u.ID); errors.Is(err, monc.ErrNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// reencryptVersion 仅在单位仍为读取时的版本时重新加密
+func (m *mongoMapper) reencryptVersion(ctx context.Context, u *Unit) (bool, error) {
+	stale := m.keyring.Stale(u.Phone) || m.keyring.Stale(u.Contact)
+	if err := m.open(u); err != nil {
+		return false, err
+	}
+	if !stale && u.PhoneIndex == m.keyring.BlindIndex(u.Phone) {
+		return false, nil
+	}
+	sealed, err := m.seal(u)
+	if err != nil {
+		return false, err
+	}
+	return true, m.IMongoMapper.UpdateFieldsIfVersion(ctx, u.ID, u.Version, bson.M{
+		cst.Phone:      sealed.Phone,
+		cst.PhoneIndex: sealed.PhoneIndex,
+		cst.Contact:    sealed.Contact,
+	})
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
 }
@@ -4,15 +4,26 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AgePolicy 单位的年龄规则, 零值表示使用默认规则
+type AgePolicy struct {
+	MinAge             int32 `json:"minAge,omitempty" bson:"minAge,omitempty"`                         // 注册及登录的最低年龄
+	GuardianAge        int32 `json:"guardianAge,omitempty" bson:"guardianAge,omitempty"`               // 低于该年龄需要监护人签署同意书
+	RestrictedAge      int32 `json:"restrictedAge,omitempty" bson:"restrictedAge,omitempty"`           // 低于该年龄或未填写出生日期时禁用RestrictedFeatures
+	RestrictedFeatures []int `json:"restrictedFeatures,omitempty" bson:"restrictedFeatures,omitempty"` // Chat | TTS | Report
+}
+
 type Unit struct {
 	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Phone      string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	PhoneIndex string             `json:"phoneIndex,omitempty" bson:"phoneIndex,omitempty"` // Phone的盲索引, 用于精确查询
 	Password   string             `json:"password,omitempty" bson:"password,omitempty"`
 	Name       string             `json:"name,omitempty" bson:"name,omitempty"`
 	Address    string             `json:"address,omitempty" bson:"address,omitempty"`
 	Contact    string             `json:"contact,omitempty" bson:"contact,omitempty"`
 	Level      int                `json:"level,omitempty" bson:"level,omitempty"`
 	Status     int                `json:"status,omitempty" bson:"status,omitempty"`
+	AgePolicy  *AgePolicy         `json:"agePolicy,omitempty" bson:"agePolicy,omitempty"`
+	Version    int64              `json:"version,omitempty" bson:"version,omitempty"` // 每次更新递增, 用于乐观并发控制, 历史数据视为0
 	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
 	UpdateTime int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
 	DeleteTime int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
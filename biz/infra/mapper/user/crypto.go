@@ -0,0 +1,155 @@
+package user
+
+import (
+	"strconv"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 联系人字段的AAD, 联系人按优先级排序存储, 不绑定位置
+const (
+	contactName     = cst.Contacts + ".name"
+	contactRelation = cst.Contacts + ".relation"
+	contactPhone    = cst.Contacts + ".phone"
+)
+
+// aad 字段密文绑定的附加数据
+func aad(field string, id primitive.ObjectID) string {
+	return crypto.AAD(collectionName, field, id.Hex())
+}
+
+// seal 返回加密后的副本, 加密Code、Name、Birth及联系人信息, 不修改传入的实体
+func (m *mongoMapper) seal(u *User) (*User, error) {
+	var err error
+	sealed := *u
+	sealed.CodeIndex = m.keyring.BlindIndex(u.Code)
+	if sealed.Code, err = m.keyring.Encrypt(u.Code, aad(cst.Code, u.ID)); err != nil {
+		return nil, err
+	}
+	if sealed.Name, err = m.keyring.Encrypt(u.Name, aad(cst.Name, u.ID)); err != nil {
+		return nil, err
+	}
+	if m.keyring.Enabled() && u.Birth != 0 {
+		if sealed.BirthCipher, err = m.keyring.Encrypt(strconv.FormatInt(u.Birth, 10), aad(cst.BirthCipher, u.ID)); err != nil {
+			return nil, err
+		}
+		sealed.Birth = 0
+	}
+	if sealed.Contacts, err = m.sealContacts(u.ID, u.Contacts); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+func (m *mongoMapper) sealContacts(id primitive.ObjectID, contacts []*Contact) ([]*Contact, error) {
+	var err error
+	sealed := make([]*Contact, 0, len(contacts))
+	for _, c := range contacts {
+		s := *c
+		if s.Name, err = m.keyring.Encrypt(c.Name, aad(contactName, id)); err != nil {
+			return nil, err
+		}
+		if s.Relation, err = m.keyring.Encrypt(c.Relation, aad(contactRelation, id)); err != nil {
+			return nil, err
+		}
+		if s.Phone, err = m.keyring.Encrypt(c.Phone, aad(contactPhone, id)); err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, &s)
+	}
+	return sealed, nil
+}
+
+// open 原地解密从数据库中读出的实体, 兼容未加密的历史数据
+func (m *mongoMapper) open(u *User) error {
+	var err error
+	if u.Code, err = m.keyring.Decrypt(u.Code, aad(cst.Code, u.ID)); err != nil {
+		return err
+	}
+	if u.Name, err = m.keyring.Decrypt(u.Name, aad(cst.Name, u.ID)); err != nil {
+		return err
+	}
+	if u.BirthCipher != "" {
+		birth, err := m.keyring.Decrypt(u.BirthCipher, aad(cst.BirthCipher, u.ID))
+		if err != nil {
+			return err
+		}
+		if u.Birth, err = strconv.ParseInt(birth, 10, 64); err != nil {
+			return err
+		}
+		u.BirthCipher = ""
+	}
+	for _, c := range u.Contacts {
+		if c.Name, err = m.keyring.Decrypt(c.Name, aad(contactName, u.ID)); err != nil {
+			return err
+		}
+		if c.Relation, err = m.keyring.Decrypt(c.Relation, aad(contactRelation, u.ID)); err != nil {
+			return err
+		}
+		if c.Phone, err = m.keyring.Decrypt(c.Phone, aad(contactPhone, u.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealUpdate 加密对id的更新中涉及的敏感字段, 返回新的更新文档
+func (m *mongoMapper) sealUpdate(id primitive.ObjectID, update bson.M) (bson.M, error) {
+	sealed := make(bson.M, len(update))
+	for k, v := range update {
+		sealed[k] = v
+	}
+	if code, ok := update[cst.Code].(string); ok {
+		sealed[cst.CodeIndex] = m.keyring.BlindIndex(code)
+		enc, err := m.keyring.Encrypt(code, aad(cst.Code, id))
+		if err != nil {
+			return nil, err
+		}
+		sealed[cst.Code] = enc
+	}
+	if name, ok := update[cst.Name].(string); ok {
+		enc, err := m.keyring.Encrypt(name, aad(cst.Name, id))
+		if err != nil {
+			return nil, err
+		}
+		sealed[cst.Name] = enc
+	}
+	if birth, ok := update[cst.Birth].(int64); ok {
+		// 同时覆盖两个字段, 避免启用或关闭加密前后的旧值残留
+		sealed[cst.BirthCipher] = ""
+		if m.keyring.Enabled() && birth != 0 {
+			enc, err := m.keyring.Encrypt(strconv.FormatInt(birth, 10), aad(cst.BirthCipher, id))
+			if err != nil {
+				return nil, err
+			}
+			sealed[cst.Birth], sealed[cst.BirthCipher] = int64(0), enc
+		}
+	}
+	if contacts, ok := update[cst.Contacts].([]*Contact); ok {
+		enc, err := m.sealContacts(id, contacts)
+		if err != nil {
+			return nil, err
+		}
+		sealed[cst.Contacts] = enc
+	}
+	return sealed, nil
+}
+
+// stale 数据库中的实体是否存在未加密或非当前主密钥加密的字段
+func (m *mongoMapper) stale(u *User) bool {
+	if m.keyring.Stale(u.Code) || m.keyring.Stale(u.Name) {
+		return true
+	}
+	if m.keyring.Enabled() && u.Birth != 0 || m.keyring.Stale(u.BirthCipher) {
+		return true
+	}
+	for _, c := range u.Contacts {
+		if m.keyring.Stale(c.Name) || m.keyring.Stale(c.Relation) || m.keyring.Stale(c.Phone) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,10 +2,15 @@ package user
 
 import (
 	"context"
+	"errors"
 
 	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
 	"github.com/xh-polaris/psych-profile/biz/infra/cst"
 	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
 	"github.com/zeromicro/go-zero/core/stores/monc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,51 +23,299 @@ const (
 	collectionName     = "user"
 )
 
+// indexUnitCode Code以盲索引在单位内唯一, 历史数据的盲索引由迁移补齐
+var indexUnitCode = mapper.Index{Name: "uniq_unitId_codeIndex", Keys: []string{cst.UnitID, cst.CodeIndex}, Unique: true, Partial: true}
+
+var indexes = []mapper.Index{
+	indexUnitCode,
+	{Name: "uniq_pseudonym", Keys: []string{cst.Pseudonym}, Unique: true, Partial: true},
+}
+
 type IMongoMapper interface {
 	FindOneByCode(ctx context.Context, phone string) (*User, error)
 	FindOneByCodeAndUnitID(ctx context.Context, phone string, unitId primitive.ObjectID) (*User, error)
 	FindOne(ctx context.Context, id primitive.ObjectID) (*User, error)
 	Insert(ctx context.Context, user *User) error
+	InsertMany(ctx context.Context, users []*User) error
 	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error
+	UnsetFields(ctx context.Context, id primitive.ObjectID, fields []string, update bson.M) error
 	ExistsByCode(ctx context.Context, phone string) (bool, error)
 	ExistsByCodeAndUnitID(ctx context.Context, code string, unitID primitive.ObjectID) (bool, error)
 	FindAllByUnitID(ctx context.Context, unitId primitive.ObjectID) ([]*User, error)
+	FindOneByPseudonym(ctx context.Context, pseudonym string) (*User, error)
+	Reencrypt(ctx context.Context) (int, error)
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
 }
 
+// mongoMapper 在读写时加解密敏感字段, 业务层只接触明文
 type mongoMapper struct {
 	mapper.IMongoMapper[User]
-	conn *monc.Model
+	conn    *monc.Model
+	keyring *crypto.Keyring
+}
+
+func NewMongoMapper(config *config.Config, keyring *crypto.Keyring) IMongoMapper {
+	conn, c := mapper.NewModel(config, collectionName)
+	m := &mongoMapper{conn: conn, keyring: keyring}
+	m.IMongoMapper = mapper.NewCachedMongoMapper[User](conn, c, collectionName, prefixUserCacheKey, m.cacheKeys)
+	return m
 }
 
-func NewMongoMapper(config *config.Config) IMongoMapper {
-	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行, 同样加密敏感字段
+func NewMemoryMapper(keyring *crypto.Keyring) IMongoMapper {
 	return &mongoMapper{
-		IMongoMapper: mapper.NewMongoMapper[User](conn),
-		conn:         conn,
+		IMongoMapper: mapper.NewMemoryMapper[User](collectionName, indexes),
+		keyring:      keyring,
+	}
+}
+
+// cacheKeys 缓存中的用户为密文, 按ID及单位内的Code缓存
+func (m *mongoMapper) cacheKeys(u *User) []string {
+	keys := []string{mapper.CacheKey(prefixUserCacheKey, cst.ID, u.ID.Hex())}
+	index := u.CodeIndex
+	if index == "" && u.Code != "" && !crypto.IsEncrypted(u.Code) {
+		// 尚未加密的历史数据
+		index = m.keyring.BlindIndex(u.Code)
+	}
+	if index != "" {
+		keys = append(keys, m.codeKey(index, u.UnitID))
 	}
+	return keys
+}
+
+func (m *mongoMapper) codeKey(codeIndex string, unitId primitive.ObjectID) string {
+	return mapper.CacheKey(prefixUserCacheKey, cst.UnitID, unitId.Hex(), cst.CodeIndex, codeIndex)
+}
+
+// findOneByKey 通过缓存查询用户并解密
+func (m *mongoMapper) findOneByKey(ctx context.Context, key string, filter bson.M) (*User, error) {
+	u, err := m.IMongoMapper.FindOneByKey(ctx, key, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.open(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindOneByFields 根据字段查询用户并解密
+func (m *mongoMapper) FindOneByFields(ctx context.Context, filter bson.M) (*User, error) {
+	u, err := m.IMongoMapper.FindOneByFields(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.open(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindOne 根据ID查询用户并解密
+func (m *mongoMapper) FindOne(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return m.findOneByKey(ctx, mapper.CacheKey(prefixUserCacheKey, cst.ID, id.Hex()), bson.M{cst.ID: id})
+}
+
+// FindAllByFields 根据字段查询所有用户并解密
+func (m *mongoMapper) FindAllByFields(ctx context.Context, filter bson.M) ([]*User, error) {
+	users, err := m.IMongoMapper.FindAllByFields(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if err = m.open(u); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// Insert 加密后插入用户, 同一单位内Code重复时返回对应的业务错误
+func (m *mongoMapper) Insert(ctx context.Context, user *User) error {
+	sealed, err := m.seal(user)
+	if err != nil {
+		return err
+	}
+	return codeError(m.IMongoMapper.Insert(ctx, sealed), user.CodeType)
+}
+
+// InsertMany 加密后按顺序插入用户, 需要原子性时在事务中调用
+// Code重复时按第一个用户的CodeType返回业务错误, 批量导入的用户CodeType相同
+func (m *mongoMapper) InsertMany(ctx context.Context, users []*User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	sealed := make([]*User, len(users))
+	for i, u := range users {
+		var err error
+		if sealed[i], err = m.seal(u); err != nil {
+			return err
+		}
+	}
+	return codeError(m.IMongoMapper.InsertMany(ctx, sealed), users[0].CodeType)
+}
+
+// BulkUpdate 加密后批量更新用户并递增版本号, 返回匹配的用户数
+func (m *mongoMapper) BulkUpdate(ctx context.Context, updates []*mapper.FieldsUpdate) (int64, error) {
+	sealed := make([]*mapper.FieldsUpdate, len(updates))
+	for i, u := range updates {
+		set, err := m.sealUpdate(u.ID, u.Set)
+		if err != nil {
+			return 0, err
+		}
+		sealed[i] = &mapper.FieldsUpdate{ID: u.ID, Set: set, Unset: u.Unset, IncVersion: true}
+	}
+	return m.IMongoMapper.BulkUpdate(ctx, sealed)
+}
+
+// codeError 同一单位内Code重复时返回对应的业务错误
+func codeError(err error, codeType int) error {
+	if !mapper.IsDuplicateKey(err, indexUnitCode) {
+		return err
+	}
+	if codeType == enum.CodeTypePhone {
+		return errorx.New(errno.ErrPhoneAlreadyExist)
+	}
+	return errorx.New(errno.ErrStudentIDAlreadyExist)
+}
+
+// UpdateFields 加密后更新字段并递增版本号
+func (m *mongoMapper) UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	sealed, err := m.sealUpdate(id, update)
+	if err != nil {
+		return err
+	}
+	return m.IMongoMapper.Update(ctx, &mapper.FieldsUpdate{ID: id, Set: sealed, IncVersion: true})
+}
+
+// UpdateFieldsIfVersion 加密后按版本号条件更新字段
+func (m *mongoMapper) UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error {
+	sealed, err := m.sealUpdate(id, update)
+	if err != nil {
+		return err
+	}
+	return m.IMongoMapper.UpdateFieldsIfVersion(ctx, id, version, sealed)
+}
+
+// UnsetFields 删除字段并加密后更新字段, 同时递增版本号
+func (m *mongoMapper) UnsetFields(ctx context.Context, id primitive.ObjectID, fields []string, update bson.M) error {
+	sealed, err := m.sealUpdate(id, update)
+	if err != nil {
+		return err
+	}
+	return m.IMongoMapper.Update(ctx, &mapper.FieldsUpdate{ID: id, Set: sealed, Unset: fields, IncVersion: true})
+}
+
+// codeFilter 通过盲索引精确匹配Code, 同时兼容尚未加密的历史数据
+func (m *mongoMapper) codeFilter(code string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{cst.CodeIndex: m.keyring.BlindIndex(code)},
+		bson.M{cst.Code: code},
+	}}
 }
 
 // FindOneByCode 根据电话号码或学号查询用户
 func (m *mongoMapper) FindOneByCode(ctx context.Context, code string) (*User, error) {
-	return m.FindOneByFields(ctx, bson.M{cst.Code: code})
+	return m.FindOneByFields(ctx, m.codeFilter(code))
 }
 
 // FindOneByCodeAndUnitID 根据电话号码和UnitID查询用户
 func (m *mongoMapper) FindOneByCodeAndUnitID(ctx context.Context, code string, unitId primitive.ObjectID) (*User, error) {
-	return m.FindOneByFields(ctx, bson.M{cst.Code: code, cst.UnitID: unitId})
+	filter := m.codeFilter(code)
+	filter[cst.UnitID] = unitId
+	return m.findOneByKey(ctx, m.codeKey(m.keyring.BlindIndex(code), unitId), filter)
 }
 
 // ExistsByCode 根据电话号码或学号查询用户是否存在
 func (m *mongoMapper) ExistsByCode(ctx context.Context, code string) (bool, error) {
-	return m.ExistsByFields(ctx, bson.M{cst.Code: code})
+	return m.ExistsByFields(ctx, m.codeFilter(code))
 }
 
 // ExistsByCodeAndUnitID 根据电话号码和UnitID查询用户是否存在
 func (m *mongoMapper) ExistsByCodeAndUnitID(ctx context.Context, code string, unitID primitive.ObjectID) (bool, error) {
-	return m.ExistsByFields(ctx, bson.M{cst.Code: code, cst.UnitID: unitID})
+	filter := m.codeFilter(code)
+	filter[cst.UnitID] = unitID
+	return m.ExistsByFields(ctx, filter)
 }
 
 // FindAllByUnitID 根据UnitID查询所有用户
 func (m *mongoMapper) FindAllByUnitID(ctx context.Context, unitId primitive.ObjectID) ([]*User, error) {
 	return m.FindAllByFields(ctx, bson.M{cst.UnitID: unitId})
 }
+
+// FindOneByPseudonym 根据假名查询用户
+func (m *mongoMapper) FindOneByPseudonym(ctx context.Context, pseudonym string) (*User, error) {
+	return m.FindOneByFields(ctx, bson.M{cst.Pseudonym: pseudonym})
+}
+
+// Reencrypt 按ID顺序分批使用当前主密钥重新加密所有用户并补全盲索引, 返回更新的用户数
+// 密钥轮换后执行, 完成前不能移除旧的主密钥
+func (m *mongoMapper) Reencrypt(ctx context.Context) (int, error) {
+	count := 0
+	filter := bson.M{}
+	for {
+		users, err := m.IMongoMapper.FindSortedByFields(ctx, filter, []string{cst.ID}, mapper.BatchSize)
+		if err != nil {
+			return count, err
+		}
+		for _, u := range users {
+			updated, err := m.reencrypt(ctx, u)
+			if err != nil {
+				return count, err
+			}
+			if updated {
+				count++
+			}
+		}
+		if len(users) < mapper.BatchSize {
+			return count, nil
+		}
+		filter = bson.M{cst.ID: bson.M{"$gt": users[len(users)-1].ID}}
+	}
+}
+
+// reencrypt 重新加密单个用户, 返回是否有更新
+// 按读取时的版本号更新, 期间用户被修改时重新读取后再加密, 避免覆盖其他请求的修改
+func (m *mongoMapper) reencrypt(ctx context.Context, u *User) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		updated, err := m.reencryptVersion(ctx, u)
+		if !mapper.IsVersionConflict(err) || attempt == mapper.MaxVersionRetries {
+			return updated, err
+		}
+		if u, err = m.IMongoMapper.FindOne(ctx, u.ID); errors.Is(err, monc.ErrNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// reencryptVersion 仅在用户仍为读取时的版本时重新加密
+func (m *mongoMapper) reencryptVersion(ctx context.Context, u *User) (bool, error) {
+	stale := m.stale(u)
+	if err := m.open(u); err != nil {
+		return false, err
+	}
+	if !stale && u.CodeIndex == m.keyring.BlindIndex(u.Code) {
+		return false, nil
+	}
+	sealed, err := m.seal(u)
+	if err != nil {
+		return false, err
+	}
+	return true, m.IMongoMapper.UpdateFieldsIfVersion(ctx, u.ID, u.Version, bson.M{
+		cst.Code:        sealed.Code,
+		cst.CodeIndex:   sealed.CodeIndex,
+		cst.Name:        sealed.Name,
+		cst.Birth:       sealed.Birth,
+		cst.BirthCipher: sealed.BirthCipher,
+		cst.Contacts:    sealed.Contacts,
+	})
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
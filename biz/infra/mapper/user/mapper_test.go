@@ -0,0 +1,56 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// racingMapper 在第一次按版本号更新前执行edit, 模拟重新加密期间其他请求对用户的修改
+type racingMapper struct {
+	mapper.IMongoMapper[User]
+	edit func()
+}
+
+func (m *racingMapper) UpdateFieldsIfVersion(ctx context.Context, id primitive.ObjectID, version int64, update bson.M) error {
+	if m.edit != nil {
+		m.edit()
+		m.edit = nil
+	}
+	return m.IMongoMapper.UpdateFieldsIfVersion(ctx, id, version, update)
+}
+
+// 重新加密期间被修改的用户重新读取后再加密, 不会覆盖其他请求的修改
+func TestReencryptKeepsConcurrentEdit(t *testing.T) {
+	ctx := context.Background()
+	c := &config.Config{}
+	c.Pseudonym.Key = "pseudonym-key"
+	keyring, err := crypto.NewKeyring(c)
+	require.NoError(t, err)
+
+	base := mapper.NewMemoryMapper[User](collectionName, indexes)
+	// 历史数据没有盲索引
+	u := &User{ID: primitive.NewObjectID(), Code: "13800000001", Name: "张三"}
+	require.NoError(t, base.Insert(ctx, u))
+
+	m := &mongoMapper{IMongoMapper: &racingMapper{IMongoMapper: base, edit: func() {
+		require.NoError(t, base.UpdateFieldsIfVersion(ctx, u.ID, 0, bson.M{cst.Name: "李四"}))
+	}}, keyring: keyring}
+	count, err := m.Reencrypt(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	got, err := m.FindOne(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "李四", got.Name)
+	assert.Equal(t, keyring.BlindIndex("13800000001"), got.CodeIndex)
+	assert.EqualValues(t, 2, got.Version)
+}
@@ -4,21 +4,35 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Contact 监护人及紧急联系人
+type Contact struct {
+	Type     int    `json:"type" bson:"type"` // Guardian | Emergency
+	Name     string `json:"name,omitempty" bson:"name,omitempty"`
+	Relation string `json:"relation,omitempty" bson:"relation,omitempty"`
+	Phone    string `json:"phone,omitempty" bson:"phone,omitempty"`
+	Priority int32  `json:"priority" bson:"priority"` // 数值越小越优先联系
+}
+
 type User struct {
-	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	CodeType   int                `json:"codeType,omitempty" bson:"codeType,omitempty"` // Phone | StudentID
-	Code       string             `json:"code,omitempty" bson:"code,omitempty"`
-	Password   string             `json:"password,omitempty" bson:"password,omitempty"`
-	UnitID     primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
-	Name       string             `json:"name,omitempty" bson:"name,omitempty"`
-	Birth      int64              `json:"birth,omitempty" bson:"birth,omitempty"`
-	Gender     int                `json:"gender,omitempty" bson:"gender,omitempty"`
-	Status     int                `json:"status,omitempty" bson:"status,omitempty"`
-	EnrollYear int32              `json:"enrollYear,omitempty" bson:"enrollYear,omitempty"`
-	Grade      int32              `json:"grade,omitempty" bson:"grade,omitempty"`
-	Class      int32              `json:"class,omitempty" bson:"class,omitempty"`
-	Options    map[string]any     `json:"option,omitempty" bson:"option,omitempty"`
-	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
-	UpdateTime int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
-	DeleteTime int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	CodeType    int                `json:"codeType,omitempty" bson:"codeType,omitempty"` // Phone | StudentID
+	Code        string             `json:"code,omitempty" bson:"code,omitempty"`
+	CodeIndex   string             `json:"codeIndex,omitempty" bson:"codeIndex,omitempty"` // Code的盲索引, 用于精确查询
+	Password    string             `json:"password,omitempty" bson:"password,omitempty"`
+	UnitID      primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	Name        string             `json:"name,omitempty" bson:"name,omitempty"`
+	Birth       int64              `json:"birth,omitempty" bson:"birth,omitempty"`
+	BirthCipher string             `json:"birthCipher,omitempty" bson:"birthCipher,omitempty"` // 加密后的Birth
+	Gender      int                `json:"gender,omitempty" bson:"gender,omitempty"`
+	Status      int                `json:"status,omitempty" bson:"status,omitempty"`
+	EnrollYear  int32              `json:"enrollYear,omitempty" bson:"enrollYear,omitempty"`
+	Grade       int32              `json:"grade,omitempty" bson:"grade,omitempty"`
+	Class       int32              `json:"class,omitempty" bson:"class,omitempty"`
+	Options     map[string]any     `json:"options,omitempty" bson:"options,omitempty"`
+	Contacts    []*Contact         `json:"contacts,omitempty" bson:"contacts,omitempty"`
+	Pseudonym   string             `json:"pseudonym,omitempty" bson:"pseudonym,omitempty"` // 提供给下游分析及AI服务的假名
+	Version     int64              `json:"version,omitempty" bson:"version,omitempty"`     // 每次更新递增, 用于乐观并发控制, 历史数据视为0
+	CreateTime  int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+	UpdateTime  int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
+	DeleteTime  int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
 }
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/cst"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/enum"
+	"github.com/zeromicro/go-zero/core/stores/monc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ IMongoMapper = (*mongoMapper)(nil)
+
+const (
+	prefixWebhookCacheKey = "cache:webhook"
+	collectionName        = "webhook"
+)
+
+var indexes = []mapper.Index{
+	{Name: "idx_unitId_status", Keys: []string{cst.UnitID, cst.Status}},
+}
+
+type IMongoMapper interface {
+	FindOne(ctx context.Context, id primitive.ObjectID) (*Webhook, error)
+	FindActiveByUnitID(ctx context.Context, unitID primitive.ObjectID) ([]*Webhook, error)
+	Insert(ctx context.Context, webhook *Webhook) error
+	UpdateFields(ctx context.Context, id primitive.ObjectID, update bson.M) error
+	Ping(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error)
+}
+
+type mongoMapper struct {
+	mapper.IMongoMapper[Webhook]
+	conn    *monc.Model
+	keyring *crypto.Keyring
+}
+
+func NewMongoMapper(config *config.Config, keyring *crypto.Keyring) IMongoMapper {
+	conn := monc.MustNewModel(config.Mongo.URL, config.Mongo.DB, collectionName, config.Cache)
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMongoMapper[Webhook](conn, collectionName),
+		conn:         conn,
+		keyring:      keyring,
+	}
+}
+
+// NewMemoryMapper 数据保存在进程内的实现, 用于测试及本地运行
+func NewMemoryMapper(keyring *crypto.Keyring) IMongoMapper {
+	return &mongoMapper{
+		IMongoMapper: mapper.NewMemoryMapper[Webhook](collectionName, indexes),
+		keyring:      keyring,
+	}
+}
+
+// FindOne 根据ID查询订阅并解密密钥
+func (m *mongoMapper) FindOne(ctx context.Context, id primitive.ObjectID) (*Webhook, error) {
+	w, err := m.IMongoMapper.FindOne(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if w.Secret, err = m.keyring.Decrypt(w.Secret, secretAAD(w.ID)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// FindActiveByUnitID 查询单位未删除的订阅并解密密钥
+func (m *mongoMapper) FindActiveByUnitID(ctx context.Context, unitID primitive.ObjectID) ([]*Webhook, error) {
+	webhooks, err := m.FindAllByFields(ctx, bson.M{cst.UnitID: unitID, cst.Status: bson.M{"$ne": enum.Deleted}})
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range webhooks {
+		if w.Secret, err = m.keyring.Decrypt(w.Secret, secretAAD(w.ID)); err != nil {
+			return nil, err
+		}
+	}
+	return webhooks, nil
+}
+
+// Insert 加密密钥后插入订阅, 不修改传入的实体
+func (m *mongoMapper) Insert(ctx context.Context, webhook *Webhook) error {
+	sealed := *webhook
+	var err error
+	if sealed.Secret, err = m.keyring.Encrypt(webhook.Secret, secretAAD(webhook.ID)); err != nil {
+		return err
+	}
+	return m.IMongoMapper.Insert(ctx, &sealed)
+}
+
+// secretAAD 签名密钥密文绑定的附加数据
+func secretAAD(id primitive.ObjectID) string {
+	return crypto.AAD(collectionName, cst.Secret, id.Hex())
+}
+
+// EnsureIndexes 创建缺失的索引并报告与声明不一致的索引
+func (m *mongoMapper) EnsureIndexes(ctx context.Context) (*mapper.IndexReport, error) {
+	return m.IMongoMapper.EnsureIndexes(ctx, indexes)
+}
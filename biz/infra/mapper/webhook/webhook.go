@@ -0,0 +1,28 @@
+package webhook
+
+import (
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TypeTest 测试事件的类型, 只通过WebhookTest发送, 不能订阅
+const TypeTest = "webhook.test"
+
+// Webhook 单位登记的事件订阅, 单位内发生Types中的事件时向URL投递签名后的请求
+type Webhook struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UnitID     primitive.ObjectID `json:"unitId,omitempty" bson:"unitId,omitempty"`
+	URL        string             `json:"url,omitempty" bson:"url,omitempty"`
+	Types      []string           `json:"types,omitempty" bson:"types,omitempty"`
+	Secret     string             `json:"secret,omitempty" bson:"secret,omitempty"` // HMAC-SHA256签名密钥, 开启字段级加密时落库加密
+	Status     int                `json:"status,omitempty" bson:"status,omitempty"`
+	CreateTime int64              `json:"createTime,omitempty" bson:"createTime,omitempty"`
+	UpdateTime int64              `json:"updateTime,omitempty" bson:"updateTime,omitempty"`
+	DeleteTime int64              `json:"deleteTime,omitempty" bson:"deleteTime,omitempty"`
+}
+
+// Subscribed 是否订阅了某类事件
+func (w *Webhook) Subscribed(typ string) bool {
+	return slices.Contains(w.Types, typ)
+}
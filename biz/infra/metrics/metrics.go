@@ -0,0 +1,185 @@
+// Package metrics 定义服务的Prometheus指标
+// 指标注册在默认的registry上, 由go-zero的DevServer在DevServer.Port的MetricsPath上暴露
+// DevServer未开启指标时所有记录均为空操作
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/metric"
+)
+
+const namespace = "psych_profile"
+
+var (
+	rpcRequests = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "requests_total",
+		Help:      "rpc请求数",
+		Labels:    []string{"method", "code", "affect_stability"},
+	})
+	rpcDuration = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "duration_ms",
+		Help:      "rpc请求耗时(ms)",
+		Labels:    []string{"method", "code", "affect_stability"},
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000},
+	})
+	rpcSystemErrors = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "system_errors_total",
+		Help:      "影响稳定性的rpc错误数, 与requests_total相除得到系统错误率",
+		Labels:    []string{"method"},
+	})
+
+	mongoDuration = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "duration_ms",
+		Help:      "mongo操作耗时(ms)",
+		Labels:    []string{"collection", "op", "result"},
+		Buckets:   []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000},
+	})
+
+	cacheRequests = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "mapper缓存读取数, result为hit、negative_hit或miss",
+		Labels:    []string{"collection", "result"},
+	})
+
+	bcryptDuration = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: namespace,
+		Subsystem: "bcrypt",
+		Name:      "duration_ms",
+		Help:      "bcrypt计算耗时(ms)",
+		Labels:    []string{"op"},
+		Buckets:   []float64{10, 25, 50, 75, 100, 150, 250, 500},
+	})
+
+	signUps = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "business",
+		Name:      "sign_ups_total",
+		Help:      "注册数",
+		Labels:    []string{"kind"},
+	})
+	signInFailures = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "business",
+		Name:      "sign_in_failures_total",
+		Help:      "登录失败数",
+		Labels:    []string{"kind", "reason"},
+	})
+	bulkImportRows = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "business",
+		Name:      "bulk_import_rows_total",
+		Help:      "批量导入用户的行数",
+		Labels:    []string{"result"},
+	})
+	events = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "outbox",
+		Name:      "events_total",
+		Help:      "事件投递数, result为delivered、retry或dead",
+		Labels:    []string{"type", "result"},
+	})
+	unversionedWrites = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "business",
+		Name:      "unversioned_writes_total",
+		Help:      "未携带版本号的更新数",
+		Labels:    []string{"method"},
+	})
+	webhookDeliveries = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "webhook",
+		Name:      "deliveries_total",
+		Help:      "单位webhook投递数, result与outbox事件相同",
+		Labels:    []string{"result"},
+	})
+)
+
+// 业务指标的标签取值
+const (
+	KindUser = "user"
+	KindUnit = "unit"
+
+	ReasonAccount  = "account"  // 账号不存在
+	ReasonPassword = "password" // 密码错误
+	ReasonAge      = "age"      // 年龄不符合单位要求
+
+	CacheHit         = "hit"
+	CacheNegativeHit = "negative_hit" // 命中不存在的占位
+	CacheMiss        = "miss"
+
+	ImportSuccess = "success"
+	ImportSkip    = "skip"
+
+	EventDelivered = "delivered"
+	EventRetry     = "retry"
+	EventDead      = "dead" // 超过最大重试次数
+)
+
+// ObserveRPC 记录一次rpc请求, affectStability为true时计入系统错误
+func ObserveRPC(method string, code int32, affectStability bool, latency time.Duration) {
+	c, s := strconv.Itoa(int(code)), strconv.FormatBool(affectStability)
+	rpcRequests.Inc(method, c, s)
+	rpcDuration.ObserveFloat(float64(latency.Microseconds())/1000, method, c, s)
+	if affectStability {
+		rpcSystemErrors.Inc(method)
+	}
+}
+
+// ObserveMongo 记录一次mongo操作, 用法为 defer metrics.ObserveMongo(collection, op, time.Now(), &err)
+func ObserveMongo(collection, op string, start time.Time, err *error) {
+	result := "ok"
+	if err != nil && *err != nil {
+		result = "error"
+	}
+	mongoDuration.ObserveFloat(float64(time.Since(start).Microseconds())/1000, collection, op, result)
+}
+
+// IncCache 记录一次缓存读取
+func IncCache(collection, result string) {
+	cacheRequests.Inc(collection, result)
+}
+
+// ObserveBcrypt 记录一次bcrypt计算, op为hash或check
+func ObserveBcrypt(op string, start time.Time) {
+	bcryptDuration.ObserveFloat(float64(time.Since(start).Microseconds())/1000, op)
+}
+
+func IncSignUp(kind string) {
+	signUps.Inc(kind)
+}
+
+func IncSignInFailure(kind, reason string) {
+	signInFailures.Inc(kind, reason)
+}
+
+// IncBulkImportRow 逐行记录, 中途失败的导入已写入的行也会被计入
+func IncBulkImportRow(result string) {
+	bulkImportRows.Inc(result)
+}
+
+// IncUnversionedWrite 记录一次未携带版本号、退化为服务端读取的版本号的更新
+func IncUnversionedWrite(method string) {
+	unversionedWrites.Inc(method)
+}
+
+// IncEvent 记录一次事件投递的结果
+func IncEvent(typ, result string) {
+	events.Inc(typ, result)
+}
+
+// IncWebhookDelivery 记录一次单位webhook投递的结果
+func IncWebhookDelivery(result string) {
+	webhookDeliveries.Inc(result)
+}
@@ -0,0 +1,191 @@
+// Package migration 按版本顺序执行mongo数据迁移, 已执行的版本记录在migration集合中
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"github.com/zeromicro/go-zero/core/stores/mon"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const collectionName = "migration"
+
+// 迁移记录的状态
+const (
+	stateRunning = "running"
+	stateDone    = "done"
+)
+
+// 执行中的实例按heartbeatInterval续期, 超过leaseTimeout未续期视为实例已退出, 其他实例可以接管
+// 其他实例按pollInterval检查迁移是否完成, 滚动部署时不会因迁移正在执行而启动失败
+const (
+	leaseTimeout      = 2 * time.Minute
+	heartbeatInterval = 30 * time.Second
+	pollInterval      = 5 * time.Second
+)
+
+// Migration 一次向上迁移, Up需要可重复执行, 中途失败后重新执行不应破坏数据
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// record 迁移记录, 以版本号为主键, 多个实例同时启动时只有一个能执行
+type record struct {
+	Version       int    `bson:"_id"`
+	Name          string `bson:"name"`
+	State         string `bson:"state"`
+	StartTime     int64  `bson:"startTime"`
+	HeartbeatTime int64  `bson:"heartbeatTime,omitempty"` // 执行中的续期时间, 早期的记录没有该字段, 以StartTime代替
+	EndTime       int64  `bson:"endTime,omitempty"`
+}
+
+// migrations 所有迁移, 新的迁移追加在末尾, 已发布的迁移不能修改
+var migrations = []*Migration{
+	configFieldCase,
+	userOptions,
+	userPseudonym,
+	blindIndex,
+}
+
+type Runner struct {
+	url        string
+	dbName     string
+	migrations []*Migration
+}
+
+// NewRunner 在Run时才连接mongo, 使用内存存储时可以创建但不应执行
+func NewRunner(c *config.Config) *Runner {
+	return &Runner{url: c.Mongo.URL, dbName: c.Mongo.DB, migrations: migrations}
+}
+
+// Run 按版本顺序执行尚未执行的迁移, 任一迁移失败时停止
+func (r *Runner) Run(ctx context.Context) error {
+	sorted := make([]*Migration, len(r.migrations))
+	copy(sorted, r.migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+
+	model, err := mon.NewModel(r.url, r.dbName, collectionName)
+	if err != nil {
+		return err
+	}
+	db := model.Database()
+	coll := db.Collection(collectionName)
+	applied := 0
+	for _, m := range sorted {
+		done, err := acquire(ctx, coll, m)
+		if err != nil {
+			return err
+		}
+		if done {
+			continue
+		}
+		logs.Infof("apply migration %d %s", m.Version, m.Name)
+		if err = runWithHeartbeat(ctx, coll, m, db); err != nil {
+			// 删除占位以便修复后重试, 迁移需要可重复执行
+			if _, e := coll.DeleteOne(ctx, bson.M{"_id": m.Version}); e != nil {
+				logs.Errorf("delete migration record %d error: %s", m.Version, e)
+			}
+			return fmt.Errorf("apply migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if _, err = coll.UpdateByID(ctx, m.Version, bson.M{"$set": bson.M{"state": stateDone, "endTime": time.Now().Unix()}}); err != nil {
+			return err
+		}
+		applied++
+	}
+	logs.Infof("migrations done, applied=%d, total=%d", applied, len(sorted))
+	return nil
+}
+
+// acquire 返回迁移是否已执行, 未执行时占位后返回false
+// 其他实例正在执行时等待其完成、失败后删除占位或租约过期, 再重新检查
+func acquire(ctx context.Context, coll *mongo.Collection, m *Migration) (bool, error) {
+	waiting := false
+	for {
+		var rec record
+		err := coll.FindOne(ctx, bson.M{"_id": m.Version}).Decode(&rec)
+		if err == nil && rec.State == stateDone {
+			return true, nil
+		}
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, err
+		}
+
+		claimed, err := claim(ctx, coll, m)
+		if err != nil || claimed {
+			return false, err
+		}
+		if !waiting {
+			logs.Infof("migration %d %s is being applied by another instance, waiting", m.Version, m.Name)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// claim 先占位再执行, 其他实例插入时会因主键冲突失败, 返回是否占位成功
+// 已有的占位超过租约未续期时说明执行的实例已退出, 接管后重新执行, 迁移需要可重复执行
+func claim(ctx context.Context, coll *mongo.Collection, m *Migration) (bool, error) {
+	now := time.Now().Unix()
+	_, err := coll.InsertOne(ctx, record{Version: m.Version, Name: m.Name, State: stateRunning, StartTime: now, HeartbeatTime: now})
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	deadline := now - int64(leaseTimeout.Seconds())
+	res, err := coll.UpdateOne(ctx, bson.M{
+		"_id":   m.Version,
+		"state": stateRunning,
+		"$or": bson.A{
+			bson.M{"heartbeatTime": bson.M{"$lt": deadline}},
+			bson.M{"heartbeatTime": bson.M{"$exists": false}, "startTime": bson.M{"$lt": deadline}},
+		},
+	}, bson.M{"$set": bson.M{"startTime": now, "heartbeatTime": now}})
+	if err != nil || res.ModifiedCount == 0 {
+		return false, err
+	}
+	logs.Warnf("take over expired migration %d %s", m.Version, m.Name)
+	return true, nil
+}
+
+// runWithHeartbeat 执行迁移期间定期续期占位
+func runWithHeartbeat(ctx context.Context, coll *mongo.Collection, m *Migration, db *mongo.Database) error {
+	hbCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hbCtx.Done():
+				return
+			case <-ticker.C:
+				if _, err := coll.UpdateOne(hbCtx, bson.M{"_id": m.Version, "state": stateRunning},
+					bson.M{"$set": bson.M{"heartbeatTime": time.Now().Unix()}}); err != nil && hbCtx.Err() == nil {
+					logs.Errorf("renew migration %d lease error: %s", m.Version, err)
+				}
+			}
+		}
+	}()
+	return m.Up(ctx, db)
+}
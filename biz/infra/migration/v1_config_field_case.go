@@ -0,0 +1,40 @@
+package migration
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// configFieldCase 修复配置更新时写入的小写字段名
+// 更新接口曾写入{chat,tts,report}.appid、{chat,tts,report}.updatetime及updatetime, 与实体的appId、updateTime不一致
+// 小写字段总是由更新写入, 比创建时写入的字段更新, 因此直接覆盖; 子文档的updatetime没有对应字段, 直接删除
+var configFieldCase = &Migration{
+	Version: 1,
+	Name:    "config_field_case",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		coll := db.Collection("config")
+		renames := []struct{ from, to string }{
+			{"chat.appid", "chat.appId"},
+			{"tts.appid", "tts.appId"},
+			{"report.appid", "report.appId"},
+			{"updatetime", "updateTime"},
+		}
+		for _, r := range renames {
+			if _, err := coll.UpdateMany(ctx,
+				bson.M{r.from: bson.M{"$exists": true}},
+				bson.M{"$rename": bson.M{r.from: r.to}}); err != nil {
+				return err
+			}
+		}
+		for _, f := range []string{"chat.updatetime", "tts.updatetime", "report.updatetime"} {
+			if _, err := coll.UpdateMany(ctx,
+				bson.M{f: bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{f: ""}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,27 @@
+package migration
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// userOptions 统一用户的自定义选项字段
+// 注册时按实体写入option, 更新接口写入options, 两者同时存在时以更新写入的options为准
+var userOptions = &Migration{
+	Version: 2,
+	Name:    "user_options",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		coll := db.Collection("user")
+		if _, err := coll.UpdateMany(ctx,
+			bson.M{"option": bson.M{"$exists": true}, "options": bson.M{"$exists": false}},
+			bson.M{"$rename": bson.M{"option": "options"}}); err != nil {
+			return err
+		}
+		_, err := coll.UpdateMany(ctx,
+			bson.M{"option": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"option": ""}})
+		return err
+	},
+}
@@ -0,0 +1,46 @@
+package migration
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/util/encrypt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// userPseudonym 为历史用户补齐假名, 并修正单位变更后失效的假名
+// 假名只由单位ID和用户ID计算, 重复执行结果相同, 更新假名时递增版本号
+var userPseudonym = &Migration{
+	Version: 3,
+	Name:    "user_pseudonym",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		key := config.GetConfig().Pseudonym.Key
+		coll := db.Collection("user")
+		cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"unitId": 1, "pseudonym": 1}))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = cursor.Close(ctx) }()
+
+		for cursor.Next(ctx) {
+			var u struct {
+				ID        bson.ObjectID `bson:"_id"`
+				UnitID    bson.ObjectID `bson:"unitId"`
+				Pseudonym string        `bson:"pseudonym"`
+			}
+			if err = cursor.Decode(&u); err != nil {
+				return err
+			}
+			pseudonym := encrypt.Pseudonym(key, u.UnitID.Hex(), u.ID.Hex())
+			if u.Pseudonym == pseudonym {
+				continue
+			}
+			if _, err = coll.UpdateByID(ctx, u.ID, bson.M{"$set": bson.M{"pseudonym": pseudonym}, "$inc": bson.M{"version": int64(1)}}); err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	},
+}
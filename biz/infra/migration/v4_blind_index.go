@@ -0,0 +1,73 @@
+package migration
+
+import (
+	"context"
+
+	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/pkg/logs"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// blindIndex 为历史用户和单位补齐codeIndex及phoneIndex, 使唯一索引覆盖全部数据
+// 只补齐盲索引, 不重新加密字段, 重新加密由重新加密任务完成; 逻辑固定在迁移内, 不随mapper的修改而变化
+// 仅在code或phone与读取时相同时写入, 不会覆盖并发的修改; 重复执行时只更新缺失或过期的文档
+// 已有重复的Code或手机号时迁移失败, 需要人工处理重复数据后重试
+var blindIndex = &Migration{
+	Version: 4,
+	Name:    "blind_index",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		keyring, err := crypto.NewKeyring(config.GetConfig())
+		if err != nil {
+			return err
+		}
+		users, err := backfillBlindIndex(ctx, db.Collection("user"), keyring, "code", "codeIndex")
+		if err != nil {
+			return err
+		}
+		units, err := backfillBlindIndex(ctx, db.Collection("unit"), keyring, "phone", "phoneIndex")
+		if err != nil {
+			return err
+		}
+		logs.Infof("backfill blind index, users=%d, units=%d", users, units)
+		return nil
+	},
+}
+
+// backfillBlindIndex 解密field后计算盲索引写入indexField, 返回更新的文档数
+func backfillBlindIndex(ctx context.Context, coll *mongo.Collection, keyring *crypto.Keyring, field, indexField string) (int, error) {
+	cursor, err := coll.Find(ctx, bson.M{field: bson.M{"$exists": true}}, options.Find().SetProjection(bson.M{field: 1, indexField: 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	count := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err = cursor.Decode(&doc); err != nil {
+			return count, err
+		}
+		id, _ := doc["_id"].(bson.ObjectID)
+		stored, _ := doc[field].(string)
+		if stored == "" {
+			continue
+		}
+		value, err := keyring.Decrypt(stored, crypto.AAD(coll.Name(), field, id.Hex()))
+		if err != nil {
+			return count, err
+		}
+		index := keyring.BlindIndex(value)
+		if current, _ := doc[indexField].(string); current == index {
+			continue
+		}
+		if _, err = coll.UpdateOne(ctx, bson.M{"_id": id, field: stored},
+			bson.M{"$set": bson.M{indexField: index}, "$inc": bson.M{"version": int64(1)}}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, cursor.Err()
+}
@@ -0,0 +1,26 @@
+package age
+
+import "time"
+
+const (
+	Adult  = 18  // 成年年龄
+	MaxAge = 120 // 可信的最大年龄
+)
+
+// Of 根据出生日期(秒级时间戳)计算now时的周岁
+func Of(birth int64, now time.Time) int {
+	b := time.Unix(birth, 0).In(now.Location())
+	years := now.Year() - b.Year()
+	if now.Month() < b.Month() || (now.Month() == b.Month() && now.Day() < b.Day()) {
+		years--
+	}
+	return years
+}
+
+// Valid 出生日期不能晚于now, 年龄也不能超过MaxAge
+func Valid(birth int64, now time.Time) bool {
+	if time.Unix(birth, 0).After(now) {
+		return false
+	}
+	return Of(birth, now) <= MaxAge
+}
@@ -1,11 +1,26 @@
 package convert
 
 import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+const optionField = "选项"
+
+// Anypb2Any 将protobuf Any转换为可存入bson的值
+// Struct -> map[string]any, ListValue -> []any, Timestamp -> time.Time, BytesValue -> []byte
+// 无符号整数统一存为int64, 读回时为Int64Value, 超过MaxInt64的uint64与其他无法存储的类型一样返回ErrUnsupportedType
 func Anypb2Any(req map[string]*anypb.Any) (map[string]any, error) {
 	res := make(map[string]any)
 	for k, v := range req {
@@ -21,12 +36,36 @@ func Anypb2Any(req map[string]*anypb.Any) (map[string]any, error) {
 			res[k] = m.Value
 		case *wrapperspb.Int64Value:
 			res[k] = m.Value
+		case *wrapperspb.UInt32Value:
+			res[k] = int64(m.Value)
+		case *wrapperspb.UInt64Value:
+			// bson无法编码超过MaxInt64的uint64, 与UInt32Value一样以int64存储
+			if m.Value > math.MaxInt64 {
+				return nil, unsupported(k, m.Value)
+			}
+			res[k] = int64(m.Value)
 		case *wrapperspb.FloatValue:
 			res[k] = m.Value
 		case *wrapperspb.DoubleValue:
 			res[k] = m.Value
 		case *wrapperspb.BoolValue:
 			res[k] = m.Value
+		case *wrapperspb.BytesValue:
+			// 空字节反序列化后为nil, 避免存为null
+			if m.Value == nil {
+				m.Value = []byte{}
+			}
+			res[k] = m.Value
+		case *timestamppb.Timestamp:
+			res[k] = m.AsTime()
+		case *structpb.Struct:
+			res[k] = m.AsMap()
+		case *structpb.ListValue:
+			res[k] = m.AsSlice()
+		case *structpb.Value:
+			res[k] = m.AsInterface()
+		default:
+			return nil, unsupported(k, msg)
 		}
 	}
 	return res, nil
@@ -36,7 +75,7 @@ func Any2Anypb(req map[string]any) (map[string]*anypb.Any, error) {
 	res := make(map[string]*anypb.Any)
 
 	for k, v := range req {
-		anyVal, err := Wrap(v)
+		anyVal, err := wrap(k, v)
 		if err != nil {
 			return nil, err
 		}
@@ -46,9 +85,16 @@ func Any2Anypb(req map[string]any) (map[string]*anypb.Any, error) {
 	return res, nil
 }
 
+// Wrap 将Go值(包括从bson中读出的值)转换为protobuf Any
 func Wrap(v any) (*anypb.Any, error) {
+	return wrap(optionField, v)
+}
+
+func wrap(field string, v any) (*anypb.Any, error) {
 	var msg proto.Message
 	switch val := v.(type) {
+	case nil:
+		msg = structpb.NewNullValue()
 	case string:
 		msg = wrapperspb.String(val)
 	case int:
@@ -57,12 +103,103 @@ func Wrap(v any) (*anypb.Any, error) {
 		msg = wrapperspb.Int32(val)
 	case int64:
 		msg = wrapperspb.Int64(val)
+	case uint32:
+		msg = wrapperspb.UInt32(val)
+	case uint64:
+		msg = wrapperspb.UInt64(val)
 	case float32:
 		msg = wrapperspb.Float(val)
 	case float64:
 		msg = wrapperspb.Double(val)
 	case bool:
 		msg = wrapperspb.Bool(val)
+	case []byte:
+		msg = wrapperspb.Bytes(val)
+	case primitive.Binary:
+		msg = wrapperspb.Bytes(val.Data)
+	case time.Time:
+		msg = timestamppb.New(val)
+	case primitive.DateTime:
+		msg = timestamppb.New(val.Time())
+	case map[string]any, primitive.M, primitive.D, []any, primitive.A:
+		value, err := toValue(field, val)
+		if err != nil {
+			return nil, err
+		}
+		if s := value.GetStructValue(); s != nil {
+			msg = s
+		} else {
+			msg = value.GetListValue()
+		}
+	default:
+		return nil, unsupported(field, v)
 	}
 	return anypb.New(msg)
 }
+
+// toValue 递归地将嵌套值转换为structpb.Value
+// structpb无法区分整数与浮点数, 嵌套的数字统一为float64, 字节和时间分别以base64和RFC3339字符串表示
+func toValue(field string, v any) (*structpb.Value, error) {
+	switch val := v.(type) {
+	case nil:
+		return structpb.NewNullValue(), nil
+	case string:
+		return structpb.NewStringValue(val), nil
+	case bool:
+		return structpb.NewBoolValue(val), nil
+	case int:
+		return structpb.NewNumberValue(float64(val)), nil
+	case int32:
+		return structpb.NewNumberValue(float64(val)), nil
+	case int64:
+		return structpb.NewNumberValue(float64(val)), nil
+	case uint32:
+		return structpb.NewNumberValue(float64(val)), nil
+	case uint64:
+		return structpb.NewNumberValue(float64(val)), nil
+	case float32:
+		return structpb.NewNumberValue(float64(val)), nil
+	case float64:
+		return structpb.NewNumberValue(val), nil
+	case []byte:
+		return structpb.NewStringValue(base64.StdEncoding.EncodeToString(val)), nil
+	case primitive.Binary:
+		return structpb.NewStringValue(base64.StdEncoding.EncodeToString(val.Data)), nil
+	case time.Time:
+		return structpb.NewStringValue(val.UTC().Format(time.RFC3339Nano)), nil
+	case primitive.DateTime:
+		return structpb.NewStringValue(val.Time().UTC().Format(time.RFC3339Nano)), nil
+	case primitive.D:
+		return toValue(field, val.Map())
+	case primitive.M:
+		return toValue(field, map[string]any(val))
+	case map[string]any:
+		fields := make(map[string]*structpb.Value, len(val))
+		for k, item := range val {
+			value, err := toValue(field+"."+k, item)
+			if err != nil {
+				return nil, err
+			}
+			fields[k] = value
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields}), nil
+	case primitive.A:
+		return toValue(field, []any(val))
+	case []any:
+		values := make([]*structpb.Value, 0, len(val))
+		for i, item := range val {
+			value, err := toValue(fmt.Sprintf("%s[%d]", field, i), item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: values}), nil
+	default:
+		return nil, unsupported(field, v)
+	}
+}
+
+func unsupported(field string, v any) error {
+	return errorx.New(errno.ErrUnsupportedType, errorx.KV("field", field), errorx.KVf("type", "%T", v))
+}
@@ -0,0 +1,204 @@
+package convert
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xh-polaris/psych-profile/pkg/errorx"
+	"github.com/xh-polaris/psych-profile/types/errno"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// roundTrip 模拟options写入mongo后再读出: Anypb -> bson -> Anypb
+func roundTrip(t *testing.T, in proto.Message) proto.Message {
+	t.Helper()
+	a, err := anypb.New(in)
+	require.NoError(t, err)
+
+	stored, err := Anypb2Any(map[string]*anypb.Any{"k": a})
+	require.NoError(t, err)
+
+	raw, err := bson.Marshal(bson.M{"options": stored})
+	require.NoError(t, err)
+	var doc struct {
+		Options map[string]any `bson:"options"`
+	}
+	require.NoError(t, bson.Unmarshal(raw, &doc))
+
+	out, err := Any2Anypb(doc.Options)
+	require.NoError(t, err)
+	msg, err := out["k"].UnmarshalNew()
+	require.NoError(t, err)
+	return msg
+}
+
+func mustStruct(t *testing.T, m map[string]any) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(m)
+	require.NoError(t, err)
+	return s
+}
+
+func mustList(t *testing.T, l []any) *structpb.ListValue {
+	t.Helper()
+	v, err := structpb.NewList(l)
+	require.NoError(t, err)
+	return v
+}
+
+func TestRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC)
+
+	tests := []struct {
+		name string
+		in   proto.Message
+		want proto.Message
+	}{
+		{"string", wrapperspb.String("a"), wrapperspb.String("a")},
+		{"int32", wrapperspb.Int32(-3), wrapperspb.Int32(-3)},
+		{"int64", wrapperspb.Int64(math.MaxInt64), wrapperspb.Int64(math.MaxInt64)},
+		// 无符号整数以int64存储, 读回为Int64Value
+		{"uint32", wrapperspb.UInt32(math.MaxUint32), wrapperspb.Int64(math.MaxUint32)},
+		{"uint64", wrapperspb.UInt64(math.MaxInt64), wrapperspb.Int64(math.MaxInt64)},
+		{"double", wrapperspb.Double(1.5), wrapperspb.Double(1.5)},
+		{"bool", wrapperspb.Bool(true), wrapperspb.Bool(true)},
+		{"bytes", wrapperspb.Bytes([]byte{0, 1, 0xff}), wrapperspb.Bytes([]byte{0, 1, 0xff})},
+		{"empty bytes", wrapperspb.Bytes([]byte{}), wrapperspb.Bytes([]byte{})},
+		// mongo以毫秒精度存储时间
+		{"timestamp", timestamppb.New(ts), timestamppb.New(ts)},
+		{
+			"struct",
+			mustStruct(t, map[string]any{"s": "x", "b": false, "n": nil}),
+			mustStruct(t, map[string]any{"s": "x", "b": false, "n": nil}),
+		},
+		{
+			// 嵌套的数字读回时为float64
+			"struct with numbers",
+			mustStruct(t, map[string]any{"i": 1, "f": 2.5}),
+			mustStruct(t, map[string]any{"i": float64(1), "f": 2.5}),
+		},
+		{
+			"nested struct",
+			mustStruct(t, map[string]any{"a": map[string]any{"b": []any{"c", 1}}}),
+			mustStruct(t, map[string]any{"a": map[string]any{"b": []any{"c", float64(1)}}}),
+		},
+		{
+			"list",
+			mustList(t, []any{"a", true, nil, 3}),
+			mustList(t, []any{"a", true, nil, float64(3)}),
+		},
+		{
+			"nested list",
+			mustList(t, []any{[]any{1, 2}, map[string]any{"k": "v"}}),
+			mustList(t, []any{[]any{float64(1), float64(2)}, map[string]any{"k": "v"}}),
+		},
+		{"empty list", mustList(t, []any{}), mustList(t, []any{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, tt.in)
+			assert.True(t, proto.Equal(tt.want, got), "want %v, got %v", tt.want, got)
+		})
+	}
+}
+
+func TestAnypb2AnyUInt64Overflow(t *testing.T) {
+	a, err := anypb.New(wrapperspb.UInt64(math.MaxInt64 + 1))
+	require.NoError(t, err)
+	_, err = Anypb2Any(map[string]*anypb.Any{"k": a})
+	var se errorx.StatusError
+	require.True(t, errors.As(err, &se), "got %v", err)
+	assert.EqualValues(t, errno.ErrUnsupportedType, se.Code())
+}
+
+func TestAnypb2AnyUnsupported(t *testing.T) {
+	a, err := anypb.New(&anypb.Any{})
+	require.NoError(t, err)
+	_, err = Anypb2Any(map[string]*anypb.Any{"k": a})
+	assert.Error(t, err)
+}
+
+// 嵌套在struct中的时间和字节以字符串表示
+func TestWrapNested(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	a, err := Wrap(map[string]any{"t": ts, "b": []byte("hi"), "u": uint64(7)})
+	require.NoError(t, err)
+	msg, err := a.UnmarshalNew()
+	require.NoError(t, err)
+	want := mustStruct(t, map[string]any{"t": "2024-01-02T03:04:05Z", "b": "aGk=", "u": float64(7)})
+	assert.True(t, proto.Equal(want, msg), "got %v", msg)
+}
+
+func TestWrapUnsupported(t *testing.T) {
+	_, err := Wrap(struct{}{})
+	assert.Error(t, err)
+	_, err = Wrap(map[string]any{"c": make(chan int)})
+	assert.Error(t, err)
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestGolden 记录每种类型落库时的Go值及读回后的消息类型, 落库格式变化会导致已有数据无法按原类型读回
+func TestGolden(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC)
+	tests := []struct {
+		name string
+		in   proto.Message
+	}{
+		{"string", wrapperspb.String("a")},
+		{"int32", wrapperspb.Int32(-3)},
+		{"int64", wrapperspb.Int64(math.MaxInt64)},
+		{"uint32", wrapperspb.UInt32(math.MaxUint32)},
+		{"uint64", wrapperspb.UInt64(math.MaxInt64)},
+		{"float", wrapperspb.Float(0.5)},
+		{"double", wrapperspb.Double(1.5)},
+		{"bool", wrapperspb.Bool(true)},
+		{"bytes", wrapperspb.Bytes([]byte{0, 1, 0xff})},
+		{"empty bytes", wrapperspb.Bytes(nil)},
+		{"timestamp", timestamppb.New(ts)},
+		{"struct", mustStruct(t, map[string]any{"s": "x", "n": 1, "l": []any{true, nil}})},
+		{"list", mustList(t, []any{"a", 2.5, map[string]any{"k": "v"}})},
+		{"value", structpb.NewStringValue("v")},
+		// 无法存储的值返回错误码
+		{"uint64 overflow", wrapperspb.UInt64(math.MaxInt64 + 1)},
+		{"unsupported", &anypb.Any{}},
+	}
+
+	var b strings.Builder
+	for _, tt := range tests {
+		a, err := anypb.New(tt.in)
+		require.NoError(t, err)
+		stored, err := Anypb2Any(map[string]*anypb.Any{"k": a})
+		if err != nil {
+			var se errorx.StatusError
+			require.True(t, errors.As(err, &se), "got %v", err)
+			fmt.Fprintf(&b, "%s\terror\t%d\n", tt.name, se.Code())
+			continue
+		}
+		out := roundTrip(t, tt.in)
+		fmt.Fprintf(&b, "%s\t%T\t%#v\t%s\n", tt.name, stored["k"], stored["k"], proto.MessageName(out))
+	}
+
+	path := filepath.Join("testdata", "convert.golden")
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), b.String(), "run go test -update to accept the new format")
+}
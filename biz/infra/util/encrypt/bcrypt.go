@@ -2,7 +2,9 @@ package encrypt
 
 import (
 	"sync"
+	"time"
 
+	"github.com/xh-polaris/psych-profile/biz/infra/metrics"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -11,6 +13,7 @@ var once sync.Once
 
 // BcryptEncrypt Bcrypt加密函数
 func BcryptEncrypt(password string) (string, error) {
+	defer metrics.ObserveBcrypt("hash", time.Now())
 	// 使用 bcrypt 生成哈希值
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -21,6 +24,7 @@ func BcryptEncrypt(password string) (string, error) {
 
 // BcryptCheck Bcrypt校验函数
 func BcryptCheck(password string, hash string) bool {
+	defer metrics.ObserveBcrypt("check", time.Now())
 	// 检查密码是否匹配
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
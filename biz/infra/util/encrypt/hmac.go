@@ -0,0 +1,16 @@
+package encrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const pseudonymLength = 32 // 截取的十六进制字符数
+
+// Pseudonym 使用HMAC-SHA256为单位内的用户生成稳定的假名, 没有key无法反推出用户
+func Pseudonym(key, unitId, userId string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(unitId + ":" + userId))
+	return hex.EncodeToString(mac.Sum(nil))[:pseudonymLength]
+}
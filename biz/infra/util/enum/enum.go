@@ -2,8 +2,9 @@ package enum
 
 // status
 const (
-	Active  = 0
-	Deleted = 1
+	Active    = 0
+	Deleted   = 1
+	Graduated = 2 // 已毕业, 账号保留但不再属于在读学生
 )
 
 // gender
@@ -25,9 +26,43 @@ const (
 	ConfigTypeEnd2End = 1
 )
 
+// role
+const (
+	RoleStudent   = 0
+	RoleCounselor = 1
+	RoleAdmin     = 2
+)
+
+// contact type
+const (
+	ContactTypeGuardian  = 0
+	ContactTypeEmergency = 1
+)
+
+// consent type
+const (
+	ConsentTypeAICounseling   = 0
+	ConsentTypeRecording      = 1
+	ConsentTypeDataProcessing = 2
+)
+
+// acceptor
+const (
+	AcceptorSelf     = 0
+	AcceptorGuardian = 1
+)
+
+// feature
+const (
+	FeatureChat   = 0
+	FeatureTTS    = 1
+	FeatureReport = 2
+)
+
 var statusMap = map[string]int{
-	"active":  Active,
-	"deleted": Deleted,
+	"active":    Active,
+	"deleted":   Deleted,
+	"graduated": Graduated,
 }
 
 var genderMap = map[string]int{
@@ -46,9 +81,38 @@ var configTypeMap = map[string]int{
 	"end2end": ConfigTypeEnd2End,
 }
 
+var roleMap = map[string]int{
+	"student":   RoleStudent,
+	"counselor": RoleCounselor,
+	"admin":     RoleAdmin,
+}
+
+var contactTypeMap = map[string]int{
+	"guardian":  ContactTypeGuardian,
+	"emergency": ContactTypeEmergency,
+}
+
+var consentTypeMap = map[string]int{
+	"ai_counseling":   ConsentTypeAICounseling,
+	"recording":       ConsentTypeRecording,
+	"data_processing": ConsentTypeDataProcessing,
+}
+
+var acceptorMap = map[string]int{
+	"self":     AcceptorSelf,
+	"guardian": AcceptorGuardian,
+}
+
+var featureMap = map[string]int{
+	"chat":   FeatureChat,
+	"tts":    FeatureTTS,
+	"report": FeatureReport,
+}
+
 var statusMapReverse = map[int]string{
-	Active:  "active",
-	Deleted: "deleted",
+	Active:    "active",
+	Deleted:   "deleted",
+	Graduated: "graduated",
 }
 
 var genderMapReverse = map[int]string{
@@ -66,3 +130,31 @@ var configTypeMapReverse = map[int]string{
 	ConfigTypeChain:   "chain",
 	ConfigTypeEnd2End: "end2end",
 }
+
+var roleMapReverse = map[int]string{
+	RoleStudent:   "student",
+	RoleCounselor: "counselor",
+	RoleAdmin:     "admin",
+}
+
+var contactTypeMapReverse = map[int]string{
+	ContactTypeGuardian:  "guardian",
+	ContactTypeEmergency: "emergency",
+}
+
+var consentTypeMapReverse = map[int]string{
+	ConsentTypeAICounseling:   "ai_counseling",
+	ConsentTypeRecording:      "recording",
+	ConsentTypeDataProcessing: "data_processing",
+}
+
+var acceptorMapReverse = map[int]string{
+	AcceptorSelf:     "self",
+	AcceptorGuardian: "guardian",
+}
+
+var featureMapReverse = map[int]string{
+	FeatureChat:   "chat",
+	FeatureTTS:    "tts",
+	FeatureReport: "report",
+}
@@ -0,0 +1,102 @@
+package enum
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// enums 所有枚举的名称到取值及取值到名称的映射
+// 取值会落库并通过事件发给订阅方, 只能新增, 不能修改已有的取值
+var enums = []struct {
+	name    string
+	values  map[string]int
+	reverse map[int]string
+}{
+	{"status", statusMap, statusMapReverse},
+	{"gender", genderMap, genderMapReverse},
+	{"codeType", codeTypeMap, codeTypeMapReverse},
+	{"configType", configTypeMap, configTypeMapReverse},
+	{"role", roleMap, roleMapReverse},
+	{"contactType", contactTypeMap, contactTypeMapReverse},
+	{"consentType", consentTypeMap, consentTypeMapReverse},
+	{"acceptor", acceptorMap, acceptorMapReverse},
+	{"feature", featureMap, featureMapReverse},
+}
+
+func TestReverse(t *testing.T) {
+	for _, e := range enums {
+		t.Run(e.name, func(t *testing.T) {
+			assert.Len(t, e.reverse, len(e.values))
+			for name, v := range e.values {
+				assert.Equal(t, name, e.reverse[v], "value %d", v)
+			}
+		})
+	}
+}
+
+func TestGolden(t *testing.T) {
+	var b strings.Builder
+	for _, e := range enums {
+		names := make([]string, 0, len(e.values))
+		for name := range e.values {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return e.values[names[i]] < e.values[names[j]] })
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s %s %d\n", e.name, name, e.values[name])
+		}
+	}
+
+	path := filepath.Join("testdata", "enum.golden")
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), b.String(), "run go test -update to accept new values")
+}
+
+func TestParseAndGet(t *testing.T) {
+	tests := []struct {
+		parse func(string) (int, bool)
+		get   func(int) (string, bool)
+		name  string
+		value int
+	}{
+		{ParseStatus, GetStatus, "graduated", Graduated},
+		{ParseGender, GetGender, "female", Female},
+		{ParseCodeType, GetCodeType, "studentId", CodeTypeStudentID},
+		{ParseConfigType, GetConfigType, "end2end", ConfigTypeEnd2End},
+		{ParseRole, GetRole, "admin", RoleAdmin},
+		{ParseContactType, GetContactType, "emergency", ContactTypeEmergency},
+		{ParseConsentType, GetConsentType, "data_processing", ConsentTypeDataProcessing},
+		{ParseAcceptor, GetAcceptor, "guardian", AcceptorGuardian},
+		{ParseFeature, GetFeature, "report", FeatureReport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := tt.parse(tt.name)
+			assert.True(t, ok)
+			assert.Equal(t, tt.value, v)
+			name, ok := tt.get(tt.value)
+			assert.True(t, ok)
+			assert.Equal(t, tt.name, name)
+
+			_, ok = tt.parse("unknown-" + tt.name)
+			assert.False(t, ok)
+			_, ok = tt.get(-1)
+			assert.False(t, ok)
+		})
+	}
+}
@@ -20,6 +20,31 @@ func ParseConfigType(configType string) (int, bool) {
 	return val, ok
 }
 
+func ParseRole(role string) (int, bool) {
+	val, ok := roleMap[role]
+	return val, ok
+}
+
+func ParseContactType(contactType string) (int, bool) {
+	val, ok := contactTypeMap[contactType]
+	return val, ok
+}
+
+func ParseConsentType(consentType string) (int, bool) {
+	val, ok := consentTypeMap[consentType]
+	return val, ok
+}
+
+func ParseAcceptor(acceptor string) (int, bool) {
+	val, ok := acceptorMap[acceptor]
+	return val, ok
+}
+
+func ParseFeature(feature string) (int, bool) {
+	val, ok := featureMap[feature]
+	return val, ok
+}
+
 func GetStatus(status int) (string, bool) {
 	val, ok := statusMapReverse[status]
 	return val, ok
@@ -39,3 +64,28 @@ func GetConfigType(configType int) (string, bool) {
 	val, ok := configTypeMapReverse[configType]
 	return val, ok
 }
+
+func GetRole(role int) (string, bool) {
+	val, ok := roleMapReverse[role]
+	return val, ok
+}
+
+func GetContactType(contactType int) (string, bool) {
+	val, ok := contactTypeMapReverse[contactType]
+	return val, ok
+}
+
+func GetConsentType(consentType int) (string, bool) {
+	val, ok := consentTypeMapReverse[consentType]
+	return val, ok
+}
+
+func GetAcceptor(acceptor int) (string, bool) {
+	val, ok := acceptorMapReverse[acceptor]
+	return val, ok
+}
+
+func GetFeature(feature int) (string, bool) {
+	val, ok := featureMapReverse[feature]
+	return val, ok
+}
@@ -0,0 +1,73 @@
+package meta
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 调用方通过kitex metainfo透传的键
+const (
+	KeyActor   = "actor"
+	KeyRole    = "role"    // 由网关鉴权后写入的调用方角色 student | counselor | admin
+	KeyVersion = "version" // 请求中为期望的版本号, 响应中为当前的版本号
+	KeyView    = "view"    // 查询用户时的视图, redacted时只返回假名和不可识别身份的属性
+)
+
+// ViewRedacted 脱敏视图, 供下游分析及AI服务使用
+const ViewRedacted = "redacted"
+
+// Actor 获得发起请求的操作人, 未透传时返回unknown
+func Actor(ctx context.Context) string {
+	if actor, ok := metainfo.GetPersistentValue(ctx, KeyActor); ok && actor != "" {
+		return actor
+	}
+	if actor, ok := metainfo.GetValue(ctx, KeyActor); ok && actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+// Role 获得经网关鉴权的调用方角色, 未透传时返回空串
+func Role(ctx context.Context) string {
+	if role, ok := metainfo.GetPersistentValue(ctx, KeyRole); ok {
+		return role
+	}
+	role, _ := metainfo.GetValue(ctx, KeyRole)
+	return role
+}
+
+// View 获得调用方请求的视图, 未透传时返回空串
+func View(ctx context.Context) string {
+	if view, ok := metainfo.GetPersistentValue(ctx, KeyView); ok {
+		return view
+	}
+	view, _ := metainfo.GetValue(ctx, KeyView)
+	return view
+}
+
+// Version 获得调用方期望的版本号, 未透传或不合法时ok为false
+func Version(ctx context.Context) (int64, bool) {
+	v, ok := metainfo.GetValue(ctx, KeyVersion)
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(v, 10, 64)
+	return version, err == nil
+}
+
+// SetVersion 通过反向透传将实体的当前版本号返回给调用方
+func SetVersion(ctx context.Context, version int64) {
+	metainfo.SendBackwardValue(ctx, KeyVersion, strconv.FormatInt(version, 10))
+}
+
+// TraceID 获得当前请求的链路ID, 未开启链路追踪时返回空串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
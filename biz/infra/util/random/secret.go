@@ -0,0 +1,17 @@
+package random
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const secretSize = 32 // 密钥字节数
+
+// GenerateSecret 随机生成一个十六进制编码的密钥
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
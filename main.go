@@ -1,40 +1,173 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"net"
+	"net/http"
 
 	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	"github.com/cloudwego/kitex/server"
 	"github.com/kitex-contrib/obs-opentelemetry/tracing"
-	"github.com/xh-polaris/gopkg/kitex/middleware"
 	"github.com/xh-polaris/psych-idl/kitex_gen/profile/psychprofileservice"
+	"github.com/xh-polaris/psych-profile/biz/adaptor/middleware"
 	"github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/event"
+	"github.com/xh-polaris/psych-profile/biz/infra/graceful"
+	"github.com/xh-polaris/psych-profile/biz/infra/health"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
 	"github.com/xh-polaris/psych-profile/pkg/logs"
 	"github.com/xh-polaris/psych-profile/provider"
+	"github.com/zeromicro/go-zero/core/logx"
 )
 
 func main() {
 	klog.SetLogger(logs.NewKlogLogger())
-	s, err := provider.NewProvider()
+	jobName := flag.String("job", "", "执行一次性任务后退出: reencrypt | migrate | index")
+	flag.Parse()
+	if *jobName != "" {
+		runJob(*jobName)
+		return
+	}
+
+	app, err := provider.NewProvider()
 	if err != nil {
 		panic(err)
 	}
-	addr, err := net.ResolveTCPAddr("tcp", config.GetConfig().ListenOn)
+	setLogger()
+	c := config.GetConfig()
+
+	// 健康检查独立于rpc端口, 开始监听前及关闭过程中就绪检查返回不可用
+	checker := health.GetChecker()
+	healthSvr := health.NewServer(c.Health.ListenOn, checker)
+	go func() {
+		if err := healthSvr.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logs.Errorf("health server error: %s", err)
+		}
+	}()
+
+	// 数据迁移及索引创建完成后才开始监听rpc端口, 索引依赖迁移后的字段
+	// 多个实例同时启动时只有一个执行迁移, 其余实例等待其完成
+	// 内存实现在创建时已生效唯一索引, 不需要迁移
+	if c.Storage == config.StorageMongo && c.Mongo.AutoMigrate {
+		if err = app.Migrator.Run(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+	if c.Storage == config.StorageMongo && c.Mongo.AutoIndex {
+		if err = app.Index.Run(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+	config.StartReload()
+	// 事件投递在排空时停止, 未投递的事件保留在outbox中由下次启动继续投递
+	event.GetDispatcher().Start()
+	event.GetDeliverer().Start()
+
+	addr, err := net.ResolveTCPAddr("tcp", c.ListenOn)
 	if err != nil {
 		panic(err)
 	}
 	svr := psychprofileservice.NewServer(
-		s,
+		app.Server,
 		server.WithServiceAddr(addr),
+		server.WithExitWaitTime(c.Drain.Timeout),
 		server.WithSuite(tracing.NewServerSuite()),
 		server.WithServerBasicInfo(&rpcinfo.EndpointBasicInfo{ServiceName: config.GetConfig().Name}),
-		server.WithMiddleware(middleware.LogMiddleware(config.GetConfig().Name)),
+		server.WithMiddleware(middleware.MetricMiddleware),
+		server.WithMiddleware(middleware.LogMiddleware),
+		server.WithMiddleware(middleware.DrainMiddleware),
 	)
 
+	server.RegisterStartHook(func() { checker.SetReady(true) })
+	// 收到SIGTERM或SIGINT后kitex关闭监听并在ExitWaitTime内等待连接上的请求结束
+	server.RegisterShutdownHook(func() {
+		checker.SetReady(false)
+		graceful.Drain(c.Drain.Timeout)
+	})
+
 	err = svr.Run()
 
 	if err != nil {
 		logs.Error(err.Error())
 	}
+	shutdown(healthSvr)
+}
+
+// shutdown 等待剩余的请求及后台任务, 然后关闭健康检查、断开mongo并刷新日志
+// 指标由Prometheus拉取, 没有需要刷新的缓冲
+func shutdown(healthSvr *http.Server) {
+	c := config.GetConfig()
+	graceful.Drain(c.Drain.Timeout)
+	graceful.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Health.Timeout)
+	defer cancel()
+	if err := healthSvr.Shutdown(ctx); err != nil {
+		logs.Errorf("shutdown health server error: %s", err)
+	}
+	if c.Storage == config.StorageMongo {
+		if err := mapper.Disconnect(ctx, c.Mongo.URL); err != nil {
+			logs.Errorf("disconnect mongo error: %s", err)
+		}
+	}
+	logs.Info("server stopped")
+	_ = logx.Close()
+}
+
+// setLogger 按配置选择日志格式, json时输出结构化日志, 日志级别随配置热更新
+func setLogger() {
+	c := config.GetConfig()
+	if c.Log.Encoding == "json" {
+		l := logs.NewJSONLogger(c.Name)
+		logs.SetLogger(l)
+		klog.SetLogger(logs.NewJSONKlogLogger(l))
+	}
+	config.Watch(func(c *config.Config) string { return c.Log.Level }, func(level string) {
+		lv, zlv := logs.LevelInfo, uint32(logx.InfoLevel)
+		switch level {
+		case "debug":
+			lv, zlv = logs.LevelDebug, logx.DebugLevel
+		case "error":
+			lv, zlv = logs.LevelError, logx.ErrorLevel
+		case "severe":
+			lv, zlv = logs.LevelFatal, logx.SevereLevel
+		}
+		logs.SetLevel(lv)
+		klog.SetLevel(klog.Level(lv))
+		logx.SetLevel(zlv)
+	})
+}
+
+func runJob(name string) {
+	switch name {
+	case "reencrypt":
+		j, err := provider.NewReencryptJob()
+		if err != nil {
+			panic(err)
+		}
+		if err = j.Run(context.Background()); err != nil {
+			panic(err)
+		}
+	case "migrate":
+		r, err := provider.NewMigrator()
+		if err != nil {
+			panic(err)
+		}
+		if err = r.Run(context.Background()); err != nil {
+			panic(err)
+		}
+	case "index":
+		j, err := provider.NewIndexJob()
+		if err != nil {
+			panic(err)
+		}
+		if err = j.Run(context.Background()); err != nil {
+			panic(err)
+		}
+	default:
+		panic("unknown job: " + name)
+	}
 }
@@ -0,0 +1,26 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+)
+
+type fieldsKey struct{}
+
+// WithFields 在ctx上附加日志键值, kv按键、值交替传入, 结构化日志会输出这些键值
+func WithFields(ctx context.Context, kv ...any) context.Context {
+	parent := fieldsFrom(ctx)
+	fields := make(map[string]any, len(parent)+len(kv)/2)
+	for k, v := range parent {
+		fields[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+func fieldsFrom(ctx context.Context) map[string]any {
+	fields, _ := ctx.Value(fieldsKey{}).(map[string]any)
+	return fields
+}
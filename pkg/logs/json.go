@@ -0,0 +1,220 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	_ FullLogger      = (*JSONLogger)(nil)
+	_ klog.FullLogger = (*JSONKlogLogger)(nil)
+)
+
+var levelNames = []string{"trace", "debug", "info", "notice", "warn", "error", "fatal"}
+
+func (lv Level) name() string {
+	if lv >= LevelTrace && lv <= LevelFatal {
+		return levelNames[lv]
+	}
+	return strconv.Itoa(int(lv))
+}
+
+// JSONLogger 每行输出一个json对象的结构化日志
+// 固定字段为level, time, caller, service, msg, 带ctx时额外输出trace_id, span_id以及WithFields附加的键值
+type JSONLogger struct {
+	mu      sync.Mutex
+	out     io.Writer
+	level   Level
+	service string
+}
+
+func NewJSONLogger(service string) *JSONLogger {
+	return &JSONLogger{out: os.Stderr, level: LevelInfo, service: service}
+}
+
+func (l *JSONLogger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
+func (l *JSONLogger) SetLevel(lv Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = lv
+}
+
+// output 与defaultLogger一致, 调用深度4对应包级函数的调用方
+func (l *JSONLogger) output(ctx context.Context, lv Level, format *string, v ...interface{}) {
+	l.mu.Lock()
+	level := l.level
+	l.mu.Unlock()
+	if level > lv {
+		return
+	}
+
+	entry := map[string]any{
+		"level":   lv.name(),
+		"time":    time.Now().Format(time.RFC3339Nano),
+		"service": l.service,
+	}
+	if _, file, line, ok := runtime.Caller(4); ok {
+		entry["caller"] = filepath.Base(filepath.Dir(file)) + "/" + filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			entry["trace_id"] = sc.TraceID().String()
+			entry["span_id"] = sc.SpanID().String()
+		}
+		for k, val := range fieldsFrom(ctx) {
+			if _, reserved := entry[k]; !reserved {
+				entry[k] = val
+			}
+		}
+	}
+	if format != nil {
+		entry["msg"] = fmt.Sprintf(*format, v...)
+	} else {
+		entry["msg"] = fmt.Sprint(v...)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]any{"level": lv.name(), "msg": fmt.Sprintf("marshal log entry error: %v", err)})
+	}
+	l.mu.Lock()
+	_, _ = l.out.Write(append(data, '\n'))
+	l.mu.Unlock()
+	if lv == LevelFatal {
+		os.Exit(1)
+	}
+}
+
+func (l *JSONLogger) logf(lv Level, format *string, v ...interface{}) {
+	l.output(nil, lv, format, v...)
+}
+
+func (l *JSONLogger) logfCtx(ctx context.Context, lv Level, format *string, v ...interface{}) {
+	l.output(ctx, lv, format, v...)
+}
+
+func (l *JSONLogger) CondError(cond bool, format string, v ...interface{}) {
+	if cond {
+		l.logf(LevelError, &format, v...)
+	}
+}
+
+func (l *JSONLogger) CondErrorf(cond bool, format string, v ...interface{}) {
+	if cond {
+		l.logf(LevelError, &format, v...)
+	}
+}
+
+func (l *JSONLogger) Fatal(v ...interface{}) {
+	l.logf(LevelFatal, nil, v...)
+}
+
+func (l *JSONLogger) Error(v ...interface{}) {
+	l.logf(LevelError, nil, v...)
+}
+
+func (l *JSONLogger) Warn(v ...interface{}) {
+	l.logf(LevelWarn, nil, v...)
+}
+
+func (l *JSONLogger) Notice(v ...interface{}) {
+	l.logf(LevelNotice, nil, v...)
+}
+
+func (l *JSONLogger) Info(v ...interface{}) {
+	l.logf(LevelInfo, nil, v...)
+}
+
+func (l *JSONLogger) Debug(v ...interface{}) {
+	l.logf(LevelDebug, nil, v...)
+}
+
+func (l *JSONLogger) Trace(v ...interface{}) {
+	l.logf(LevelTrace, nil, v...)
+}
+
+func (l *JSONLogger) Fatalf(format string, v ...interface{}) {
+	l.logf(LevelFatal, &format, v...)
+}
+
+func (l *JSONLogger) Errorf(format string, v ...interface{}) {
+	l.logf(LevelError, &format, v...)
+}
+
+func (l *JSONLogger) Warnf(format string, v ...interface{}) {
+	l.logf(LevelWarn, &format, v...)
+}
+
+func (l *JSONLogger) Noticef(format string, v ...interface{}) {
+	l.logf(LevelNotice, &format, v...)
+}
+
+func (l *JSONLogger) Infof(format string, v ...interface{}) {
+	l.logf(LevelInfo, &format, v...)
+}
+
+func (l *JSONLogger) Debugf(format string, v ...interface{}) {
+	l.logf(LevelDebug, &format, v...)
+}
+
+func (l *JSONLogger) Tracef(format string, v ...interface{}) {
+	l.logf(LevelTrace, &format, v...)
+}
+
+func (l *JSONLogger) CtxFatalf(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelFatal, &format, v...)
+}
+
+func (l *JSONLogger) CtxErrorf(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelError, &format, v...)
+}
+
+func (l *JSONLogger) CtxWarnf(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelWarn, &format, v...)
+}
+
+func (l *JSONLogger) CtxNoticef(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelNotice, &format, v...)
+}
+
+func (l *JSONLogger) CtxInfof(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelInfo, &format, v...)
+}
+
+func (l *JSONLogger) CtxDebugf(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelDebug, &format, v...)
+}
+
+func (l *JSONLogger) CtxTracef(ctx context.Context, format string, v ...interface{}) {
+	l.logfCtx(ctx, LevelTrace, &format, v...)
+}
+
+// JSONKlogLogger 将JSONLogger适配为kitex的klog.FullLogger
+type JSONKlogLogger struct {
+	*JSONLogger
+}
+
+func NewJSONKlogLogger(l *JSONLogger) *JSONKlogLogger {
+	return &JSONKlogLogger{l}
+}
+
+// SetLevel klog与logs的级别取值一致
+func (l *JSONKlogLogger) SetLevel(lv klog.Level) {
+	l.JSONLogger.SetLevel(Level(lv))
+}
@@ -0,0 +1,154 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const mask = "******"
+
+// secretFields 凭据类字段, 完全脱敏, 以小写的json字段名匹配
+var secretFields = map[string]bool{
+	"password":    true,
+	"newpassword": true,
+	"verifycode":  true,
+	"appid":       true,
+	"token":       true,
+	"secret":      true,
+}
+
+// personalFields 个人信息字段, 完全脱敏
+var personalFields = map[string]bool{
+	"name":         true,
+	"birth":        true,
+	"relation":     true,
+	"acceptorname": true,
+	"contact":      true, // 单位联系人
+}
+
+// phoneFields 手机号字段, 保留前3位和后4位
+var phoneFields = map[string]bool{
+	"phone":  true,
+	"code":   true, // 用户的手机号或学号
+	"authid": true,
+}
+
+// Policy 某类消息在字段名规则之外的脱敏配置, 字段路径为以点号连接的json字段名, 数组不需要下标
+type Policy struct {
+	Allow []string // 与规则同名但不含个人信息的字段, 如单位名称
+	Deny  []string // 规则无法识别但需要完全脱敏的字段
+}
+
+var policies sync.Map // reflect.Type -> *compiledPolicy
+
+type compiledPolicy struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// RegisterPolicy 为消息类型注册脱敏配置, msg可以是值或指针
+func RegisterPolicy(msg any, p Policy) {
+	c := &compiledPolicy{allow: map[string]bool{}, deny: map[string]bool{}}
+	for _, f := range p.Allow {
+		c.allow[strings.ToLower(f)] = true
+	}
+	for _, f := range p.Deny {
+		c.deny[strings.ToLower(f)] = true
+	}
+	policies.Store(typeOf(msg), c)
+}
+
+// Redact 将消息序列化为脱敏后的json, 用于日志输出
+func Redact(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "<unmarshalable>"
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err = dec.Decode(&doc); err != nil {
+		return "<unmarshalable>"
+	}
+
+	p := &compiledPolicy{}
+	if c, ok := policies.Load(typeOf(v)); ok {
+		p = c.(*compiledPolicy)
+	}
+	doc = redact(doc, "", p)
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return "<unmarshalable>"
+	}
+	return string(out)
+}
+
+func redact(v any, path string, p *compiledPolicy) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, field := range val {
+			val[k] = redactField(k, field, join(path, strings.ToLower(k)), p)
+		}
+		return val
+	case []any:
+		for i := range val {
+			val[i] = redact(val[i], path, p)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+func redactField(key string, v any, path string, p *compiledPolicy) any {
+	if p.deny[path] {
+		return mask
+	}
+	if p.allow[path] {
+		return redact(v, path, p)
+	}
+	name := strings.ToLower(key)
+	switch {
+	case secretFields[name], personalFields[name]:
+		if v == nil {
+			return nil
+		}
+		return mask
+	case phoneFields[name]:
+		if s, ok := v.(string); ok {
+			return MaskPhone(s)
+		}
+	}
+	return redact(v, path, p)
+}
+
+// MaskPhone 部分脱敏手机号, 保留前3位和后4位, 过短时完全脱敏
+func MaskPhone(phone string) string {
+	if phone == "" {
+		return ""
+	}
+	r := []rune(phone)
+	if len(r) < 8 {
+		return mask
+	}
+	return string(r[:3]) + strings.Repeat("*", len(r)-7) + string(r[len(r)-4:])
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeOf(v any) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
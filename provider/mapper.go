@@ -0,0 +1,96 @@
+package provider
+
+import (
+	infraconfig "github.com/xh-polaris/psych-profile/biz/infra/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/acceptance"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/audit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/consent"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/delivery"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/outbox"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/revision"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/mapper/webhook"
+)
+
+// 按Storage选择mapper的实现, 内存实现只在进程内保存数据
+
+func NewUserMapper(c *infraconfig.Config, keyring *crypto.Keyring) user.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return user.NewMemoryMapper(keyring)
+	}
+	return user.NewMongoMapper(c, keyring)
+}
+
+func NewUnitMapper(c *infraconfig.Config, keyring *crypto.Keyring) unit.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return unit.NewMemoryMapper(keyring)
+	}
+	return unit.NewMongoMapper(c, keyring)
+}
+
+func NewConfigMapper(c *infraconfig.Config) config.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return config.NewMemoryMapper()
+	}
+	return config.NewMongoMapper(c)
+}
+
+func NewConsentMapper(c *infraconfig.Config) consent.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return consent.NewMemoryMapper()
+	}
+	return consent.NewMongoMapper(c)
+}
+
+func NewAcceptanceMapper(c *infraconfig.Config) acceptance.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return acceptance.NewMemoryMapper()
+	}
+	return acceptance.NewMongoMapper(c)
+}
+
+func NewAuditMapper(c *infraconfig.Config) audit.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return audit.NewMemoryMapper()
+	}
+	return audit.NewMongoMapper(c)
+}
+
+func NewOutboxMapper(c *infraconfig.Config) outbox.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return outbox.NewMemoryMapper()
+	}
+	return outbox.NewMongoMapper(c)
+}
+
+func NewWebhookMapper(c *infraconfig.Config, keyring *crypto.Keyring) webhook.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return webhook.NewMemoryMapper(keyring)
+	}
+	return webhook.NewMongoMapper(c, keyring)
+}
+
+func NewDeliveryMapper(c *infraconfig.Config) delivery.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return delivery.NewMemoryMapper()
+	}
+	return delivery.NewMongoMapper(c)
+}
+
+func NewRevisionMapper(c *infraconfig.Config, keyring *crypto.Keyring) revision.IMongoMapper {
+	if c.Storage == infraconfig.StorageMemory {
+		return revision.NewMemoryMapper(keyring)
+	}
+	return revision.NewMongoMapper(c, keyring)
+}
+
+func NewTransactor(c *infraconfig.Config) mapper.Transactor {
+	if c.Storage == infraconfig.StorageMemory {
+		return mapper.NewMemoryTransactor()
+	}
+	return mapper.NewMongoTransactor(c)
+}
@@ -2,14 +2,25 @@ package provider
 
 import (
 	"github.com/google/wire"
+	"github.com/xh-polaris/psych-profile/biz/adaptor"
 	"github.com/xh-polaris/psych-profile/biz/adaptor/controller"
+	"github.com/xh-polaris/psych-profile/biz/application/job"
 	"github.com/xh-polaris/psych-profile/biz/application/service"
 	infraconfig "github.com/xh-polaris/psych-profile/biz/infra/config"
-	"github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
-	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
-	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/event"
+	"github.com/xh-polaris/psych-profile/biz/infra/health"
+	"github.com/xh-polaris/psych-profile/biz/infra/migration"
 )
 
+// App rpc服务、健康检查及启动时执行的迁移和索引任务, 共用同一组mapper
+type App struct {
+	Server   *adaptor.Server
+	Health   *health.Checker
+	Migrator *migration.Runner
+	Index    *job.Index
+}
+
 var ControllerSet = wire.NewSet(
 	controller.UserControllerSet,
 	controller.UnitControllerSet,
@@ -20,21 +31,63 @@ var ApplicationSet = wire.NewSet(
 	service.UserServiceSet,
 	service.UnitServiceSet,
 	service.ConfigServiceSet,
+	service.ConsentServiceSet,
+	service.PrivacyServiceSet,
+	service.AuditServiceSet,
+	service.EventServiceSet,
+	service.WebhookServiceSet,
+	service.RevisionServiceSet,
+	service.HealthServiceSet,
 )
 
 var MapperSet = wire.NewSet(
-	user.NewMongoMapper,
-	unit.NewMongoMapper,
-	config.NewMongoMapper,
+	NewUserMapper,
+	NewUnitMapper,
+	NewConfigMapper,
+	NewConsentMapper,
+	NewAcceptanceMapper,
+	NewAuditMapper,
+	NewOutboxMapper,
+	NewWebhookMapper,
+	NewDeliveryMapper,
+	NewRevisionMapper,
+	NewTransactor,
 )
 
 var InfraSet = wire.NewSet(
 	infraconfig.NewConfig,
+	crypto.NewKeyring,
 	MapperSet,
 )
 
+var ServerInfraSet = wire.NewSet(
+	InfraSet,
+	health.NewChecker,
+	event.NewDispatcher,
+	event.NewDeliverer,
+)
+
 var ServerProvider = wire.NewSet(
+	wire.Struct(new(App), "*"),
+	wire.Struct(new(adaptor.Server), "*"),
 	ControllerSet,
 	ApplicationSet,
+	ServerInfraSet,
+	migration.NewRunner,
+	job.IndexSet,
+)
+
+var ReencryptProvider = wire.NewSet(
+	job.ReencryptSet,
 	InfraSet,
 )
+
+var IndexProvider = wire.NewSet(
+	job.IndexSet,
+	InfraSet,
+)
+
+var MigrateProvider = wire.NewSet(
+	migration.NewRunner,
+	infraconfig.NewConfig,
+)
@@ -5,13 +5,34 @@ package provider
 
 import (
 	"github.com/google/wire"
-	"github.com/xh-polaris/psych-profile/biz/adaptor"
+	"github.com/xh-polaris/psych-profile/biz/application/job"
+	"github.com/xh-polaris/psych-profile/biz/infra/migration"
 )
 
-func NewProvider() (*adaptor.Server, error) {
+func NewProvider() (*App, error) {
 	wire.Build(
-		wire.Struct(new(adaptor.Server), "*"),
 		ServerProvider,
 	)
 	return nil, nil
 }
+
+func NewReencryptJob() (*job.Reencrypt, error) {
+	wire.Build(
+		ReencryptProvider,
+	)
+	return nil, nil
+}
+
+func NewIndexJob() (*job.Index, error) {
+	wire.Build(
+		IndexProvider,
+	)
+	return nil, nil
+}
+
+func NewMigrator() (*migration.Runner, error) {
+	wire.Build(
+		MigrateProvider,
+	)
+	return nil, nil
+}
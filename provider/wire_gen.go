@@ -9,39 +9,76 @@ package provider
 import (
 	"github.com/xh-polaris/psych-profile/biz/adaptor"
 	"github.com/xh-polaris/psych-profile/biz/adaptor/controller"
+	"github.com/xh-polaris/psych-profile/biz/application/job"
 	"github.com/xh-polaris/psych-profile/biz/application/service"
 	"github.com/xh-polaris/psych-profile/biz/infra/config"
-	config2 "github.com/xh-polaris/psych-profile/biz/infra/mapper/config"
-	"github.com/xh-polaris/psych-profile/biz/infra/mapper/unit"
-	"github.com/xh-polaris/psych-profile/biz/infra/mapper/user"
+	"github.com/xh-polaris/psych-profile/biz/infra/crypto"
+	"github.com/xh-polaris/psych-profile/biz/infra/event"
+	"github.com/xh-polaris/psych-profile/biz/infra/health"
+	"github.com/xh-polaris/psych-profile/biz/infra/migration"
 )
 
 // Injectors from wire.go:
 
-func NewProvider() (*adaptor.Server, error) {
+func NewProvider() (*App, error) {
 	configConfig, err := config.NewConfig()
 	if err != nil {
 		return nil, err
 	}
-	iMongoMapper := user.NewMongoMapper(configConfig)
-	unitIMongoMapper := unit.NewMongoMapper(configConfig)
+	keyring, err := crypto.NewKeyring(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	iMongoMapper := NewUserMapper(configConfig, keyring)
+	unitIMongoMapper := NewUnitMapper(configConfig, keyring)
+	transactor := NewTransactor(configConfig)
+	auditIMongoMapper := NewAuditMapper(configConfig)
+	auditService := &service.AuditService{
+		AuditMapper: auditIMongoMapper,
+	}
+	outboxIMongoMapper := NewOutboxMapper(configConfig)
+	webhookIMongoMapper := NewWebhookMapper(configConfig, keyring)
+	deliveryIMongoMapper := NewDeliveryMapper(configConfig)
+	deliverer := event.NewDeliverer(configConfig, webhookIMongoMapper, deliveryIMongoMapper)
+	dispatcher := event.NewDispatcher(configConfig, outboxIMongoMapper, deliverer)
+	eventService := &service.EventService{
+		OutboxMapper: outboxIMongoMapper,
+		Dispatcher:   dispatcher,
+	}
 	userService := &service.UserService{
-		UserMapper: iMongoMapper,
-		UnitMapper: unitIMongoMapper,
+		UserMapper:   iMongoMapper,
+		UnitMapper:   unitIMongoMapper,
+		Transactor:   transactor,
+		AuditService: auditService,
+		EventService: eventService,
 	}
 	userController := &controller.UserController{
 		UserService: userService,
 	}
+	configIMongoMapper := NewConfigMapper(configConfig)
+	revisionIMongoMapper := NewRevisionMapper(configConfig, keyring)
+	revisionService := &service.RevisionService{
+		RevisionMapper: revisionIMongoMapper,
+	}
 	unitService := &service.UnitService{
-		UnitMapper: unitIMongoMapper,
-		UserMapper: iMongoMapper,
+		UnitMapper:      unitIMongoMapper,
+		UserMapper:      iMongoMapper,
+		ConfigMapper:    configIMongoMapper,
+		Transactor:      transactor,
+		AuditService:    auditService,
+		EventService:    eventService,
+		RevisionService: revisionService,
 	}
 	unitController := &controller.UnitController{
 		UnitService: unitService,
 	}
-	configIMongoMapper := config2.NewMongoMapper(configConfig)
 	configService := &service.ConfigService{
-		ConfigMapper: configIMongoMapper,
+		ConfigMapper:    configIMongoMapper,
+		RevisionMapper:  revisionIMongoMapper,
+		Transactor:      transactor,
+		AuditService:    auditService,
+		EventService:    eventService,
+		RevisionService: revisionService,
 	}
 	configController := &controller.ConfigController{
 		ConfigService: configService,
@@ -51,5 +88,91 @@ func NewProvider() (*adaptor.Server, error) {
 		IUnitController:   unitController,
 		IConfigController: configController,
 	}
-	return server, nil
+	consentIMongoMapper := NewConsentMapper(configConfig)
+	acceptanceIMongoMapper := NewAcceptanceMapper(configConfig)
+	checker, err := health.NewChecker(configConfig, iMongoMapper, unitIMongoMapper, configIMongoMapper, consentIMongoMapper, acceptanceIMongoMapper, auditIMongoMapper, outboxIMongoMapper, webhookIMongoMapper, deliveryIMongoMapper, revisionIMongoMapper)
+	if err != nil {
+		return nil, err
+	}
+	runner := migration.NewRunner(configConfig)
+	index := &job.Index{
+		UserMapper:       iMongoMapper,
+		UnitMapper:       unitIMongoMapper,
+		ConfigMapper:     configIMongoMapper,
+		ConsentMapper:    consentIMongoMapper,
+		AcceptanceMapper: acceptanceIMongoMapper,
+		AuditMapper:      auditIMongoMapper,
+		OutboxMapper:     outboxIMongoMapper,
+		WebhookMapper:    webhookIMongoMapper,
+		DeliveryMapper:   deliveryIMongoMapper,
+		RevisionMapper:   revisionIMongoMapper,
+	}
+	app := &App{
+		Server:   server,
+		Health:   checker,
+		Migrator: runner,
+		Index:    index,
+	}
+	return app, nil
+}
+
+func NewReencryptJob() (*job.Reencrypt, error) {
+	configConfig, err := config.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := crypto.NewKeyring(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	iMongoMapper := NewUserMapper(configConfig, keyring)
+	unitIMongoMapper := NewUnitMapper(configConfig, keyring)
+	reencrypt := &job.Reencrypt{
+		UserMapper: iMongoMapper,
+		UnitMapper: unitIMongoMapper,
+	}
+	return reencrypt, nil
+}
+
+func NewIndexJob() (*job.Index, error) {
+	configConfig, err := config.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := crypto.NewKeyring(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	iMongoMapper := NewUserMapper(configConfig, keyring)
+	unitIMongoMapper := NewUnitMapper(configConfig, keyring)
+	configIMongoMapper := NewConfigMapper(configConfig)
+	consentIMongoMapper := NewConsentMapper(configConfig)
+	acceptanceIMongoMapper := NewAcceptanceMapper(configConfig)
+	auditIMongoMapper := NewAuditMapper(configConfig)
+	outboxIMongoMapper := NewOutboxMapper(configConfig)
+	webhookIMongoMapper := NewWebhookMapper(configConfig, keyring)
+	deliveryIMongoMapper := NewDeliveryMapper(configConfig)
+	revisionIMongoMapper := NewRevisionMapper(configConfig, keyring)
+	index := &job.Index{
+		UserMapper:       iMongoMapper,
+		UnitMapper:       unitIMongoMapper,
+		ConfigMapper:     configIMongoMapper,
+		ConsentMapper:    consentIMongoMapper,
+		AcceptanceMapper: acceptanceIMongoMapper,
+		AuditMapper:      auditIMongoMapper,
+		OutboxMapper:     outboxIMongoMapper,
+		WebhookMapper:    webhookIMongoMapper,
+		DeliveryMapper:   deliveryIMongoMapper,
+		RevisionMapper:   revisionIMongoMapper,
+	}
+	return index, nil
+}
+
+func NewMigrator() (*migration.Runner, error) {
+	configConfig, err := config.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	runner := migration.NewRunner(configConfig)
+	return runner, nil
 }
@@ -17,6 +17,11 @@ const (
 	ErrInternalError          = 1008
 	ErrPhoneAlreadyExist      = 1009
 	ErrWrongPassword          = 1010
+	ErrUnsupportedType        = 1011
+	ErrPermissionDenied       = 1012
+	ErrServiceUnavailable     = 1013
+	ErrWeakPassword           = 1014
+	ErrVersionConflict        = 1015
 )
 
 func init() {
@@ -75,4 +80,29 @@ func init() {
 		"密码错误",
 		code.WithAffectStability(false),
 	)
+	code.Register(
+		ErrUnsupportedType,
+		"{field}的类型{type}不受支持",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrPermissionDenied,
+		"无权访问{field}",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrServiceUnavailable,
+		"服务正在重启, 请稍后重试",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrWeakPassword,
+		"密码{reason}",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrVersionConflict,
+		"数据已被他人修改, 请刷新后重试",
+		code.WithAffectStability(false),
+	)
 }
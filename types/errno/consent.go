@@ -0,0 +1,28 @@
+package errno
+
+import "github.com/xh-polaris/psych-profile/pkg/errorx/code"
+
+// Consent 错误码 5000 开始
+const (
+	ErrConsentVersionOutdated = 5000
+	ErrConsentNotPublished    = 5001
+	ErrConsentVersionConflict = 5002
+)
+
+func init() {
+	code.Register(
+		ErrConsentVersionOutdated,
+		"同意书已更新, 请阅读最新版本后重新签署",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrConsentNotPublished,
+		"单位尚未发布{field}",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrConsentVersionConflict,
+		"同意书已被他人同时发布, 请刷新后重试",
+		code.WithAffectStability(false),
+	)
+}
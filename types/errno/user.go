@@ -6,6 +6,8 @@ import "github.com/xh-polaris/psych-profile/pkg/errorx/code"
 
 const (
 	ErrStudentIDAlreadyExist = 3000
+	ErrInvalidBirth          = 3001
+	ErrBelowMinimumAge       = 3002
 )
 
 func init() {
@@ -14,4 +16,14 @@ func init() {
 		"学号已被注册",
 		code.WithAffectStability(false),
 	)
+	code.Register(
+		ErrInvalidBirth,
+		"出生日期不合法",
+		code.WithAffectStability(false),
+	)
+	code.Register(
+		ErrBelowMinimumAge,
+		"未满{age}岁, 暂不能使用",
+		code.WithAffectStability(false),
+	)
 }